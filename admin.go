@@ -43,6 +43,32 @@ func (self *AdminHandler) registerAdminRoutes() (*gin.RouterGroup) {
     aAuth := r.Group("/admin")
     aAuth.Use( self.NeedAdminAuth( self.authHandler ) )
     aAuth.GET("/", self.showAdminRoot )
+    aAuth.GET("/tokens", self.showTokens )
+    aAuth.POST("/tokens/create", self.handleTokenCreate )
+    aAuth.POST("/tokens/revoke", self.handleTokenRevoke )
+    aAuth.GET("/users", self.showUsers )
+    aAuth.POST("/users/create", self.handleUserCreate )
+    aAuth.POST("/users/role", self.handleUserRole )
+    aAuth.POST("/users/password", self.handleUserPassword )
+    aAuth.POST("/users/disable", self.handleUserDisable )
+    aAuth.GET("/registration", self.showRegistration )
+    aAuth.POST("/registration/password", self.handleRegPass )
+    aAuth.POST("/registration/open", self.handleRegOpen )
+    aAuth.POST("/registration/tokens/create", self.handleRegTokenCreate )
+    aAuth.POST("/registration/tokens/revoke", self.handleRegTokenRevoke )
+    aAuth.GET("/providers", self.showProviders )
+    aAuth.POST("/providers/rotate", self.handleProviderRotate )
+    aAuth.POST("/providers/disable", self.handleProviderDisable )
+    aAuth.POST("/providers/cert", self.handleProviderCert )
+    aAuth.POST("/providers/cert/revoke", self.handleProviderCertRevoke )
+    aAuth.POST("/providers/token", self.handleProviderToken )
+    aAuth.GET("/usage", self.showUsage )
+    aAuth.GET("/usage/export", self.handleUsageExport )
+    aAuth.GET("/audit", self.showAudit )
+    aAuth.GET("/audit/export", self.handleAuditExport )
+    aAuth.GET("/videos", self.showVideos )
+    aAuth.GET("/videos/download", self.handleVideoDownload )
+    aAuth.POST("/videos/delete", self.handleVideoDelete )
     return aAuth
 }
 
@@ -50,6 +76,25 @@ func (self *AdminHandler) NeedAdminAuth( authHandler adminauth.AuthHandler ) gin
     return func( c *gin.Context ) {
         sCtx := self.sessionManager.GetSession( c )
         
+        if token := bearerToken( c ); token != "" {
+            dbToken := checkApiToken( c, token, true )
+            if dbToken == nil {
+                return
+            }
+            role := tokenRole( self.config, dbToken )
+            if !roleHas( role, PermAdmin ) {
+                c.AbortWithStatusJSON( http.StatusForbidden, SApiError{
+                    Success: false,
+                    Err:     "admin_role_required",
+                } )
+                return
+            }
+            self.sessionManager.session.Put( sCtx, "admin", dbToken.User )
+            c.Set( "role", role )
+            c.Next()
+            return
+        }
+        
         loginI := self.sessionManager.session.Get( sCtx, "admin" )
         
         if loginI == nil {
@@ -62,10 +107,19 @@ func (self *AdminHandler) NeedAdminAuth( authHandler adminauth.AuthHandler ) gin
             c.Abort()
             fmt.Println("admin user fail")
             return
-        } else {
-            fmt.Println("admin user ok")
         }
         
+        // Admins let in by the auth module aren't in the user table
+        role := userRole( loginI.(string), RoleAdmin )
+        if !roleHas( role, PermAdmin ) {
+            self.sessionManager.session.Remove( sCtx, "admin" )
+            self.sessionManager.WriteSession( c )
+            c.Redirect( 302, "/admin/login" )
+            c.Abort()
+            return
+        }
+        c.Set( "role", role )
+        
         c.Next()
     }
 }
@@ -173,17 +227,18 @@ func (self *AdminHandler) handleAdminLogin( c *gin.Context ) {
     user := c.PostForm("user")
     pass := c.PostForm("pass")
     
-    if user == "ok" && pass == "ok" {
-        fmt.Printf("admin login ok\n")
-        
-        self.sessionManager.session.Put( s, "admin", "test" )
-        self.sessionManager.WriteSession( c )
-        
-        c.Redirect( 302, "/admin/" )
+    dbUser, reason := checkUserLogin( user, pass )
+    if dbUser != nil && !roleHas( dbUser.Role, PermAdmin ) {
+        dbUser, reason = nil, "not_admin"
+    }
+    if dbUser == nil {
+        recordUserLoginFail( c, user, reason, true )
+        self.showAdminLogin( c )
         return
-    } else {
-        fmt.Printf("admin login failed\n")
     }
     
-    self.showAdminLogin( c )
+    self.sessionManager.session.Put( s, "admin", dbUser.Username )
+    self.sessionManager.WriteSession( c )
+    
+    c.Redirect( 302, "/admin/" )
 }
\ No newline at end of file
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const apiTokenPrefix = "cf_"
+
+// Only a hash of each token is stored; the token itself is shown once at creation
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createApiToken(user string, name string, admin bool) (string, *DbApiToken) {
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+
+	dbToken := &DbApiToken{
+		Name:    name,
+		User:    user,
+		Admin:   admin,
+		Prefix:  token[:len(apiTokenPrefix)+8],
+		Hash:    hashApiToken(token),
+		Created: time.Now(),
+	}
+	addApiToken(dbToken)
+
+	log.WithFields(log.Fields{
+		"type":  "api_token_add",
+		"id":    dbToken.Id,
+		"user":  user,
+		"name":  name,
+		"admin": admin,
+	}).Info("Created api token")
+
+	return token, dbToken
+}
+
+// Fetch the bearer token of a request; empty if the request doesn't carry one
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// Check the bearer token of a request, aborting with 401 or 403 if it
+// is unknown, revoked, or lacks the admin flag an admin route needs
+func checkApiToken(c *gin.Context, token string, needAdmin bool) *DbApiToken {
+	dbToken := getApiTokenByHash(hashApiToken(token))
+	if dbToken == nil || dbToken.Revoked {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, SApiError{
+			Success: false,
+			Err:     "invalid_token",
+		})
+		return nil
+	}
+	if needAdmin && !dbToken.Admin {
+		c.AbortWithStatusJSON(http.StatusForbidden, SApiError{
+			Success: false,
+			Err:     "admin_token_required",
+		})
+		return nil
+	}
+
+	touchApiToken(dbToken.Id)
+	return dbToken
+}
+
+type STokenRow struct {
+	Id       int64
+	Name     string
+	User     string
+	Admin    bool
+	Prefix   string
+	Created  string
+	LastUsed string
+	Revoked  bool
+}
+
+func formatTokenTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func (self *AdminHandler) showApiTokens(c *gin.Context, newToken string) {
+	tokens, err := getApiTokens()
+	if err != nil {
+		panic(err)
+	}
+
+	rows := []STokenRow{}
+	for _, token := range tokens {
+		rows = append(rows, STokenRow{
+			Id:       token.Id,
+			Name:     token.Name,
+			User:     token.User,
+			Admin:    token.Admin,
+			Prefix:   token.Prefix,
+			Created:  formatTokenTime(token.Created),
+			LastUsed: formatTokenTime(token.LastUsed),
+			Revoked:  token.Revoked,
+		})
+	}
+
+	c.HTML(http.StatusOK, "adminTokens", gin.H{
+		"tokens":      rows,
+		"newToken":    newToken,
+		"deviceVideo": self.config.text.deviceVideo,
+	})
+}
+
+// @Summary Admin - API token list
+// @Router /admin/tokens [GET]
+func (self *AdminHandler) showTokens(c *gin.Context) {
+	self.showApiTokens(c, "")
+}
+
+// @Summary Admin - Create API token
+// @Router /admin/tokens/create [POST]
+// @Param user formData string true "User the token acts as"
+// @Param name formData string false "Token description"
+// @Param admin formData string false "Set to allow admin routes"
+func (self *AdminHandler) handleTokenCreate(c *gin.Context) {
+	user := c.PostForm("user")
+	if user == "" {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "user is required",
+		})
+		return
+	}
+
+	token, _ := createApiToken(user, c.PostForm("name"), c.PostForm("admin") != "")
+	self.showApiTokens(c, token)
+}
+
+// @Summary Admin - Revoke API token
+// @Router /admin/tokens/revoke [POST]
+// @Param id formData int true "Token id"
+func (self *AdminHandler) handleTokenRevoke(c *gin.Context) {
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "invalid token id",
+		})
+		return
+	}
+
+	if revokeApiToken(id) {
+		log.WithFields(log.Fields{
+			"type": "api_token_revoke",
+			"id":   id,
+		}).Info("Revoked api token")
+	}
+
+	c.Redirect(http.StatusFound, "/admin/tokens")
+}
+
+func printApiToken(token DbApiToken) {
+	fmt.Printf("Id: %d\nName: %s\nUser: %s\nAdmin: %t\nPrefix: %s\nCreated: %s\nLast used: %s\nRevoked: %t\n\n",
+		token.Id, token.Name, token.User, token.Admin, token.Prefix,
+		formatTokenTime(token.Created), formatTokenTime(token.LastUsed), token.Revoked)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+)
+
+type auditUserKey struct{}
+
+// Tag a provider round-trip with the user behind it; only tagged requests are audited
+func withAuditUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, auditUserKey{}, user)
+}
+
+func auditUser(ctx context.Context) string {
+	user, _ := ctx.Value(auditUserKey{}).(string)
+	return user
+}
+
+const auditRedacted = "[redacted]"
+
+// Message fields holding typed text, blanked when auditRedactText is set
+var auditTextFields = map[string]bool{
+	"text":     true,
+	"keys":     true,
+	"prevkeys": true,
+}
+
+// An audited request that has been sent and is waiting for its result
+type AuditRecord struct {
+	entry *DbAuditEntry
+	start time.Time
+}
+
+type Auditor struct {
+	redactText bool
+	entries    chan *DbAuditEntry
+}
+
+var gAudit *Auditor
+
+// Start the background writer so recording never blocks the provider loops
+func startAuditor(config *Config) {
+	gAudit = &Auditor{
+		redactText: config.auditRedactText,
+		entries:    make(chan *DbAuditEntry, 1000),
+	}
+	go func() {
+		for entry := range gAudit.entries {
+			addAuditEntry(entry)
+		}
+	}()
+}
+
+// Split a provider message into its type, udid, and remaining fields
+func provMessageFields(req ProvBase) (string, string, map[string]string) {
+	var action, udid string
+	params := make(map[string]string)
+
+	root, _, err := uj.ParseFull([]byte(req.asText(0)))
+	if err != nil {
+		return action, udid, params
+	}
+	root.ForEachKeyed(func(key string, val uj.JNode) {
+		switch key {
+		case "id":
+		case "type":
+			action = val.String()
+		case "udid":
+			udid = val.String()
+		default:
+			params[key] = val.String()
+		}
+	})
+	return action, udid, params
+}
+
+// Begin auditing a request; nil if auditing is off or nobody is behind the request
+func (self *Auditor) begin(ctx context.Context, req ProvBase) *AuditRecord {
+	if self == nil {
+		return nil
+	}
+	user := auditUser(ctx)
+	if user == "" {
+		return nil
+	}
+
+	action, udid, params := provMessageFields(req)
+	if self.redactText {
+		for key := range params {
+			if auditTextFields[key] {
+				params[key] = auditRedacted
+			}
+		}
+	}
+	text, _ := json.Marshal(params)
+
+	entry := &DbAuditEntry{
+		Stamp:  time.Now().Unix(),
+		User:   user,
+		Udid:   udid,
+		Action: action,
+		Params: string(text),
+	}
+
+	return &AuditRecord{
+		entry: entry,
+		start: time.Now(),
+	}
+}
+
+// Record the outcome of an audited request; result is "ok" or a request error code
+func (self *Auditor) finish(rec *AuditRecord, result string) {
+	if self == nil || rec == nil {
+		return
+	}
+	rec.entry.Result = result
+	rec.entry.LatencyMs = time.Since(rec.start).Milliseconds()
+
+	select {
+	case self.entries <- rec.entry:
+	default:
+		fmt.Printf("Audit queue full; dropping %s by %s\n", rec.entry.Action, rec.entry.User)
+	}
+}
+
+func auditResult(root uj.JNode) string {
+	if reqErr := resError(root); reqErr != "" {
+		return reqErr
+	}
+	return "ok"
+}
+
+type SAuditEntry struct {
+	Time      string            `json:"time"      example:"2021-06-01 10:00:00"`
+	User      string            `json:"user"      example:"someuser"`
+	Udid      string            `json:"udid"      example:"00008100-001338811EE10033"`
+	Action    string            `json:"action"    example:"click"`
+	Params    map[string]string `json:"params"`
+	Result    string            `json:"result"    example:"ok"`
+	LatencyMs int64             `json:"latencyMs" example:"120"`
+}
+
+func auditFilter(c *gin.Context) (*AuditQuery, error) {
+	query := &AuditQuery{
+		user:   c.Query("user"),
+		udid:   c.Query("udid"),
+		action: c.Query("action"),
+		result: c.Query("result"),
+		limit:  500,
+	}
+	if str := c.Query("from"); str != "" {
+		from, err := time.ParseInLocation(usageDateFormat, str, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date: %s", str)
+		}
+		query.from = from
+	}
+	if str := c.Query("to"); str != "" {
+		to, err := time.ParseInLocation(usageDateFormat, str, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date: %s", str)
+		}
+		query.to = to.AddDate(0, 0, 1)
+	}
+	if str := c.Query("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit: %s", str)
+		}
+		query.limit = limit
+	}
+	return query, nil
+}
+
+func toAuditEntry(entry *DbAuditEntry) SAuditEntry {
+	params := make(map[string]string)
+	json.Unmarshal([]byte(entry.Params), &params)
+	return SAuditEntry{
+		Time:      time.Unix(entry.Stamp, 0).Format("2006-01-02 15:04:05"),
+		User:      entry.User,
+		Udid:      entry.Udid,
+		Action:    entry.Action,
+		Params:    params,
+		Result:    entry.Result,
+		LatencyMs: entry.LatencyMs,
+	}
+}
+
+// @Summary Admin - Audit log
+// @Router /admin/audit [GET]
+// @Param user query string false "User"
+// @Param udid query string false "Device UDID"
+// @Param action query string false "Action, e.g. click or launch"
+// @Param result query string false "ok, timeout, provider_disconnected, ..."
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param limit query int false "Maximum entries; default 500"
+func (self *AdminHandler) showAudit(c *gin.Context) {
+	query, err := auditFilter(c)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error", gin.H{
+			"text": err.Error(),
+		})
+		return
+	}
+
+	entries, err := getAuditEntries(query)
+	if err != nil {
+		panic(err)
+	}
+
+	rows := []SAuditEntry{}
+	for i := range entries {
+		rows = append(rows, toAuditEntry(&entries[i]))
+	}
+
+	c.HTML(http.StatusOK, "adminAudit", gin.H{
+		"entries":     rows,
+		"user":        c.Query("user"),
+		"udid":        c.Query("udid"),
+		"action":      c.Query("action"),
+		"result":      c.Query("result"),
+		"from":        c.Query("from"),
+		"to":          c.Query("to"),
+		"query":       c.Request.URL.RawQuery,
+		"deviceVideo": self.config.text.deviceVideo,
+	})
+}
+
+// @Summary Admin - Export audit log
+// @Description One JSON object per line, oldest first. Takes the same filters as /admin/audit; limit 0 exports everything.
+// @Router /admin/audit/export [GET]
+// @Param user query string false "User"
+// @Param udid query string false "Device UDID"
+// @Param action query string false "Action"
+// @Param result query string false "Result"
+// @Param from query string false "First day, YYYY-MM-DD"
+// @Param to query string false "Last day, YYYY-MM-DD"
+// @Param limit query int false "Maximum entries"
+// @Produce plain
+// @Success 200 {object} SAuditEntry
+func (self *AdminHandler) handleAuditExport(c *gin.Context) {
+	query, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, SApiError{
+			Success: false,
+			Err:     err.Error(),
+		})
+		return
+	}
+	if c.Query("limit") == "" {
+		query.limit = 0
+	}
+	query.oldestFirst = true
+
+	entries, err := getAuditEntries(query)
+	if err != nil {
+		panic(err)
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=audit.jsonl")
+	c.Header("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(c.Writer)
+	for i := range entries {
+		enc.Encode(toAuditEntry(&entries[i]))
+	}
+}
@@ -17,6 +17,17 @@ type ConfigText struct {
     deviceVideo string
 }
 
+// How providers are pinged, and how many missed or slow pings count as
+//   degraded or lost
+type HeartbeatConfig struct {
+    interval         time.Duration
+    timeout          time.Duration
+    history          int
+    degradedMisses   int
+    degradedLatency  time.Duration
+    disconnectMisses int
+}
+
 type Config struct {
     listen      string
     https       bool
@@ -26,7 +37,34 @@ type Config struct {
     adminAuth   string
     root        uj.JNode
     idleTimeout int
+    providerTimeout time.Duration
+    providerGrace time.Duration
+    reservationLease time.Duration
+    auditRedactText bool
     maxHeight   int
+    recordVideo bool
+    videoDir    string
+    videoRetention time.Duration
+    shareSecret string
+    shareAnonymous bool
+    shareTtl    time.Duration
+    shareMaxTtl time.Duration
+    sessionStore string
+    sessionLifetime time.Duration
+    sessionCleanup time.Duration
+    regPass     string
+    regOpen     bool
+    regTokenTtl time.Duration
+    provAuthPassword bool
+    provAuthCert bool
+    provAuthToken bool
+    provCaCrt   string
+    provCaKey   string
+    provCertDays int
+    provTokenSecret string
+    provTokenTtl time.Duration
+    heartbeat   *HeartbeatConfig
+    defaultRole string
     text        *ConfigText
     disableCache bool
     theme       string
@@ -65,6 +103,15 @@ func GetInt( root uj.JNode, path string ) int {
     }
     return node.Int()
 }
+func GetDuration( root uj.JNode, path string ) time.Duration {
+    str := GetStr( root, path )
+    dur, err := time.ParseDuration( str )
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "%s is not a valid duration: %s", path, err )
+        os.Exit(1)
+    }
+    return dur
+}
 
 func NewConfig( configPath string, defaultsPath string ) (*Config) {
     config := Config{
@@ -89,6 +136,11 @@ func NewConfig( configPath string, defaultsPath string ) (*Config) {
         config.idleTimeout = int(dur.Seconds())
     }
     
+    config.providerTimeout = GetDuration( root, "providerTimeout" )
+    config.providerGrace = GetDuration( root, "providerGrace" )
+    config.reservationLease = GetDuration( root, "reservationLease" )
+    config.auditRedactText = GetBool( root, "auditRedactText" )
+    
     authNode := config.root.Get("auth")
     if authNode != nil {
         config.auth = GetStr( authNode, "type" )
@@ -100,6 +152,63 @@ func NewConfig( configPath string, defaultsPath string ) (*Config) {
     }
     
     config.maxHeight = GetInt( root, "video.maxHeight" )
+    config.recordVideo = GetBool( root, "video.record" )
+    config.videoDir = GetStr( root, "video.dir" )
+    config.videoRetention = GetDuration( root, "video.retention" )
+    
+    config.shareSecret = GetStr( root, "share.secret" )
+    config.shareAnonymous = GetBool( root, "share.anonymous" )
+    config.shareTtl = GetDuration( root, "share.ttl" )
+    config.shareMaxTtl = GetDuration( root, "share.maxTtl" )
+    
+    config.sessionStore = GetStr( root, "session.store" )
+    if config.sessionStore != "db" && config.sessionStore != "memory" {
+        fmt.Fprintf( os.Stderr, "session.store must be \"db\" or \"memory\", not \"%s\"\n", config.sessionStore )
+        os.Exit(1)
+    }
+    config.sessionLifetime = GetDuration( root, "session.lifetime" )
+    config.sessionCleanup = GetDuration( root, "session.cleanup" )
+    
+    config.regPass = GetStr( root, "registration.password" )
+    config.regOpen = GetBool( root, "registration.open" )
+    config.regTokenTtl = GetDuration( root, "registration.tokenTtl" )
+    
+    config.provAuthPassword = GetBool( root, "providerAuth.password" )
+    config.provAuthCert = GetBool( root, "providerAuth.cert" )
+    config.provAuthToken = GetBool( root, "providerAuth.token" )
+    if config.provAuthCert && !config.https {
+        fmt.Fprintf( os.Stderr, "providerAuth.cert needs https to be enabled\n" )
+        os.Exit(1)
+    }
+    config.provCaCrt = GetStr( root, "providerAuth.caCrt" )
+    config.provCaKey = GetStr( root, "providerAuth.caKey" )
+    config.provCertDays = GetInt( root, "providerAuth.certDays" )
+    config.provTokenSecret = GetStr( root, "providerAuth.tokenSecret" )
+    config.provTokenTtl = GetDuration( root, "providerAuth.tokenTtl" )
+    
+    config.heartbeat = &HeartbeatConfig{
+        interval:         GetDuration( root, "providerHeartbeat.interval" ),
+        timeout:          GetDuration( root, "providerHeartbeat.timeout" ),
+        history:          GetInt( root, "providerHeartbeat.history" ),
+        degradedMisses:   GetInt( root, "providerHeartbeat.degradedMisses" ),
+        degradedLatency:  GetDuration( root, "providerHeartbeat.degradedLatency" ),
+        disconnectMisses: GetInt( root, "providerHeartbeat.disconnectMisses" ),
+    }
+    if config.heartbeat.interval <= 0 || config.heartbeat.history < 1 {
+        fmt.Fprintf( os.Stderr, "providerHeartbeat.interval and providerHeartbeat.history must be positive\n" )
+        os.Exit(1)
+    }
+    // A ping outliving the interval would overlap the next, piling them up on a slow provider
+    if config.heartbeat.timeout <= 0 || config.heartbeat.timeout > config.heartbeat.interval {
+        fmt.Fprintf( os.Stderr, "providerHeartbeat.timeout must be positive and no longer than providerHeartbeat.interval\n" )
+        os.Exit(1)
+    }
+    
+    config.defaultRole = GetStr( root, "users.defaultRole" )
+    if config.defaultRole != "" && !validRole( config.defaultRole ) {
+        fmt.Fprintf( os.Stderr, "users.defaultRole must be viewer, tester, admin, or empty; not \"%s\"\n", config.defaultRole )
+        os.Exit(1)
+    }
     
     config.text = &ConfigText{
         deviceVideo: GetStr( root, "text.deviceVideo" ),
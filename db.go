@@ -29,6 +29,9 @@ type DbDevice struct {
 	ClickHeight int
 	Ready       string `xorm:"-"`
 	WdaPort     int
+	// Health of the device's provider, for listings; empty when offline
+	ProviderState  string `xorm:"-"`
+	ProviderPingMs int64  `xorm:"-"`
 }
 
 func (DbDevice) TableName() string {
@@ -46,10 +49,112 @@ func (DbReservation) TableName() string {
 	return "reservation"
 }
 
+// Why a reservation ended, as recorded in its history row
+const (
+	ReserveEndReleased     = "released"
+	ReserveEndStopped      = "stopped"
+	ReserveEndIdle         = "idle"
+	ReserveEndStreamClosed = "stream_closed"
+	ReserveEndKicked       = "kicked"
+	ReserveEndExpired      = "expired"
+	ReserveEndReplaced     = "replaced"
+)
+
+// One row per reservation; Reason stays empty while the reservation is held
+type DbReservationHistory struct {
+	Id         int64
+	Udid       string
+	User       string
+	Rid        string `xorm:"index"`
+	ProviderId int64
+	Start      time.Time
+	End        time.Time
+	Reason     string
+}
+
+func (DbReservationHistory) TableName() string {
+	return "reservation_history"
+}
+
+// A user-initiated provider request; Stamp is unix seconds so range queries stay numeric
+type DbAuditEntry struct {
+	Id        int64
+	Stamp     int64  `xorm:"index"`
+	User      string `xorm:"index"`
+	Udid      string `xorm:"index"`
+	Action    string
+	Params    string
+	Result    string
+	LatencyMs int64
+}
+
+func (DbAuditEntry) TableName() string {
+	return "audit"
+}
+
+// An action sent to a device during a reservation; Offset is milliseconds since the reservation began
+type DbRecordedAction struct {
+	Id     int64
+	Rid    string `xorm:"index"`
+	Udid   string
+	User   string
+	Offset int64
+	Action string
+	Params string
+}
+
+func (DbRecordedAction) TableName() string {
+	return "recorded_action"
+}
+
+// Video captured from a device during a reservation; End stays zero while frames are still being written
+type DbVideoRecording struct {
+	Id     int64
+	Rid    string `xorm:"unique"`
+	Udid   string
+	User   string `xorm:"index"`
+	Start  time.Time
+	End    time.Time
+	Frames int64
+	Bytes  int64
+}
+
+func (DbVideoRecording) TableName() string {
+	return "video_recording"
+}
+
+// A login session kept by scs; Expiry is unix seconds
+type DbSession struct {
+	Token  string `xorm:"pk"`
+	Data   []byte
+	Expiry int64 `xorm:"index"`
+}
+
+func (DbSession) TableName() string {
+	return "session"
+}
+
+// A revoked share link, or with a reservation id as Key every link of that
+// session issued before generation Gen; At is unix seconds
+type DbShareRevocation struct {
+	Key string `xorm:"pk"`
+	At  int64  `xorm:"index"`
+	Gen int64
+}
+
+func (DbShareRevocation) TableName() string {
+	return "share_revocation"
+}
+
 type DbProvider struct {
-	Id       int64
-	Username string
-	Password string
+	Id            int64
+	Username      string
+	Password      string // salted hash, see hashProviderPass
+	Disabled      bool
+	PassChanged   time.Time
+	LastLogin     time.Time
+	LoginFailures int
+	LastFailure   time.Time
 }
 
 func (DbProvider) TableName() string {
@@ -57,14 +162,99 @@ func (DbProvider) TableName() string {
 }
 
 type DbConf struct {
-	Id      int64
-	RegPass string
+	Id        int64
+	RegPass   string
+	RegClosed bool
+	// Key signing provider tokens when providerAuth.tokenSecret is unset
+	ProvTokenSecret string
 }
 
 func (DbConf) TableName() string {
 	return "conf"
 }
 
+// A one-time token a single provider host registers with; only its hash is stored
+type DbRegToken struct {
+	Id       int64
+	Note     string
+	Username string // when set, the only provider username the token registers
+	Prefix   string
+	Hash     string `xorm:"index"`
+	Created  time.Time
+	Expires  time.Time
+	Used     bool
+	UsedBy   string
+	UsedAt   time.Time
+	Revoked  bool
+}
+
+func (DbRegToken) TableName() string {
+	return "reg_token"
+}
+
+// A user of the built-in login, used when no auth module is configured
+type DbUser struct {
+	Id        int64
+	Username  string `xorm:"unique"`
+	Password  string // bcrypt hash
+	Role      string
+	Disabled  bool
+	Created   time.Time
+	LastLogin time.Time
+}
+
+func (DbUser) TableName() string {
+	return "user"
+}
+
+// A client certificate issued to a provider by the provider CA
+type DbProviderCert struct {
+	Id         int64
+	ProviderId int64
+	Serial     string `xorm:"index"`
+	Created    time.Time
+	Expires    time.Time
+	Revoked    bool
+}
+
+func (DbProviderCert) TableName() string {
+	return "provider_cert"
+}
+
+type DbApiToken struct {
+	Id       int64
+	Name     string
+	User     string
+	Admin    bool
+	Prefix   string
+	Hash     string `xorm:"unique"`
+	Created  time.Time
+	LastUsed time.Time
+	Revoked  bool
+}
+
+func (DbApiToken) TableName() string {
+	return "api_token"
+}
+
+// A user waiting for a device, either a specific udid or any device matching the pool criteria
+type DbWaiter struct {
+	Id          int64
+	User        string
+	Udid        string
+	ProductType string
+	IosVersion  string
+	Provider    int64
+	Created     time.Time
+	GrantedUdid string
+	Rid         string
+	Granted     time.Time
+}
+
+func (DbWaiter) TableName() string {
+	return "waiter"
+}
+
 func openDb() *xorm.Engine {
 	doesNotExist := false
 	if _, err := os.Stat("db.sqlite3"); os.IsNotExist(err) {
@@ -76,6 +266,13 @@ func openDb() *xorm.Engine {
 		panic(err)
 	}
 
+	// Tables added since the original schema; synced on every open so existing dbs gain them
+	err = engine.Sync2(new(DbApiToken), new(DbWaiter), new(DbReservationHistory), new(DbAuditEntry), new(DbRecordedAction), new(DbVideoRecording), new(DbSession), new(DbShareRevocation), new(DbProvider), new(DbConf), new(DbRegToken), new(DbProviderCert), new(DbUser))
+	if err != nil {
+		panic(err)
+	}
+	hashPlainProviderPasswords(engine)
+
 	if !doesNotExist {
 		return engine
 	}
@@ -103,6 +300,97 @@ func getProvider(username string) *DbProvider {
 	return &provider
 }
 
+func getProviderById(id int64) *DbProvider {
+	var provider DbProvider
+	has, err := gDb.ID(id).Get(&provider)
+	if err != nil || !has {
+		return nil
+	}
+	return &provider
+}
+
+func getProviders() ([]DbProvider, error) {
+	var provs []DbProvider
+	err := gDb.OrderBy("id").Find(&provs)
+	if err != nil {
+		return []DbProvider{}, err
+	}
+	return provs, nil
+}
+
+func setProviderPassword(id int64, hash string) {
+	_, err := gDb.ID(id).Cols("password", "pass_changed").Update(&DbProvider{
+		Password:    hash,
+		PassChanged: time.Now(),
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func setProviderDisabled(id int64, disabled bool) {
+	_, err := gDb.ID(id).Cols("disabled").Update(&DbProvider{Disabled: disabled})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Record a successful login, clearing the count of failed ones
+func noteProviderLogin(id int64) {
+	_, err := gDb.ID(id).Cols("last_login", "login_failures").Update(&DbProvider{LastLogin: time.Now()})
+	if err != nil {
+		fmt.Printf("Error updating provider login: %s\n", err)
+	}
+}
+
+func noteProviderLoginFail(id int64) {
+	_, err := gDb.ID(id).Incr("login_failures").Cols("last_failure").Update(&DbProvider{LastFailure: time.Now()})
+	if err != nil {
+		fmt.Printf("Error updating provider login failures: %s\n", err)
+	}
+}
+
+func addApiToken(token *DbApiToken) {
+	_, err := gDb.Insert(token)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getApiTokenByHash(hash string) *DbApiToken {
+	var token DbApiToken
+	has, err := gDb.Where("hash = ?", hash).Get(&token)
+	if err != nil || !has {
+		return nil
+	}
+	return &token
+}
+
+func getApiTokens() ([]DbApiToken, error) {
+	var tokens []DbApiToken
+	err := gDb.OrderBy("id").Find(&tokens)
+	if err != nil {
+		return []DbApiToken{}, err
+	}
+	return tokens, nil
+}
+
+func revokeApiToken(id int64) bool {
+	affected, err := gDb.ID(id).Cols("revoked").Update(&DbApiToken{Revoked: true})
+	if err != nil {
+		fmt.Printf("Error revoking api token: %s\n", err)
+		return false
+	}
+	return affected > 0
+}
+
+func touchApiToken(id int64) {
+	_, err := gDb.ID(id).Cols("last_used").Update(&DbApiToken{LastUsed: time.Now()})
+	if err != nil {
+		fmt.Printf("Error updating api token last use: %s\n", err)
+	}
+}
+
 func getReservation(udid string) *DbReservation {
 	rv := DbReservation{
 		Udid: udid,
@@ -114,12 +402,15 @@ func getReservation(udid string) *DbReservation {
 	return &rv
 }
 
-func deleteReservation(udid string) {
+func deleteReservation(udid string, reason string) {
 	log.WithFields(log.Fields{
-		"type": "reserve_delete",
-		"udid": censorUuid(udid),
+		"type":   "reserve_delete",
+		"udid":   censorUuid(udid),
+		"reason": reason,
 	}).Info("Deleting device reservation")
 
+	cur := getReservation(udid)
+
 	rv := DbReservation{
 		Udid: udid,
 	}
@@ -128,13 +419,18 @@ func deleteReservation(udid string) {
 		fmt.Printf("Error: %s\n", err)
 		panic("Delete reservation error")
 	}
+
+	if cur != nil {
+		endReservationHistory(cur.Rid, reason)
+	}
 }
 
-func deleteReservationWithRid(udid string, rid string) {
+func deleteReservationWithRid(udid string, rid string, reason string) {
 	log.WithFields(log.Fields{
-		"type": "reserve_delete",
-		"udid": censorUuid(udid),
-		"rid":  rid,
+		"type":   "reserve_delete",
+		"udid":   censorUuid(udid),
+		"rid":    rid,
+		"reason": reason,
 	}).Info("Deleting device reservation")
 
 	rv := DbReservation{
@@ -148,7 +444,9 @@ func deleteReservationWithRid(udid string, rid string) {
 	}
 	if affected == 0 {
 		fmt.Printf("Delete reservation with rid %s; no rows deleted\n", rid)
+		return
 	}
+	endReservationHistory(rid, reason)
 }
 
 func addReservation(udid string, user string, rid string) bool {
@@ -160,18 +458,341 @@ func addReservation(udid string, user string, rid string) bool {
 	}).Info("Adding device reservation")
 
 	rv := DbReservation{
-		Udid: udid,
-		User: user,
-		Rid:  rid,
+		Udid:  udid,
+		User:  user,
+		Rid:   rid,
+		Start: time.Now(),
 	}
 	_, err := gDb.Insert(&rv)
 	if err != nil {
 		fmt.Printf("Error adding reservation: %s\n", err)
 		return false
 	}
+
+	var provId int64
+	if dev := getDevice(udid); dev != nil {
+		provId = dev.ProviderId
+	}
+	_, err = gDb.Insert(&DbReservationHistory{
+		Udid:       udid,
+		User:       user,
+		Rid:        rid,
+		ProviderId: provId,
+		Start:      rv.Start,
+	})
+	if err != nil {
+		fmt.Printf("Error adding reservation history: %s\n", err)
+	}
 	return true
 }
 
+func endReservationHistory(rid string, reason string) {
+	_, err := gDb.Where("rid = ? and reason = ''", rid).Cols("end", "reason").Update(&DbReservationHistory{
+		End:    time.Now(),
+		Reason: reason,
+	})
+	if err != nil {
+		fmt.Printf("Error ending reservation history: %s\n", err)
+	}
+}
+
+func getReservationHistory() ([]DbReservationHistory, error) {
+	var rows []DbReservationHistory
+	err := gDb.OrderBy("id").Find(&rows)
+	if err != nil {
+		return []DbReservationHistory{}, err
+	}
+	return rows, nil
+}
+
+func getReservationHistoryWithRid(rid string) *DbReservationHistory {
+	var row DbReservationHistory
+	has, err := gDb.Where("rid = ?", rid).Get(&row)
+	if err != nil || !has {
+		return nil
+	}
+	return &row
+}
+
+func getUserReservationHistory(user string) ([]DbReservationHistory, error) {
+	var rows []DbReservationHistory
+	err := gDb.Where("user = ?", user).Desc("id").Find(&rows)
+	if err != nil {
+		return []DbReservationHistory{}, err
+	}
+	return rows, nil
+}
+
+func getReservationWithRid(rid string) *DbReservation {
+	var rv DbReservation
+	has, err := gDb.Where("rid = ?", rid).Get(&rv)
+	if err != nil || !has {
+		return nil
+	}
+	return &rv
+}
+
+// Restart the lease of a reservation
+func renewReservation(udid string, rid string) time.Time {
+	start := time.Now()
+	_, err := gDb.Where("udid = ? and rid = ?", udid, rid).Cols("start").Update(&DbReservation{Start: start})
+	if err != nil {
+		fmt.Printf("Error renewing reservation: %s\n", err)
+	}
+	return start
+}
+
+func addWaiter(waiter *DbWaiter) {
+	_, err := gDb.Insert(waiter)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getWaiter(id int64) *DbWaiter {
+	var waiter DbWaiter
+	has, err := gDb.ID(id).Get(&waiter)
+	if err != nil || !has {
+		return nil
+	}
+	return &waiter
+}
+
+// Waiters not yet handed a device, oldest first
+func getQueuedWaiters() ([]DbWaiter, error) {
+	var waiters []DbWaiter
+	err := gDb.Where("rid = ''").OrderBy("id").Find(&waiters)
+	if err != nil {
+		return []DbWaiter{}, err
+	}
+	return waiters, nil
+}
+
+func grantWaiter(id int64, udid string, rid string) {
+	_, err := gDb.ID(id).Cols("granted_udid", "rid", "granted").Update(&DbWaiter{
+		GrantedUdid: udid,
+		Rid:         rid,
+		Granted:     time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("Error granting waiter: %s\n", err)
+	}
+}
+
+func deleteWaiter(id int64) {
+	_, err := gDb.ID(id).Delete(&DbWaiter{})
+	if err != nil {
+		fmt.Printf("Error deleting waiter: %s\n", err)
+	}
+}
+
+// Drop granted waiters once they are old enough that nobody will poll them again
+func purgeGrantedWaiters(before time.Time) {
+	var waiters []DbWaiter
+	err := gDb.Where("rid != ''").Find(&waiters)
+	if err != nil {
+		fmt.Printf("Error purging waiters: %s\n", err)
+		return
+	}
+	for _, waiter := range waiters {
+		if waiter.Granted.Before(before) {
+			deleteWaiter(waiter.Id)
+		}
+	}
+}
+
+func addAuditEntry(entry *DbAuditEntry) {
+	_, err := gDb.Insert(entry)
+	if err != nil {
+		fmt.Printf("Error adding audit entry: %s\n", err)
+	}
+}
+
+func addRecordedAction(action *DbRecordedAction) {
+	_, err := gDb.Insert(action)
+	if err != nil {
+		fmt.Printf("Error adding recorded action: %s\n", err)
+	}
+}
+
+func getRecordedActions(rid string) ([]DbRecordedAction, error) {
+	var actions []DbRecordedAction
+	err := gDb.Where("rid = ?", rid).OrderBy("id").Find(&actions)
+	if err != nil {
+		return []DbRecordedAction{}, err
+	}
+	return actions, nil
+}
+
+func countRecordedActions(rid string) int64 {
+	count, err := gDb.Where("rid = ?", rid).Count(&DbRecordedAction{})
+	if err != nil {
+		fmt.Printf("Error counting recorded actions: %s\n", err)
+	}
+	return count
+}
+
+func addVideoRecording(rec *DbVideoRecording) {
+	_, err := gDb.Insert(rec)
+	if err != nil {
+		fmt.Printf("Error adding video recording: %s\n", err)
+	}
+}
+
+func getVideoRecording(id int64) *DbVideoRecording {
+	var rec DbVideoRecording
+	has, err := gDb.ID(id).Get(&rec)
+	if err != nil || !has {
+		return nil
+	}
+	return &rec
+}
+
+func getVideoRecordingWithRid(rid string) *DbVideoRecording {
+	var rec DbVideoRecording
+	has, err := gDb.Where("rid = ?", rid).Get(&rec)
+	if err != nil || !has {
+		return nil
+	}
+	return &rec
+}
+
+// Video recordings of one user, or of everyone when user is empty; newest first
+func getVideoRecordings(user string) ([]DbVideoRecording, error) {
+	sess := gDb.NewSession()
+	defer sess.Close()
+	if user != "" {
+		sess.Where("user = ?", user)
+	}
+	var recs []DbVideoRecording
+	err := sess.Desc("id").Find(&recs)
+	if err != nil {
+		return []DbVideoRecording{}, err
+	}
+	return recs, nil
+}
+
+func updateVideoRecording(rec *DbVideoRecording) {
+	_, err := gDb.ID(rec.Id).Cols("end", "frames", "bytes").Update(rec)
+	if err != nil {
+		fmt.Printf("Error updating video recording: %s\n", err)
+	}
+}
+
+func deleteVideoRecording(id int64) {
+	_, err := gDb.ID(id).Delete(&DbVideoRecording{})
+	if err != nil {
+		fmt.Printf("Error deleting video recording: %s\n", err)
+	}
+}
+
+func getSession(token string) *DbSession {
+	var session DbSession
+	has, err := gDb.ID(token).Get(&session)
+	if err != nil || !has {
+		return nil
+	}
+	return &session
+}
+
+func saveSession(session *DbSession) error {
+	_, err := gDb.Exec("INSERT OR REPLACE INTO session (token, data, expiry) VALUES (?, ?, ?)",
+		session.Token, session.Data, session.Expiry)
+	return err
+}
+
+func deleteSession(token string) error {
+	_, err := gDb.ID(token).Delete(&DbSession{})
+	return err
+}
+
+// Remove sessions that expired before now; returns how many went
+func deleteExpiredSessions(now time.Time) int64 {
+	count, err := gDb.Where("expiry < ?", now.Unix()).Delete(&DbSession{})
+	if err != nil {
+		fmt.Printf("Error deleting expired sessions: %s\n", err)
+	}
+	return count
+}
+
+// The revocation of a share link or session; nil if there is none
+func getShareRevocation(key string) *DbShareRevocation {
+	var revocation DbShareRevocation
+	has, err := gDb.ID(key).Get(&revocation)
+	if err != nil || !has {
+		return nil
+	}
+	return &revocation
+}
+
+func saveShareRevocation(key string, at int64, gen int64) {
+	_, err := gDb.Exec("INSERT OR REPLACE INTO share_revocation (key, at, gen) VALUES (?, ?, ?)", key, at, gen)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Forget revocations made before any link still valid was issued. A
+// session's generation is kept while its reservation lasts, as links issued
+// since the last revocation still carry it.
+func deleteShareRevocations(before int64) {
+	_, err := gDb.Where("at < ? AND (gen = 0 OR key NOT IN (SELECT rid FROM reservation))", before).Delete(&DbShareRevocation{})
+	if err != nil {
+		fmt.Printf("Error deleting share revocations: %s\n", err)
+	}
+}
+
+// Filters for searching the audit log; zero values match everything
+type AuditQuery struct {
+	user        string
+	udid        string
+	action      string
+	result      string
+	from        time.Time
+	to          time.Time
+	limit       int
+	oldestFirst bool
+}
+
+func getAuditEntries(query *AuditQuery) ([]DbAuditEntry, error) {
+	sess := gDb.NewSession()
+	defer sess.Close()
+
+	if query.user != "" {
+		sess.And("user = ?", query.user)
+	}
+	if query.udid != "" {
+		sess.And("udid = ?", query.udid)
+	}
+	if query.action != "" {
+		sess.And("action = ?", query.action)
+	}
+	if query.result != "" {
+		sess.And("result = ?", query.result)
+	}
+	if !query.from.IsZero() {
+		sess.And("stamp >= ?", query.from.Unix())
+	}
+	if !query.to.IsZero() {
+		sess.And("stamp < ?", query.to.Unix())
+	}
+	if query.oldestFirst {
+		sess.Asc("id")
+	} else {
+		sess.Desc("id")
+	}
+	if query.limit > 0 {
+		sess.Limit(query.limit)
+	}
+
+	var entries []DbAuditEntry
+	err := sess.Find(&entries)
+	if err != nil {
+		return []DbAuditEntry{}, err
+	}
+	return entries, nil
+}
+
 func getReservations() (map[string]DbReservation, error) {
 	var rs []DbReservation
 	err := gDb.Find(&rs)
@@ -210,26 +831,19 @@ func getDevices() ([]DbDevice, error) {
 	return devices, nil
 }
 
+// Store a provider with the hash of its password; returns whether the provider already existed
 func addProvider(username string, password string) bool {
 	cur := getProvider(username)
 	if cur != nil {
-		fmt.Printf("Provider with username %s already existed\n", username)
-		cur.Password = password
-		fmt.Printf("  Updating password to %s\n", password)
-		_, err := gDb.ID(cur.Id).Update(cur)
-
-		after := getProvider(username)
-		fmt.Printf("  After update: %s\n", after.Password)
-
-		if err != nil {
-			panic(err)
-		}
+		fmt.Printf("Provider with username %s already existed; updating password\n", username)
+		setProviderPassword(cur.Id, hashProviderPass(password))
 		return true
 	}
 
 	provider := DbProvider{
-		Username: username,
-		Password: password,
+		Username:    username,
+		Password:    hashProviderPass(password),
+		PassChanged: time.Now(),
 	}
 	_, err := gDb.Insert(&provider)
 	if err != nil {
@@ -322,6 +936,149 @@ func addDummyDevice(db *xorm.Engine, udid string, name string) {
 	}
 }
 
+func setRegPass(pass string) {
+	conf := getConf()
+	_, err := gDb.ID(conf.Id).Cols("reg_pass").Update(&DbConf{RegPass: pass})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func setRegClosed(closed bool) {
+	conf := getConf()
+	_, err := gDb.ID(conf.Id).Cols("reg_closed").Update(&DbConf{RegClosed: closed})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func addRegToken(token *DbRegToken) {
+	_, err := gDb.Insert(token)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getRegTokenByHash(hash string) *DbRegToken {
+	var token DbRegToken
+	has, err := gDb.Where("hash = ?", hash).Get(&token)
+	if err != nil || !has {
+		return nil
+	}
+	return &token
+}
+
+func getRegTokens() ([]DbRegToken, error) {
+	var tokens []DbRegToken
+	err := gDb.OrderBy("id").Find(&tokens)
+	if err != nil {
+		return []DbRegToken{}, err
+	}
+	return tokens, nil
+}
+
+// Mark a registration token used; false if it already was, so that of two
+// registrations racing on one token only one gets through
+func useRegToken(id int64, username string) bool {
+	affected, err := gDb.ID(id).Where("used = ?", false).Cols("used", "used_by", "used_at").Update(&DbRegToken{
+		Used:   true,
+		UsedBy: username,
+		UsedAt: time.Now(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return affected == 1
+}
+
+func revokeRegToken(id int64) bool {
+	affected, err := gDb.ID(id).Cols("revoked").Update(&DbRegToken{Revoked: true})
+	if err != nil {
+		panic(err)
+	}
+	return affected == 1
+}
+
+func setProvTokenSecret(secret string) {
+	conf := getConf()
+	_, err := gDb.ID(conf.Id).Cols("prov_token_secret").Update(&DbConf{ProvTokenSecret: secret})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func addProviderCert(cert *DbProviderCert) {
+	_, err := gDb.Insert(cert)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getProviderCert(serial string) *DbProviderCert {
+	var cert DbProviderCert
+	has, err := gDb.Where("serial = ?", serial).Get(&cert)
+	if err != nil || !has {
+		return nil
+	}
+	return &cert
+}
+
+func getProviderCerts() ([]DbProviderCert, error) {
+	var certs []DbProviderCert
+	err := gDb.OrderBy("id").Find(&certs)
+	if err != nil {
+		return []DbProviderCert{}, err
+	}
+	return certs, nil
+}
+
+func revokeProviderCert(serial string) bool {
+	affected, err := gDb.Where("serial = ?", serial).Cols("revoked").Update(&DbProviderCert{Revoked: true})
+	if err != nil {
+		panic(err)
+	}
+	return affected == 1
+}
+
+func addUser(user *DbUser) {
+	_, err := gDb.Insert(user)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getUser(username string) *DbUser {
+	var user DbUser
+	has, err := gDb.Where("username = ?", username).Get(&user)
+	if err != nil || !has {
+		return nil
+	}
+	return &user
+}
+
+func getUsers() ([]DbUser, error) {
+	var users []DbUser
+	err := gDb.OrderBy("username").Find(&users)
+	if err != nil {
+		return []DbUser{}, err
+	}
+	return users, nil
+}
+
+func updateUser(id int64, user *DbUser, cols ...string) {
+	_, err := gDb.ID(id).Cols(cols...).Update(user)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func noteUserLogin(id int64) {
+	_, err := gDb.ID(id).Cols("last_login").Update(&DbUser{LastLogin: time.Now()})
+	if err != nil {
+		fmt.Printf("Error updating user login: %s\n", err)
+	}
+}
+
 func getConf() *DbConf {
 	var confs []DbConf
 	err := gDb.Find(&confs)
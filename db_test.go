@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"xorm.io/xorm"
+)
+
+// Swap gDb for an in-memory db holding the given tables until the test ends
+func useTestDb(t *testing.T, beans ...interface{}) {
+	engine, err := xorm.NewEngine("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection to :memory: would get a db of its own
+	engine.SetMaxOpenConns(1)
+	if err := engine.Sync2(beans...); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := gDb
+	gDb = engine
+	t.Cleanup(func() {
+		gDb = prev
+		engine.Close()
+	})
+}
@@ -1,21 +1,48 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 
 	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
 )
 
 type VidConn struct {
-	socket *ws.Conn
+	viewer *VidViewer
 	//stopChan chan bool
 	onDone func()
-	offset int64
 	rid    string
 }
 
 type NoticeConn struct {
-	socket *ws.Conn
+	socket    *ws.Conn
+	writeLock *sync.Mutex
+}
+
+func NewNoticeConn(socket *ws.Conn) *NoticeConn {
+	return &NoticeConn{
+		socket:    socket,
+		writeLock: &sync.Mutex{},
+	}
+}
+
+func (self *NoticeConn) send(msg []byte) error {
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+	return self.socket.WriteMessage(ws.TextMessage, msg)
+}
+
+type DevStatusNotice struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (self *DevStatusNotice) asBytes() []byte {
+	text, _ := json.Marshal(self)
+	return text
 }
 
 type DevStatus struct {
@@ -24,34 +51,60 @@ type DevStatus struct {
 	video bool
 }
 
+// Describe each service of a device as up, down, or "-" when the device isn't tracked
+func devStatusStrings(stat *DevStatus) (string, string, string) {
+	if stat == nil {
+		return "-", "-", "-"
+	}
+	upDown := func(up bool) string {
+		if up {
+			return "up"
+		}
+		return "down"
+	}
+	return upDown(stat.wda), upDown(stat.cfa), upDown(stat.video)
+}
+
 type DevInfo struct {
 	orientation string
 }
 
 type DevTracker struct {
 	provConns   map[int64]*ProviderConnection
+	provGrace   map[int64]*ProvGrace
+	streamWaits map[string]chan *ws.Conn
 	devToProv   map[string]int64
 	DevStatus   map[string]*DevStatus
 	vidConns    map[string]*VidConn
+	viewers     map[string]map[*VidViewer]bool
+	lastFrames  map[string][]byte
+	shareSecret []byte
 	DevInfo     map[string]*DevInfo
 	noticeConns map[string]*NoticeConn
 	clients     map[string]chan ClientMsg
 	lock        *sync.Mutex
 	config      *Config
+	waitlist    *Waitlist
 }
 
 func NewDevTracker(config *Config) *DevTracker {
 	self := &DevTracker{
 		provConns:   make(map[int64]*ProviderConnection),
+		provGrace:   make(map[int64]*ProvGrace),
+		streamWaits: make(map[string]chan *ws.Conn),
 		devToProv:   make(map[string]int64),
 		lock:        &sync.Mutex{},
 		vidConns:    make(map[string]*VidConn),
+		viewers:     make(map[string]map[*VidViewer]bool),
+		lastFrames:  make(map[string][]byte),
+		shareSecret: newShareSecret(config),
 		noticeConns: make(map[string]*NoticeConn),
 		DevStatus:   make(map[string]*DevStatus),
 		DevInfo:     make(map[string]*DevInfo),
 		clients:     make(map[string]chan ClientMsg),
 		config:      config,
 	}
+	self.waitlist = NewWaitlist(self)
 
 	return self
 }
@@ -61,10 +114,13 @@ func (self *DevTracker) delVidStreamOutput(udid string, rid string) {
 	curConn, exists := self.vidConns[udid]
 	if exists {
 		if curConn.rid != rid {
+			self.lock.Unlock()
 			return
 		}
 		onDone := curConn.onDone
 		delete(self.vidConns, udid)
+		delete(self.lastFrames, udid)
+		self.endViewers(udid, "")
 		self.lock.Unlock()
 		onDone()
 		return
@@ -79,6 +135,7 @@ func (self *DevTracker) setVidStreamOutput(udid string, vidConn *VidConn) {
 	if exists {
 		onDone := curConn.onDone
 		self.vidConns[udid] = vidConn
+		self.endViewers(udid, vidConn.rid)
 		self.lock.Unlock()
 		onDone()
 		return
@@ -91,6 +148,14 @@ func (self *DevTracker) getVidStreamOutput(udid string) *VidConn {
 	return self.vidConns[udid]
 }
 
+// Whether a video stream opened under the given reservation is still running
+func (self *DevTracker) hasVidStream(udid string, rid string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	vidConn, exists := self.vidConns[udid]
+	return exists && vidConn.rid == rid
+}
+
 func (self *DevTracker) delNoticeOutput(udid string, rid string) {
 	self.lock.Lock()
 	_, exists := self.noticeConns[udid]
@@ -119,11 +184,19 @@ func (self *DevTracker) getNoticeOutput(udid string) *NoticeConn {
 	return self.noticeConns[udid]
 }
 
-func (self *DevTracker) setDevProv(udid string, provId int64) {
+// Make a provider the one providing a device, unless another provider that
+// is still connected, or within its grace period, already is. Returns the id
+// of that other provider, or 0 once the device is claimed.
+func (self *DevTracker) claimDev(udid string, provId int64) int64 {
 	self.lock.Lock()
+	defer self.lock.Unlock()
+	holder, held := self.devToProv[udid]
+	if held && holder != provId && (self.provConns[holder] != nil || self.provGrace[holder] != nil) {
+		return holder
+	}
 	self.devToProv[udid] = provId
 	self.DevStatus[udid] = &DevStatus{}
-	self.lock.Unlock()
+	return 0
 }
 
 func (self *DevTracker) clearDevProv(udid string) {
@@ -181,6 +254,17 @@ func (self *DevTracker) getDevInfo(udid string) *DevInfo {
 	return devInfo
 }
 
+// Orientation a device last reported; "" if it hasn't reported one
+func (self *DevTracker) devOrientation(udid string) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	devInfo, exists := self.DevInfo[udid]
+	if !exists {
+		return ""
+	}
+	return devInfo.orientation
+}
+
 func (self *DevTracker) getDevStatus(udid string) *DevStatus {
 	devStatus, devOk := self.DevStatus[udid]
 	if devOk {
@@ -199,18 +283,86 @@ func (self *DevTracker) getDevProvId(udid string) int64 {
 	}
 }
 
+// Register a provider's connection, picking its devices back up if it
+// reconnected within the grace period
 func (self *DevTracker) setProvConn(provId int64, provConn *ProviderConnection) {
 	self.lock.Lock()
 	self.provConns[provId] = provConn
+	grace, resumed := self.provGrace[provId]
+	udids := []string{}
+	if resumed {
+		grace.timer.Stop()
+		delete(self.provGrace, provId)
+		udids = self.provDevices(provId)
+	}
 	self.lock.Unlock()
+
+	if resumed {
+		self.reattachProv(provId, provConn, grace, udids)
+	}
 }
 
 func (self *DevTracker) getProvConn(provId int64) *ProviderConnection {
 	return self.provConns[provId]
 }
 
-func (self *DevTracker) clearProvConn(provId int64) {
+// Drop a provider connection. Its devices stay put for the grace period in
+// case it reconnects, and are taken offline after that. Nothing happens if the
+// provider has since reconnected with a new connection.
+func (self *DevTracker) clearProvConn(provId int64, provConn *ProviderConnection) {
 	self.lock.Lock()
+	if self.provConns[provId] != provConn {
+		self.lock.Unlock()
+		return
+	}
 	delete(self.provConns, provId)
+
+	if self.config.providerGrace <= 0 {
+		self.lock.Unlock()
+		self.provOffline(provId)
+		return
+	}
+	udids := self.startGrace(provId)
 	self.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"type":     "provider_grace",
+		"provider": provId,
+		"devices":  len(udids),
+		"grace":    self.config.providerGrace.String(),
+	}).Info("Holding devices for provider to reconnect")
+
+	notice := DevStatusNotice{
+		Type:   "status",
+		Status: "reconnecting",
+		Reason: ReqErrProviderGone,
+	}
+	for _, udid := range udids {
+		self.sendNotice(udid, notice.asBytes())
+	}
+}
+
+// Pass a notice on to the browser watching a device, if any
+func (self *DevTracker) sendNotice(udid string, msg []byte) {
+	conn := self.getNoticeOutput(udid)
+	if conn == nil {
+		return
+	}
+	err := conn.send(msg)
+	if err != nil {
+		fmt.Printf("Failed to send notice for %s: %s\n", censorUuid(udid), err)
+	}
+}
+
+// Record the orientation a device turned to and let its browser know
+func (self *DevTracker) setOrientation(udid string, orientation string) {
+	self.lock.Lock()
+	self.getDevInfo(udid).orientation = orientation
+	self.lock.Unlock()
+
+	msg := OrientationResult{
+		Type:        "orientation",
+		Orientation: orientation,
+	}
+	self.sendNotice(udid, msg.asBytes())
 }
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestClaimDev(t *testing.T) {
+	devTracker := NewDevTracker(&Config{})
+	devTracker.provConns[1] = &ProviderConnection{}
+	devTracker.provConns[2] = &ProviderConnection{}
+
+	if holder := devTracker.claimDev("dev1", 1); holder != 0 {
+		t.Fatalf("unprovided device refused; held by %d", holder)
+	}
+	if holder := devTracker.claimDev("dev1", 1); holder != 0 {
+		t.Errorf("own device refused; held by %d", holder)
+	}
+	if holder := devTracker.claimDev("dev1", 2); holder != 1 {
+		t.Errorf("device of a connected provider: got holder %d, want 1", holder)
+	}
+	if got := devTracker.getDevProvId("dev1"); got != 1 {
+		t.Errorf("refused claim moved the device to provider %d", got)
+	}
+
+	// One that dropped keeps its devices until its grace period is over
+	delete(devTracker.provConns, 1)
+	devTracker.provGrace[1] = &ProvGrace{}
+	if holder := devTracker.claimDev("dev1", 2); holder != 1 {
+		t.Errorf("device of a reconnecting provider: got holder %d, want 1", holder)
+	}
+	delete(devTracker.provGrace, 1)
+
+	// A provider that went away without its devices being cleared loses them
+	if holder := devTracker.claimDev("dev1", 2); holder != 0 {
+		t.Errorf("device of a disconnected provider refused; held by %d", holder)
+	}
+	if got := devTracker.getDevProvId("dev1"); got != 2 {
+		t.Errorf("got provider %d, want 2", got)
+	}
+}
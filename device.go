@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,6 +20,7 @@ type DevHandler struct {
 	providerAuthGroup *gin.RouterGroup
 	userAuthGroup     *gin.RouterGroup
 	adminAuthGroup    *gin.RouterGroup
+	shareGroup        *gin.RouterGroup
 	devTracker        *DevTracker
 	sessionManager    *cfSessionManager
 	config            *Config
@@ -27,6 +30,7 @@ func NewDevHandler(
 	providerAuthGroup *gin.RouterGroup,
 	userAuthGroup *gin.RouterGroup,
 	adminAuthGroup *gin.RouterGroup,
+	shareGroup *gin.RouterGroup,
 	devTracker *DevTracker,
 	sessionManager *cfSessionManager,
 	config *Config,
@@ -35,6 +39,7 @@ func NewDevHandler(
 		providerAuthGroup,
 		userAuthGroup,
 		adminAuthGroup,
+		shareGroup,
 		devTracker,
 		sessionManager,
 		config,
@@ -45,6 +50,23 @@ func (self *DevHandler) registerDeviceRoutes() {
 	pAuth := self.providerAuthGroup
 	uAuth := self.userAuthGroup
 	aAuth := self.adminAuthGroup
+	sAuth := self.shareGroup
+
+	// Input routes; refused to viewers and while someone else holds the device
+	uCtl := uAuth.Group("")
+	uCtl.Use(self.NeedPerm(PermControl), self.NeedControl())
+
+	// Routes only device admins may use
+	uDevAdmin := uAuth.Group("")
+	uDevAdmin.Use(self.NeedPerm(PermDeviceAdmin), self.NeedControl())
+
+	control := self.NeedPerm(PermControl)
+
+	// Shared sessions; input also needs a link granting control
+	sShare := sAuth.Group("")
+	sShare.Use(self.NeedShareAuth())
+	sCtl := sShare.Group("")
+	sCtl.Use(self.NeedControl())
 
 	fmt.Println("Registering device routes")
 	pAuth.POST("/device/status/:variant", func(c *gin.Context) { self.handleDevStatus(c) })
@@ -56,22 +78,22 @@ func (self *DevHandler) registerDeviceRoutes() {
 	// - Video seems active/inactive
 
 	//uAuth.GET("/devClick", showDevClick )
-	uAuth.POST("/device/click", func(c *gin.Context) { self.handleDevClick(c) })
-	uAuth.POST("/device/doubleclick", func(c *gin.Context) { self.handleDevDoubleclick(c) })
-	uAuth.POST("/device/mouseDown", func(c *gin.Context) { self.handleDevMouseDown(c) })
-	uAuth.POST("/device/mouseUp", func(c *gin.Context) { self.handleDevMouseUp(c) })
-	uAuth.POST("/device/hardPress", func(c *gin.Context) { self.handleDevHardPress(c) })
-	uAuth.POST("/device/longPress", func(c *gin.Context) { self.handleDevLongPress(c) })
-	uAuth.POST("/device/home", func(c *gin.Context) { self.handleDevHome(c) })
-	uAuth.POST("/device/taskSwitcher", func(c *gin.Context) { self.handleDevTaskSwitcher(c) })
-	uAuth.POST("/device/shake", func(c *gin.Context) { self.handleDevShake(c) })
-	uAuth.POST("/device/cc", func(c *gin.Context) { self.handleDevCC(c) })
-	uAuth.POST("/device/assistiveTouch", func(c *gin.Context) { self.handleDevAssistiveTouch(c) })
-	uAuth.POST("/device/swipe", func(c *gin.Context) { self.handleDevSwipe(c) })
-	uAuth.POST("/device/keys", func(c *gin.Context) { self.handleKeys(c) })
-	uAuth.POST("/device/text", func(c *gin.Context) { self.handleText(c) })
-	uAuth.POST("/device/source", func(c *gin.Context) { self.handleSource(c) })
-	uAuth.POST("/device/shutdown", func(c *gin.Context) { self.handleShutdown(c) })
+	uCtl.POST("/device/click", func(c *gin.Context) { self.handleDevClick(c) })
+	uCtl.POST("/device/doubleclick", func(c *gin.Context) { self.handleDevDoubleclick(c) })
+	uCtl.POST("/device/mouseDown", func(c *gin.Context) { self.handleDevMouseDown(c) })
+	uCtl.POST("/device/mouseUp", func(c *gin.Context) { self.handleDevMouseUp(c) })
+	uCtl.POST("/device/hardPress", func(c *gin.Context) { self.handleDevHardPress(c) })
+	uCtl.POST("/device/longPress", func(c *gin.Context) { self.handleDevLongPress(c) })
+	uCtl.POST("/device/home", func(c *gin.Context) { self.handleDevHome(c) })
+	uCtl.POST("/device/taskSwitcher", func(c *gin.Context) { self.handleDevTaskSwitcher(c) })
+	uCtl.POST("/device/shake", func(c *gin.Context) { self.handleDevShake(c) })
+	uCtl.POST("/device/cc", func(c *gin.Context) { self.handleDevCC(c) })
+	uCtl.POST("/device/assistiveTouch", func(c *gin.Context) { self.handleDevAssistiveTouch(c) })
+	uCtl.POST("/device/swipe", func(c *gin.Context) { self.handleDevSwipe(c) })
+	uCtl.POST("/device/keys", func(c *gin.Context) { self.handleKeys(c) })
+	uCtl.POST("/device/text", func(c *gin.Context) { self.handleText(c) })
+	uCtl.POST("/device/source", func(c *gin.Context) { self.handleSource(c) })
+	uDevAdmin.POST("/device/shutdown", func(c *gin.Context) { self.handleShutdown(c) })
 
 	uAuth.GET("/device/info", func(c *gin.Context) { self.showDevInfo(c) })
 	aAuth.GET("/device", func(c *gin.Context) { self.showDevAdmin(c) })
@@ -79,34 +101,59 @@ func (self *DevHandler) registerDeviceRoutes() {
 
 	uAuth.GET("/device/info/json", func(c *gin.Context) { self.showDevInfoJson(c) })
 
-	uAuth.GET("/device/imgStream", func(c *gin.Context) { self.handleImgStream(c) })
-	uAuth.POST("/device/initWebrtc", func(c *gin.Context) { self.handleWebrtc(c) })
-	uAuth.GET("/device/ws", func(c *gin.Context) { self.handleDevWs(c) })
-	uAuth.GET("/device/notices", func(c *gin.Context) { self.handleDevNotices(c) })
-
-	uAuth.POST("/device/launch", func(c *gin.Context) { self.handleDevLaunch(c) })
-	uAuth.POST("/device/kill", func(c *gin.Context) { self.handleDevKill(c) })
+	uAuth.GET("/device/imgStream", control, func(c *gin.Context) { self.handleImgStream(c) })
+	uAuth.POST("/device/initWebrtc", control, func(c *gin.Context) { self.handleWebrtc(c) })
+	uAuth.GET("/device/ws", control, func(c *gin.Context) { self.handleDevWs(c) })
+	uAuth.GET("/device/notices", control, func(c *gin.Context) { self.handleDevNotices(c) })
+	uAuth.POST("/device/share", control, self.handleShare)
+
+	sAuth.GET("/watch", self.showShareWatch)
+	sShare.GET("/stream", self.handleShareStream)
+	sCtl.POST("/device/click", func(c *gin.Context) { self.handleDevClick(c) })
+	sCtl.POST("/device/doubleclick", func(c *gin.Context) { self.handleDevDoubleclick(c) })
+	sCtl.POST("/device/mouseDown", func(c *gin.Context) { self.handleDevMouseDown(c) })
+	sCtl.POST("/device/mouseUp", func(c *gin.Context) { self.handleDevMouseUp(c) })
+	sCtl.POST("/device/hardPress", func(c *gin.Context) { self.handleDevHardPress(c) })
+	sCtl.POST("/device/longPress", func(c *gin.Context) { self.handleDevLongPress(c) })
+	sCtl.POST("/device/home", func(c *gin.Context) { self.handleDevHome(c) })
+	sCtl.POST("/device/taskSwitcher", func(c *gin.Context) { self.handleDevTaskSwitcher(c) })
+	sCtl.POST("/device/shake", func(c *gin.Context) { self.handleDevShake(c) })
+	sCtl.POST("/device/cc", func(c *gin.Context) { self.handleDevCC(c) })
+	sCtl.POST("/device/assistiveTouch", func(c *gin.Context) { self.handleDevAssistiveTouch(c) })
+	sCtl.POST("/device/swipe", func(c *gin.Context) { self.handleDevSwipe(c) })
+	sCtl.POST("/device/keys", func(c *gin.Context) { self.handleKeys(c) })
+	sCtl.POST("/device/text", func(c *gin.Context) { self.handleText(c) })
+
+	uCtl.POST("/device/launch", func(c *gin.Context) { self.handleDevLaunch(c) })
+	uCtl.POST("/device/kill", func(c *gin.Context) { self.handleDevKill(c) })
 
 	aAuth.POST("/device/allowApp", func(c *gin.Context) { self.handleDevAllowApp(c) })
 	aAuth.POST("/device/restrictApp", func(c *gin.Context) { self.handleDevRestrictApp(c) })
 	aAuth.GET("/device/listRestrictedApps", func(c *gin.Context) { self.handleDevListRestrictedApps(c) })
 
-	uAuth.GET("/device/video", self.showDevVideo)
-	uAuth.GET("/device/videoNew", self.showDevVideoNew)
+	uAuth.GET("/device/video", control, self.showDevVideo)
+	uAuth.GET("/device/videoNew", control, self.showDevVideoNew)
 	uAuth.GET("/device/reserved", self.showDevReservedTest)
-	uAuth.GET("/device/kick", self.devKick)
-	uAuth.POST("/device/videoStop", self.stopDevVideo)
+	uAuth.GET("/device/kick", control, self.devKick)
+	uAuth.POST("/device/videoStop", control, self.stopDevVideo)
 
 	uAuth.GET("/device/ping", self.handleDevPing)
 	uAuth.GET("/device/inspect", self.showDevInspect)
 	uAuth.GET("/device/wdaPort", self.showWdaPort)
 
-	uAuth.GET("/device/refresh", self.handleDeviceRefresh)
-	uAuth.GET("/device/restart", self.handleDeviceRestart)
-	uAuth.POST("/device/launchsafariurl", func(c *gin.Context) { self.handleSafariUrl(c) })
-	uAuth.POST("/device/cleanbrowser", func(c *gin.Context) { self.handleBrowserCleanup(c) })
-	uAuth.POST("/device/rotatedevice", func(c *gin.Context) { self.handleRotateDevice(c) })
-	uAuth.GET("/echo", gin.HandlerFunc(self.echo))
+	uCtl.GET("/device/refresh", self.handleDeviceRefresh)
+	uCtl.GET("/device/restart", self.handleDeviceRestart)
+	uCtl.POST("/device/launchsafariurl", func(c *gin.Context) { self.handleSafariUrl(c) })
+	uCtl.POST("/device/cleanbrowser", func(c *gin.Context) { self.handleBrowserCleanup(c) })
+	uCtl.POST("/device/rotatedevice", func(c *gin.Context) { self.handleRotateDevice(c) })
+	uAuth.GET("/echo", control, self.NeedControl(), gin.HandlerFunc(self.echo))
+
+	self.registerApiRoutes()
+	self.registerReservationRoutes()
+	self.registerWaitlistRoutes()
+	self.registerRecordingRoutes()
+	self.registerVideoRoutes()
+	self.registerScreenshotRoutes()
 }
 
 type SRawInfo struct {
@@ -139,6 +186,8 @@ type SDeviceInfo struct {
 	CfaStatus   string `json:"cfaStatus"   example:"up"`
 	VideoStatus string `json:"videoStatus" example:"up"`
 	DeviceVideo string `json:"deviceVideo" example:"up"`
+	// Message types the device supports; absent when its provider didn't say
+	Features []string `json:"features,omitempty" example:"click,home,screenshot"`
 }
 
 type SDeviceWdaPort struct {
@@ -174,21 +223,28 @@ func (self *DevHandler) showWdaPort(c *gin.Context) {
 	provId := self.devTracker.getDevProvId(udid)
 	pc := self.devTracker.getProvConn(provId)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
 	ip := "unknown"
 	mac := "unknown"
 
-	pc.doWifiIp(udid, func(_ uj.JNode, json []byte) {
-		root, _ := uj.Parse(json)
-
-		ip = root.Get("ip").String()
-		mac = root.Get("mac").String()
+	pc.doWifiIp(ctx, udid, func(root uj.JNode, _ []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			ip = root.Get("ip").String()
+			mac = root.Get("mac").String()
+		}
 
-		done <- true
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	//
 
@@ -452,6 +508,33 @@ func (self *DevHandler) showDevApps(c *gin.Context) {
 	})
 }
 
+// Bound a provider round-trip by the configured timeout and the life of the HTTP request
+func (self *DevHandler) provCtx(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx := withAuditUser(c.Request.Context(), self.apiUser(c))
+	return context.WithTimeout(ctx, self.config.providerTimeout)
+}
+
+// Context for provider messages that outlive the HTTP request, such as fire-and-forget ones
+func (self *DevHandler) detachedCtx(c *gin.Context) context.Context {
+	return withAuditUser(context.Background(), self.apiUser(c))
+}
+
+// Report a provider round-trip that never got an answer
+func provFail(c *gin.Context, reqErr string) {
+	status := http.StatusBadGateway
+	if reqErr == ReqErrTimeout {
+		status = http.StatusGatewayTimeout
+	} else if reqErr == ReqErrProviderGone {
+		status = http.StatusServiceUnavailable
+	} else if reqErr == ReqErrUnsupported {
+		status = http.StatusNotImplemented
+	}
+	c.JSON(status, SDeviceInfoFail{
+		Success: false,
+		Err:     reqErr,
+	})
+}
+
 func (self *DevHandler) getPc(c *gin.Context) (*ProviderConnection, string) {
 	udid := c.PostForm("udid")
 	provId := self.devTracker.getDevProvId(udid)
@@ -493,13 +576,19 @@ func (self *DevHandler) handleDevClick(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doClick(udid, x, y, func(uj.JNode, []byte) {
-		done <- true
+	pc.doClick(ctx, udid, x, y, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -523,13 +612,19 @@ func (self *DevHandler) handleDevDoubleclick(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doDoubleclick(udid, x, y, func(uj.JNode, []byte) {
-		done <- true
+	pc.doDoubleclick(ctx, udid, x, y, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -550,13 +645,19 @@ func (self *DevHandler) handleDevLaunch(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doLaunch(udid, bid, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doLaunch(ctx, udid, bid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -577,13 +678,19 @@ func (self *DevHandler) handleDevKill(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doKill(udid, bid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doKill(ctx, udid, bid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -604,13 +711,19 @@ func (self *DevHandler) handleDevRestrictApp(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doRestrictApp(udid, bid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doRestrictApp(ctx, udid, bid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -631,13 +744,19 @@ func (self *DevHandler) handleDevAllowApp(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doAllowApp(udid, bid, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doAllowApp(ctx, udid, bid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -660,13 +779,19 @@ func (self *DevHandler) handleDevMouseDown(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doMouseDown(udid, x, y, func(uj.JNode, []byte) {
-		done <- true
+	pc.doMouseDown(ctx, udid, x, y, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -689,13 +814,19 @@ func (self *DevHandler) handleDevMouseUp(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doMouseUp(udid, x, y, func(uj.JNode, []byte) {
-		done <- true
+	pc.doMouseUp(ctx, udid, x, y, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -718,7 +849,11 @@ func (self *DevHandler) handleDevHardPress(c *gin.Context) {
 		return
 	}
 
-	pc.doHardPress(udid, x, y)
+	if !pc.caps.supports(udid, "hardPress") {
+		provFail(c, ReqErrUnsupported)
+		return
+	}
+	pc.doHardPress(self.detachedCtx(c), udid, x, y)
 }
 
 // @Summary Device - Long Press coordinate
@@ -739,13 +874,19 @@ func (self *DevHandler) handleDevLongPress(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doLongPress(udid, x, y, time, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doLongPress(ctx, udid, x, y, time, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -765,13 +906,19 @@ func (self *DevHandler) handleDevHome(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doHome(udid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doHome(ctx, udid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -790,13 +937,19 @@ func (self *DevHandler) handleDevTaskSwitcher(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doTaskSwitcher(udid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doTaskSwitcher(ctx, udid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -815,13 +968,19 @@ func (self *DevHandler) handleDevShake(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doShake(udid, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doShake(ctx, udid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -840,13 +999,19 @@ func (self *DevHandler) handleDevCC(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doCC(udid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doCC(ctx, udid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -865,13 +1030,19 @@ func (self *DevHandler) handleDevAssistiveTouch(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doAssistiveTouch(udid, func(uj.JNode, []byte) {
-		done <- true
+	pc.doAssistiveTouch(ctx, udid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -900,13 +1071,19 @@ func (self *DevHandler) handleDevSwipe(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doSwipe(udid, x1, y1, x2, y2, delay, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doSwipe(ctx, udid, x1, y1, x2, y2, delay, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -924,7 +1101,10 @@ func (self *DevHandler) handleKeys(c *gin.Context) {
 	curid, _ := strconv.Atoi(c.PostForm("curid"))
 	prevkeys := c.PostForm("prevkeys")
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
 	pc, udid := self.getPc(c)
 	if pc == nil {
@@ -934,11 +1114,14 @@ func (self *DevHandler) handleKeys(c *gin.Context) {
 		return
 	}
 
-	pc.doKeys(udid, keys, curid, prevkeys, func(uj.JNode, []byte) {
-		done <- true
+	pc.doKeys(ctx, udid, keys, curid, prevkeys, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -952,7 +1135,10 @@ func (self *DevHandler) handleKeys(c *gin.Context) {
 func (self *DevHandler) handleText(c *gin.Context) {
 	text := c.PostForm("text")
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
 	pc, udid := self.getPc(c)
 	if pc == nil {
@@ -962,11 +1148,14 @@ func (self *DevHandler) handleText(c *gin.Context) {
 		return
 	}
 
-	pc.doText(udid, text, func(uj.JNode, []byte) {
-		done <- true
+	pc.doText(ctx, udid, text, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -984,16 +1173,24 @@ func (self *DevHandler) handleWebrtc(c *gin.Context) {
 
 	offer := c.PostForm("offer")
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.initWebrtc(udid, offer, func(_ uj.JNode, raw []byte) {
-		c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
-		c.Writer.WriteHeader(200)
-		c.Writer.Write(raw)
-		done <- true
+	done := make(chan string, 1)
+
+	pc.initWebrtc(ctx, udid, offer, func(root uj.JNode, raw []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
+			c.Writer.WriteHeader(200)
+			c.Writer.Write(raw)
+		}
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+	}
 }
 
 // @Summary Device - Get device source
@@ -1008,16 +1205,24 @@ func (self *DevHandler) handleSource(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doSource(udid, func(_ uj.JNode, raw []byte) {
-		c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
-		c.Writer.WriteHeader(200)
-		c.Writer.Write(raw)
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doSource(ctx, udid, func(root uj.JNode, raw []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
+			c.Writer.WriteHeader(200)
+			c.Writer.Write(raw)
+		}
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+	}
 }
 
 // @Summary Device - List device restricted apps
@@ -1032,16 +1237,24 @@ func (self *DevHandler) handleDevListRestrictedApps(c *gin.Context) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doListRestrictedApps(udid, func(_ uj.JNode, raw []byte) {
-		c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
-		c.Writer.WriteHeader(200)
-		c.Writer.Write(raw)
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doListRestrictedApps(ctx, udid, func(root uj.JNode, raw []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			c.Writer.Header().Set("Content-Type", "text/json; charset=utf-8")
+			c.Writer.WriteHeader(200)
+			c.Writer.Write(raw)
+		}
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+	}
 }
 
 // @Summary Device - Shutdown device provider
@@ -1056,7 +1269,11 @@ func (self *DevHandler) handleShutdown(c *gin.Context) {
 		return
 	}
 
-	pc.doShutdown(func(_ uj.JNode, raw []byte) {})
+	if !pc.caps.supports("", "shutdown") {
+		provFail(c, ReqErrUnsupported)
+		return
+	}
+	pc.doShutdown(self.detachedCtx(c), func(_ uj.JNode, raw []byte) {})
 	self.devTracker.clearDevProv(udid)
 
 	// It will take at least 3 seconds to restart
@@ -1118,7 +1335,8 @@ func (self *DevHandler) devKick(c *gin.Context) {
 
 	self.devTracker.msgClient(udid, ClientMsg{msgType: CMKick, msg: "{\"type\":\"kick\"}"})
 
-	deleteReservation(udid)
+	deleteReservation(udid, ReserveEndKicked)
+	self.devTracker.waitlist.handOver(udid)
 
 	c.Redirect(302, "/devVideo?udid="+udid)
 }
@@ -1172,7 +1390,7 @@ func (self *DevHandler) showDevVideo(c *gin.Context) {
 			return
 		}
 		fmt.Printf("Renewing reservation\n")
-		deleteReservation(udid)
+		deleteReservation(udid, ReserveEndReplaced)
 		addReservation(udid, user, rid)
 	}
 
@@ -1203,7 +1421,9 @@ func (self *DevHandler) showDevVideo(c *gin.Context) {
 		"info":        info,
 		"rawInfo":     rawInfo,
 		"notes":       notesText,
-		"orientation": self.devTracker.getDevInfo(udid).orientation,
+		"canShutdown": roleHas(requestRole(c), PermDeviceAdmin) && self.devTracker.devSupports(udid, "shutdown"),
+		"can":         self.devTracker.devFeatures(udid),
+		"orientation": self.devTracker.devOrientation(udid),
 	})
 }
 
@@ -1220,6 +1440,12 @@ func (self *DevHandler) showDevVideoNew(c *gin.Context) {
 	}
 
 	dev := getDevice(udid)
+	if !self.devTracker.devSupports(udid, "initWebrtc") {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "the device's provider does not support WebRTC video",
+		})
+		return
+	}
 
 	sCtx := self.sessionManager.GetSession(c)
 	user := self.sessionManager.session.Get(sCtx, "user").(string)
@@ -1238,7 +1464,7 @@ func (self *DevHandler) showDevVideoNew(c *gin.Context) {
 			return
 		}
 		fmt.Printf("Renewing reservation\n")
-		deleteReservation(udid)
+		deleteReservation(udid, ReserveEndReplaced)
 		addReservation(udid, user, rid)
 	}
 
@@ -1269,6 +1495,8 @@ func (self *DevHandler) showDevVideoNew(c *gin.Context) {
 		"info":        info,
 		"rawInfo":     rawInfo,
 		"notes":       notesText,
+		"canShutdown": roleHas(requestRole(c), PermDeviceAdmin) && self.devTracker.devSupports(udid, "shutdown"),
+		"can":         self.devTracker.devFeatures(udid),
 	})
 }
 
@@ -1296,6 +1524,8 @@ func (self *DevHandler) showDevInspect(c *gin.Context) {
 // @Summary Device - Stop device video
 // @Router /device/videoStop [POST]
 // @Param udid query string true "Device UDID"
+// @Param rid query string true "Reservation id"
+// @Param reason query string false "idle when the page timed out"
 func (self *DevHandler) stopDevVideo(c *gin.Context) {
 	udid, uok := c.GetQuery("udid")
 	if !uok {
@@ -1314,7 +1544,12 @@ func (self *DevHandler) stopDevVideo(c *gin.Context) {
 
 	fmt.Printf("dev video stopped for udid: %s\n", udid)
 
-	deleteReservationWithRid(udid, rid)
+	reason := ReserveEndStopped
+	if c.Query("reason") == "idle" {
+		reason = ReserveEndIdle
+	}
+	deleteReservationWithRid(udid, rid, reason)
+	self.devTracker.waitlist.handOver(udid)
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -1369,22 +1604,7 @@ func (self *DevHandler) handleDevOrientation(c *gin.Context) {
 	orientation := c.PostForm("orientation")
 
 	// Notice Connection to Frontend
-	conn := self.devTracker.getNoticeOutput(udid)
-	devInfo := self.devTracker.getDevInfo(udid)
-	devInfo.orientation = orientation
-
-	if conn == nil {
-		return
-	}
-
-	msg := OrientationResult{
-		Type:        "orientation",
-		Orientation: orientation,
-	}
-	err := conn.socket.WriteMessage(ws.TextMessage, msg.asBytes())
-	if err != nil {
-		//TODO disaster
-	}
+	self.devTracker.setOrientation(udid, orientation)
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -1395,14 +1615,7 @@ func (self *DevHandler) handleDevOrientation(c *gin.Context) {
 // @Router /provider/device/status/provisionStopped [POST]
 // @Param udid query string true "Device UDID"
 func (self *DevHandler) handleDevStatus(c *gin.Context) {
-	s := self.sessionManager.GetSession(c)
-
-	session := self.sessionManager.session
-
-	provider := session.Get(s, "provider").(ProviderOb)
-
-	//status := c.PostForm("status")
-	variant := c.Param("variant")
+	provider := providerOf(c)
 
 	//fmt.Printf("devStatus request; variant=%s\n", variant )
 
@@ -1411,68 +1624,23 @@ func (self *DevHandler) handleDevStatus(c *gin.Context) {
 	}
 	ok.ok = true
 
-	udid := c.PostForm("udid")
-	//fmt.Printf("  udid=%s\n", udid )
-
-	if variant == "exists" {
-		fmt.Printf("Device Status: Provider started - udid: %s - provider: %s\n", udid, provider.User)
-		width, _ := strconv.Atoi(c.PostForm("width"))
-		height, _ := strconv.Atoi(c.PostForm("height"))
-		clickWidth, _ := strconv.Atoi(c.PostForm("clickWidth"))
-		clickHeight, _ := strconv.Atoi(c.PostForm("clickHeight"))
-		addDevice(udid, "unknown", provider.Id, provider.User, width, height, clickWidth, clickHeight)
-		self.devTracker.setDevProv(udid, provider.Id)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "info" {
-		info := c.PostForm("info")
-		fmt.Printf("Device Status: Info - udid: %s\n%s\n", udid, info)
-		updateDeviceInfo(udid, info, provider.Id)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "wdaStarted" {
-		port, _ := strconv.Atoi(c.PostForm("port"))
-		fmt.Printf("Device Status: WDA started - udid: %s\n - port %d\n", udid, port)
-		self.devTracker.setDevStatus(udid, "wda", true)
-		updateDeviceWdaPort(udid, port)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "wdaStopped" {
-		fmt.Printf("Device Status: WDA stopped - udid: %s\n\n", udid)
-		self.devTracker.setDevStatus(udid, "wda", false)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "cfaStarted" {
-		fmt.Printf("Device Status: CFA started - udid: %s\n\n", udid)
-		self.devTracker.setDevStatus(udid, "cfa", true)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "cfaStopped" {
-		fmt.Printf("Device Status: CFA stopped - udid: %s\n\n", udid)
-		self.devTracker.setDevStatus(udid, "cfa", false)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "videoStarted" {
-		fmt.Printf("Video started for %s\n", udid)
-		self.devTracker.setDevStatus(udid, "video", true)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "videoStopped" {
-		fmt.Printf("Device Status: Video stopped - udid: %s\n\n", udid)
-		self.devTracker.setDevStatus(udid, "video", false)
-		c.JSON(http.StatusOK, ok)
-		return
-	}
-	if variant == "provisionStopped" {
-		fmt.Printf("Device Status: Provider stopped - udid: %s\n\n", udid)
-		self.devTracker.clearDevProv(udid)
+	msg := &ProvStatusEvent{
+		Type:   "status",
+		Udid:   c.PostForm("udid"),
+		Status: c.Param("variant"),
+		Info:   c.PostForm("info"),
+	}
+	msg.Width, _ = strconv.Atoi(c.PostForm("width"))
+	msg.Height, _ = strconv.Atoi(c.PostForm("height"))
+	msg.ClickWidth, _ = strconv.Atoi(c.PostForm("clickWidth"))
+	msg.ClickHeight, _ = strconv.Atoi(c.PostForm("clickHeight"))
+	msg.Port, _ = strconv.Atoi(c.PostForm("port"))
+
+	// As over the websocket, only a device's own provider may change its status
+	if msg.Status != "exists" && self.devTracker.getDevProvId(msg.Udid) != provider.Id {
+		fmt.Printf("Dropping %s status from %s for a device it doesn't provide: %s\n",
+			msg.Status, provider.User, censorUuid(msg.Udid))
+	} else if self.devTracker.applyDevStatus(provider, msg) {
 		c.JSON(http.StatusOK, ok)
 		return
 	}
@@ -1559,7 +1727,7 @@ func (self *DevHandler) handleImgStream(c *gin.Context) {
 	req := c.Request
 	conn, err := wsupgrader.Upgrade(writer, req, nil)
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
 		return
 	}
 
@@ -1583,38 +1751,38 @@ func (self *DevHandler) handleImgStream(c *gin.Context) {
 		return
 	}
 
-	done := false
+	// Runs both when the browser goes away and when the stream output is
+	// replaced; only the first should end the reservation
+	doneOnce := &sync.Once{}
 	imgDone := func() {
-		if done {
-			return
-		}
-		log.WithFields(log.Fields{
-			"type": "imgstream_stop",
-			"udid": censorUuid(udid),
-			"rid":  rid,
-		}).Info("Client <- Server video disconnected")
-
-		if rok {
-			deleteReservationWithRid(udid, rid)
-		}
-		provConn.stopImgStream(udid)
+		doneOnce.Do(func() {
+			log.WithFields(log.Fields{
+				"type": "imgstream_stop",
+				"udid": censorUuid(udid),
+				"rid":  rid,
+			}).Info("Client <- Server video disconnected")
+
+			if rok {
+				deleteReservationWithRid(udid, rid, ReserveEndStreamClosed)
+				self.devTracker.waitlist.handOver(udid)
+			}
+			// The provider may have reconnected since the stream started
+			if provConn := self.devTracker.devProvConn(udid); provConn != nil {
+				provConn.stopImgStream(udid)
+			}
+		})
 	}
 
+	viewer := NewVidViewer(conn, clientOffset, nil, self.apiUser(c))
 	go func() {
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-		}
+		viewer.readReports()
 		imgDone()
 	}()
 
 	fmt.Printf("A - rid:%s\n", rid)
 
 	self.devTracker.setVidStreamOutput(udid, &VidConn{
-		socket: conn,
-		offset: clientOffset,
+		viewer: viewer,
 		rid:    rid,
 		onDone: imgDone,
 	})
@@ -1657,7 +1825,7 @@ func (self *DevHandler) handleDevWs(c *gin.Context) {
 	req := c.Request
 	conn, err := wsupgrader.Upgrade(writer, req, nil)
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
 		return
 	}
 
@@ -1712,8 +1880,14 @@ func (self *DevHandler) handleDevWs(c *gin.Context) {
 
 // @Description Device - Device Notices Websocket
 // @Router /device/notices [GET]
-// @Param udid query string true "Device UDID"
+// @Param udid query string false "Device UDID"
+// @Param wait query int false "Waiter id; notifies when the waitlist hands over a device instead"
 func (self *DevHandler) handleDevNotices(c *gin.Context) {
+	if waitId, wok := c.GetQuery("wait"); wok {
+		self.handleWaitNotices(c, waitId)
+		return
+	}
+
 	udid, uok := c.GetQuery("udid")
 	if !uok {
 		c.HTML(http.StatusOK, "error", gin.H{
@@ -1731,22 +1905,26 @@ func (self *DevHandler) handleDevNotices(c *gin.Context) {
 	req := c.Request
 	conn, err := wsupgrader.Upgrade(writer, req, nil)
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
 		return
 	}
 
 	//abort := false
-	self.devTracker.setNoticeOutput(udid, &NoticeConn{
-		socket: conn,
-	})
+	noticeConn := NewNoticeConn(conn)
+	self.devTracker.setNoticeOutput(udid, noticeConn)
 
 	for {
 		//if abort { return }
-		_, _, err := conn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			//abort = true
 			break
 		}
+		var notice ShareNotice
+		if json.Unmarshal(msg, &notice) == nil && notice.Type == "revokeShare" {
+			self.handleShareRevoke(c, noticeConn, udid, c.Query("rid"), notice.Id)
+			continue
+		}
 		time.Sleep(time.Second)
 	}
 
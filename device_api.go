@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+)
+
+type SApiSuccess struct {
+	Success bool `json:"success" example:"true"`
+}
+
+type SApiError struct {
+	Success    bool   `json:"success"              example:"false"`
+	Err        string `json:"error"                example:"provider_offline"`
+	ReservedBy string `json:"reservedBy,omitempty" example:"someuser"`
+}
+
+type SApiDevice struct {
+	Udid string `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+}
+
+type SApiPoint struct {
+	Udid string `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+	X    int    `json:"x"    example:"100"`
+	Y    int    `json:"y"    example:"200"`
+}
+
+type SApiLongPress struct {
+	Udid string  `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+	X    int     `json:"x"    example:"100"`
+	Y    int     `json:"y"    example:"200"`
+	Time float64 `json:"time" example:"1.5"`
+}
+
+type SApiSwipe struct {
+	Udid  string  `json:"udid"  binding:"required" example:"00008100-001338811EE10033"`
+	X1    int     `json:"x1"    example:"100"`
+	Y1    int     `json:"y1"    example:"600"`
+	X2    int     `json:"x2"    example:"100"`
+	Y2    int     `json:"y2"    example:"200"`
+	Delay float64 `json:"delay" example:"0.5"`
+}
+
+type SApiKeys struct {
+	Udid     string `json:"udid"     binding:"required" example:"00008100-001338811EE10033"`
+	Keys     string `json:"keys"     example:"abc"`
+	Curid    int    `json:"curid"    example:"1"`
+	Prevkeys string `json:"prevkeys" example:""`
+}
+
+type SApiText struct {
+	Udid string `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+	Text string `json:"text" example:"hello"`
+}
+
+type SApiApp struct {
+	Udid string `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+	Bid  string `json:"bid"  binding:"required" example:"com.apple.Preferences"`
+}
+
+type SApiUrl struct {
+	Udid string `json:"udid" binding:"required" example:"00008100-001338811EE10033"`
+	Url  string `json:"url"  binding:"required" example:"https://example.com"`
+}
+
+type SApiOrientation struct {
+	Udid        string `json:"udid"        binding:"required" example:"00008100-001338811EE10033"`
+	Orientation string `json:"orientation" binding:"required" example:"portrait"`
+}
+
+type SApiWifiIp struct {
+	Success bool   `json:"success" example:"true"`
+	Udid    string `json:"udid"    example:"00008100-001338811EE10033"`
+	WdaPort int    `json:"wdaPort" example:"8100"`
+	Ip      string `json:"ip"      example:"192.168.1.20"`
+	Mac     string `json:"mac"     example:"b0:8c:75:75:aa:a4"`
+}
+
+type SApiRestart struct {
+	Success bool   `json:"success" example:"true"`
+	Udid    string `json:"udid"    example:"00008100-001338811EE10033"`
+	Result  string `json:"result"  example:"true"`
+}
+
+func (self *DevHandler) registerApiRoutes() {
+	uApi := self.userAuthGroup.Group("/api/v1")
+	aApi := self.adminAuthGroup.Group("/api/v1")
+
+	control := self.NeedPerm(PermControl)
+	devAdmin := self.NeedPerm(PermDeviceAdmin)
+
+	uApi.GET("/device/info", self.apiInfo)
+	uApi.POST("/device/click", control, self.apiClick)
+	uApi.POST("/device/doubleclick", control, self.apiDoubleclick)
+	uApi.POST("/device/mouseDown", control, self.apiMouseDown)
+	uApi.POST("/device/mouseUp", control, self.apiMouseUp)
+	uApi.POST("/device/hardPress", control, self.apiHardPress)
+	uApi.POST("/device/longPress", control, self.apiLongPress)
+	uApi.POST("/device/swipe", control, self.apiSwipe)
+	uApi.POST("/device/home", control, self.apiHome)
+	uApi.POST("/device/taskSwitcher", control, self.apiTaskSwitcher)
+	uApi.POST("/device/shake", control, self.apiShake)
+	uApi.POST("/device/cc", control, self.apiCC)
+	uApi.POST("/device/assistiveTouch", control, self.apiAssistiveTouch)
+	uApi.POST("/device/keys", control, self.apiKeys)
+	uApi.POST("/device/text", control, self.apiText)
+	uApi.POST("/device/source", control, self.apiSource)
+	uApi.POST("/device/launch", control, self.apiLaunch)
+	uApi.POST("/device/kill", control, self.apiKill)
+	uApi.POST("/device/launchSafariUrl", control, self.apiSafariUrl)
+	uApi.POST("/device/cleanBrowser", control, self.apiBrowserCleanup)
+	uApi.POST("/device/rotate", control, self.apiRotate)
+	uApi.POST("/device/wifiIp", control, self.apiWifiIp)
+	uApi.POST("/device/refresh", control, self.apiRefresh)
+	uApi.POST("/device/restart", control, self.apiRestart)
+	uApi.POST("/device/shutdown", devAdmin, self.apiShutdown)
+
+	aApi.POST("/device/allowApp", self.apiAllowApp)
+	aApi.POST("/device/restrictApp", self.apiRestrictApp)
+	aApi.POST("/device/listRestrictedApps", self.apiListRestrictedApps)
+}
+
+func apiFail(c *gin.Context, status int, err string) {
+	c.JSON(status, SApiError{
+		Success: false,
+		Err:     err,
+	})
+}
+
+// Decode the JSON body of an API call; replies 400 when it doesn't fit
+func apiBind(c *gin.Context, req interface{}) bool {
+	err := c.ShouldBindJSON(req)
+	if err != nil {
+		apiFail(c, http.StatusBadRequest, "bad_request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// The user behind a request; on admin routes, the admin, and for someone
+// not logged in using a share link, the link
+func (self *DevHandler) apiUser(c *gin.Context) string {
+	sCtx := self.sessionManager.GetSession(c)
+	user, _ := self.sessionManager.session.Get(sCtx, "user").(string)
+	if user == "" {
+		user, _ = self.sessionManager.session.Get(sCtx, "admin").(string)
+	}
+	if user == "" {
+		if share := shareClaims(c); share != nil {
+			user = "share:" + share.Id
+		}
+	}
+	return user
+}
+
+// Find the provider connection for a device, replying with the matching
+// error status if the device can't be used. An empty user skips the
+// reservation check; admin calls act regardless of who holds the device.
+func (self *DevHandler) apiDevice(c *gin.Context, udid string, user string) (*ProviderConnection, bool) {
+	dev := getDevice(udid)
+	if dev == nil {
+		apiFail(c, http.StatusNotFound, "unknown_udid")
+		return nil, false
+	}
+
+	if user != "" {
+		rv := getReservation(udid)
+		if rv != nil && rv.User != user {
+			c.JSON(http.StatusConflict, SApiError{
+				Success:    false,
+				Err:        "reserved",
+				ReservedBy: rv.User,
+			})
+			return nil, false
+		}
+	}
+
+	provId := self.devTracker.getDevProvId(udid)
+	pc := self.devTracker.getProvConn(provId)
+	if pc == nil {
+		apiFail(c, http.StatusServiceUnavailable, "provider_offline")
+		return nil, false
+	}
+	return pc, true
+}
+
+// Run a provider round-trip and wait for the answer. Replies with the
+// error status and returns nil if the provider never answered.
+func (self *DevHandler) apiRoundTrip(c *gin.Context, send func(context.Context, func(uj.JNode, []byte))) uj.JNode {
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan uj.JNode, 1)
+
+	send(ctx, func(root uj.JNode, _ []byte) {
+		done <- root
+	})
+
+	root := <-done
+	if reqErr := resError(root); reqErr != "" {
+		provFail(c, reqErr)
+		return nil
+	}
+	return root
+}
+
+// Run a provider round-trip that has no result beyond success
+func (self *DevHandler) apiAction(c *gin.Context, send func(context.Context, func(uj.JNode, []byte))) {
+	root := self.apiRoundTrip(c, send)
+	if root == nil {
+		return
+	}
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+// @Summary API - Device info
+// @Router /api/v1/device/info [GET]
+// @Security ApiToken
+// @Param udid query string true "Device UDID"
+// @Produce json
+// @Success 200 {object} SDeviceInfo
+// @Failure 404 {object} SApiError
+func (self *DevHandler) apiInfo(c *gin.Context) {
+	udid := c.Query("udid")
+	dev := getDevice(udid)
+	if dev == nil {
+		apiFail(c, http.StatusNotFound, "unknown_udid")
+		return
+	}
+
+	wdaUp, cfaUp, videoUp := devStatusStrings(self.devTracker.getDevStatus(udid))
+
+	provId := self.devTracker.getDevProvId(udid)
+
+	c.JSON(http.StatusOK, SDeviceInfo{
+		Udid:        udid,
+		Name:        dev.Name,
+		ClickWidth:  dev.ClickWidth,
+		ClickHeight: dev.ClickHeight,
+		VidWidth:    dev.Width,
+		VidHeight:   dev.Height,
+		Provider:    int(provId),
+		RawInfo:     dev.JsonInfo,
+		WdaStatus:   wdaUp,
+		CfaStatus:   cfaUp,
+		VideoStatus: videoUp,
+		DeviceVideo: self.config.text.deviceVideo,
+		Features:    self.devTracker.devFeatureList(udid),
+	})
+}
+
+// @Summary API - Click coordinate
+// @Router /api/v1/device/click [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiPoint true "Click"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiClick(c *gin.Context) {
+	var req SApiPoint
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doClick(ctx, req.Udid, req.X, req.Y, onDone)
+	})
+}
+
+// @Summary API - Doubleclick coordinate
+// @Router /api/v1/device/doubleclick [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiPoint true "Doubleclick"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiDoubleclick(c *gin.Context) {
+	var req SApiPoint
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doDoubleclick(ctx, req.Udid, req.X, req.Y, onDone)
+	})
+}
+
+// @Summary API - Mouse down
+// @Router /api/v1/device/mouseDown [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiPoint true "Mouse down"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiMouseDown(c *gin.Context) {
+	var req SApiPoint
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doMouseDown(ctx, req.Udid, req.X, req.Y, onDone)
+	})
+}
+
+// @Summary API - Mouse up
+// @Router /api/v1/device/mouseUp [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiPoint true "Mouse up"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiMouseUp(c *gin.Context) {
+	var req SApiPoint
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doMouseUp(ctx, req.Udid, req.X, req.Y, onDone)
+	})
+}
+
+// @Summary API - Hard press coordinate
+// @Description The provider does not acknowledge hard presses; success means the press was queued.
+// @Router /api/v1/device/hardPress [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiPoint true "Hard press"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+func (self *DevHandler) apiHardPress(c *gin.Context) {
+	var req SApiPoint
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	if !pc.caps.supports(req.Udid, "hardPress") {
+		provFail(c, ReqErrUnsupported)
+		return
+	}
+	pc.doHardPress(self.detachedCtx(c), req.Udid, req.X, req.Y)
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+// @Summary API - Long press coordinate
+// @Router /api/v1/device/longPress [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiLongPress true "Long press"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiLongPress(c *gin.Context) {
+	var req SApiLongPress
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doLongPress(ctx, req.Udid, req.X, req.Y, req.Time, onDone)
+	})
+}
+
+// @Summary API - Swipe
+// @Router /api/v1/device/swipe [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiSwipe true "Swipe"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiSwipe(c *gin.Context) {
+	var req SApiSwipe
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doSwipe(ctx, req.Udid, req.X1, req.Y1, req.X2, req.Y2, req.Delay, onDone)
+	})
+}
+
+// @Summary API - Home button
+// @Router /api/v1/device/home [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiHome(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doHome(ctx, req.Udid, onDone)
+	})
+}
+
+// @Summary API - Task switcher
+// @Router /api/v1/device/taskSwitcher [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiTaskSwitcher(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doTaskSwitcher(ctx, req.Udid, onDone)
+	})
+}
+
+// @Summary API - Shake
+// @Router /api/v1/device/shake [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiShake(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doShake(ctx, req.Udid, onDone)
+	})
+}
+
+// @Summary API - Control center
+// @Router /api/v1/device/cc [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiCC(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doCC(ctx, req.Udid, onDone)
+	})
+}
+
+// @Summary API - Assistive touch
+// @Router /api/v1/device/assistiveTouch [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiAssistiveTouch(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doAssistiveTouch(ctx, req.Udid, onDone)
+	})
+}
+
+// @Summary API - Simulate keystrokes
+// @Router /api/v1/device/keys [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiKeys true "Keys"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiKeys(c *gin.Context) {
+	var req SApiKeys
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doKeys(ctx, req.Udid, req.Keys, req.Curid, req.Prevkeys, onDone)
+	})
+}
+
+// @Summary API - Enter a block of text
+// @Router /api/v1/device/text [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiText true "Text"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiText(c *gin.Context) {
+	var req SApiText
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doText(ctx, req.Udid, req.Text, onDone)
+	})
+}
+
+// @Summary API - Get device source
+// @Description Returns the provider's source response as is.
+// @Router /api/v1/device/source [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} object
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiSource(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	root := self.apiRoundTrip(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doSource(ctx, req.Udid, onDone)
+	})
+	if root == nil {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(root.JsonSave()))
+}
+
+// @Summary API - Launch app
+// @Router /api/v1/device/launch [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiApp true "App"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiLaunch(c *gin.Context) {
+	var req SApiApp
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doLaunch(ctx, req.Udid, req.Bid, onDone)
+	})
+}
+
+// @Summary API - Kill app
+// @Router /api/v1/device/kill [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiApp true "App"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiKill(c *gin.Context) {
+	var req SApiApp
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doKill(ctx, req.Udid, req.Bid, onDone)
+	})
+}
+
+// @Summary API - Launch url in safari app
+// @Router /api/v1/device/launchSafariUrl [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiUrl true "Url"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiSafariUrl(c *gin.Context) {
+	var req SApiUrl
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doOpenSafariUrl(ctx, req.Udid, req.Url, onDone)
+	})
+}
+
+// @Summary API - Clean up browser data
+// @Router /api/v1/device/cleanBrowser [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiApp true "Browser app"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiBrowserCleanup(c *gin.Context) {
+	var req SApiApp
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doBrowserCleanup(ctx, req.Udid, req.Bid, onDone)
+	})
+}
+
+// @Summary API - Change orientation
+// @Router /api/v1/device/rotate [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiOrientation true "portrait, portraitUpsideDown, landscapeLeft or landscapeRight"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiRotate(c *gin.Context) {
+	var req SApiOrientation
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doRotateDevice(ctx, req.Udid, req.Orientation, onDone)
+	})
+}
+
+// @Summary API - Device network address and WDA port
+// @Router /api/v1/device/wifiIp [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiWifiIp
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiWifiIp(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	root := self.apiRoundTrip(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doWifiIp(ctx, req.Udid, onDone)
+	})
+	if root == nil {
+		return
+	}
+
+	res := SApiWifiIp{
+		Success: true,
+		Udid:    req.Udid,
+		Ip:      "unknown",
+		Mac:     "unknown",
+	}
+	dev := getDevice(req.Udid)
+	if dev != nil {
+		res.WdaPort = dev.WdaPort
+	}
+	if ipNode := root.Get("ip"); ipNode != nil {
+		res.Ip = ipNode.String()
+	}
+	if macNode := root.Get("mac"); macNode != nil {
+		res.Mac = macNode.String()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// @Summary API - Refresh device
+// @Router /api/v1/device/refresh [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiRestart
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiRefresh(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	root := self.apiRoundTrip(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doRefresh(ctx, req.Udid, onDone)
+	})
+	if root == nil {
+		return
+	}
+
+	res := SApiRestart{
+		Success: true,
+		Udid:    req.Udid,
+	}
+	if node := root.Get("refresh"); node != nil {
+		res.Result = node.String()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// @Summary API - Restart device
+// @Router /api/v1/device/restart [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiRestart
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiRestart(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	root := self.apiRoundTrip(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doRestart(ctx, req.Udid, onDone)
+	})
+	if root == nil {
+		return
+	}
+
+	res := SApiRestart{
+		Success: true,
+		Udid:    req.Udid,
+	}
+	if node := root.Get("restart"); node != nil {
+		res.Result = node.String()
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// @Summary API - Shutdown device provider
+// @Description Asks the provider to reset the device; the device drops offline while it restarts.
+// @Router /api/v1/device/shutdown [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+func (self *DevHandler) apiShutdown(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	if !pc.caps.supports("", "shutdown") {
+		provFail(c, ReqErrUnsupported)
+		return
+	}
+	pc.doShutdown(self.detachedCtx(c), func(uj.JNode, []byte) {})
+	self.devTracker.clearDevProv(req.Udid)
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+// @Summary API - Allow app
+// @Router /admin/api/v1/device/allowApp [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiApp true "App"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiAllowApp(c *gin.Context) {
+	var req SApiApp
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, "")
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doAllowApp(ctx, req.Udid, req.Bid, onDone)
+	})
+}
+
+// @Summary API - Restrict app
+// @Router /admin/api/v1/device/restrictApp [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiApp true "App"
+// @Success 200 {object} SApiSuccess
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiRestrictApp(c *gin.Context) {
+	var req SApiApp
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, "")
+	if !ok {
+		return
+	}
+	self.apiAction(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doRestrictApp(ctx, req.Udid, req.Bid, onDone)
+	})
+}
+
+// @Summary API - List restricted apps
+// @Description Returns the provider's response as is.
+// @Router /admin/api/v1/device/listRestrictedApps [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SApiDevice true "Device"
+// @Success 200 {object} object
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) apiListRestrictedApps(c *gin.Context) {
+	var req SApiDevice
+	if !apiBind(c, &req) {
+		return
+	}
+	pc, ok := self.apiDevice(c, req.Udid, "")
+	if !ok {
+		return
+	}
+	root := self.apiRoundTrip(c, func(ctx context.Context, onDone func(uj.JNode, []byte)) {
+		pc.doListRestrictedApps(ctx, req.Udid, onDone)
+	})
+	if root == nil {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(root.JsonSave()))
+}
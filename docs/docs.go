@@ -33,704 +33,1274 @@ var doc = `{
                 "summary": "Home - Admin"
             }
         },
-        "/admin/device": {
-            "get": {
-                "summary": "Device - Device admin page",
+        "/admin/api/v1/device/allowApp": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Allow app",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "App",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiApp"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/admin/deviceApps": {
-            "get": {
-                "summary": "Device - Device app restriction page",
+        "/admin/api/v1/device/listRestrictedApps": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Returns the provider's response as is.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - List restricted apps",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/adminLogout": {
+        "/admin/api/v1/device/restrictApp": {
             "post": {
-                "description": "Admin - Logout"
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Restrict app",
+                "parameters": [
+                    {
+                        "description": "App",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiApp"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/adminlogin": {
-            "post": {
-                "description": "Admin - Login",
+        "/admin/audit": {
+            "get": {
+                "summary": "Admin - Audit log",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Username",
+                        "description": "User",
                         "name": "user",
-                        "in": "formData",
-                        "required": true
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Password",
-                        "name": "pass",
-                        "in": "formData",
-                        "required": true
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Action, e.g. click or launch",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ok, timeout, provider_disconnected, ...",
+                        "name": "result",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First day, YYYY-MM-DD",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day, YYYY-MM-DD",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum entries; default 500",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ]
             }
         },
-        "/device/allowApp": {
-            "post": {
-                "summary": "Device - Allow app",
+        "/admin/audit/export": {
+            "get": {
+                "description": "One JSON object per line, oldest first. Takes the same filters as /admin/audit; limit 0 exports everything.",
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Admin - Export audit log",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User",
+                        "name": "user",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
                         "description": "Device UDID",
                         "name": "udid",
-                        "in": "formData",
-                        "required": true
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "[bundle id]",
-                        "name": "bid",
-                        "in": "formData",
-                        "required": true
+                        "description": "Action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Result",
+                        "name": "result",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First day, YYYY-MM-DD",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day, YYYY-MM-DD",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum entries",
+                        "name": "limit",
+                        "in": "query"
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SAuditEntry"
+                        }
+                    }
+                }
             }
         },
-        "/device/assistiveTouch": {
-            "post": {
-                "summary": "Device assistive touch",
+        "/admin/device": {
+            "get": {
+                "summary": "Device - Device admin page",
                 "parameters": [
                     {
                         "type": "string",
                         "description": "Device UDID",
                         "name": "udid",
-                        "in": "formData",
+                        "in": "query",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/cc": {
-            "post": {
-                "summary": "Device control center",
+        "/admin/deviceApps": {
+            "get": {
+                "summary": "Device - Device app restriction page",
                 "parameters": [
                     {
                         "type": "string",
                         "description": "Device UDID",
                         "name": "udid",
-                        "in": "formData",
+                        "in": "query",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/click": {
+        "/admin/providers": {
+            "get": {
+                "summary": "Admin - Provider list"
+            }
+        },
+        "/admin/providers/cert": {
             "post": {
-                "summary": "Device - Click coordinate",
+                "summary": "Admin - Issue provider certificate",
                 "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    },
                     {
                         "type": "integer",
-                        "description": "x",
-                        "name": "x",
+                        "description": "Provider id",
+                        "name": "id",
                         "in": "formData",
                         "required": true
-                    },
+                    }
+                ]
+            }
+        },
+        "/admin/providers/cert/revoke": {
+            "post": {
+                "summary": "Admin - Revoke provider certificate",
+                "parameters": [
                     {
-                        "type": "integer",
-                        "description": "y",
-                        "name": "y",
+                        "type": "string",
+                        "description": "Certificate serial",
+                        "name": "serial",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/hardPress": {
+        "/admin/providers/disable": {
             "post": {
-                "summary": "Device - Hard press coordinate",
+                "summary": "Admin - Disable or enable provider",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "type": "integer",
+                        "description": "Provider id",
+                        "name": "id",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "x",
-                        "name": "x",
+                        "type": "boolean",
+                        "description": "Whether the provider is disabled",
+                        "name": "disabled",
                         "in": "formData",
                         "required": true
-                    },
+                    }
+                ]
+            }
+        },
+        "/admin/providers/rotate": {
+            "post": {
+                "summary": "Admin - Rotate provider password",
+                "parameters": [
                     {
                         "type": "integer",
-                        "description": "y",
-                        "name": "y",
+                        "description": "Provider id",
+                        "name": "id",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/home": {
+        "/admin/providers/token": {
             "post": {
-                "summary": "Device click",
+                "summary": "Admin - Issue provider token",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "type": "integer",
+                        "description": "Provider id",
+                        "name": "id",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/imgStream": {
+        "/admin/registration": {
             "get": {
-                "description": "Device - Image Stream Websocket",
+                "summary": "Admin - Provider registration settings and tokens"
+            }
+        },
+        "/admin/registration/open": {
+            "post": {
+                "summary": "Admin - Open or close password registration",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Video Instance ID",
-                        "name": "rid",
-                        "in": "query",
+                        "type": "boolean",
+                        "description": "Whether providers may register with the password",
+                        "name": "open",
+                        "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/info": {
-            "get": {
-                "summary": "Device - Device info page",
+        "/admin/registration/password": {
+            "post": {
+                "summary": "Admin - Set provider registration password",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
+                        "description": "New registration password",
+                        "name": "password",
+                        "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/info/json": {
-            "get": {
-                "produces": [
-                    "application/json"
-                ],
-                "summary": "Device - Device info JSON",
+        "/admin/registration/tokens/create": {
+            "post": {
+                "summary": "Admin - Create registration token",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.SDeviceInfo"
-                        }
+                        "description": "Which provider host the token is for",
+                        "name": "note",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only allow registering this provider username",
+                        "name": "username",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Lifetime, such as 24h",
+                        "name": "ttl",
+                        "in": "formData"
                     }
-                }
+                ]
             }
         },
-        "/device/inspect": {
-            "get": {
-                "summary": "Device - Inspect Page",
+        "/admin/registration/tokens/revoke": {
+            "post": {
+                "summary": "Admin - Revoke registration token",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "Token id",
+                        "name": "id",
+                        "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/keys": {
+        "/admin/tokens": {
+            "get": {
+                "summary": "Admin - API token list"
+            }
+        },
+        "/admin/tokens/create": {
             "post": {
-                "summary": "Device - Simulate keystrokes",
+                "summary": "Admin - Create API token",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Incrementing unique ID",
-                        "name": "curid",
+                        "description": "User the token acts as",
+                        "name": "user",
                         "in": "formData",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Keys",
-                        "name": "keys",
-                        "in": "formData",
-                        "required": true
+                        "description": "Token description",
+                        "name": "name",
+                        "in": "formData"
                     },
                     {
                         "type": "string",
-                        "description": "Previous keys",
-                        "name": "prevkeys",
-                        "in": "formData",
-                        "required": true
+                        "description": "Set to allow admin routes",
+                        "name": "admin",
+                        "in": "formData"
                     }
                 ]
             }
         },
-        "/device/kick": {
-            "get": {
-                "summary": "Device - Kick device user",
+        "/admin/tokens/revoke": {
+            "post": {
+                "summary": "Admin - Revoke API token",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "Token id",
+                        "name": "id",
+                        "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/kill": {
-            "post": {
-                "summary": "Device - Kill app",
+        "/admin/usage": {
+            "get": {
+                "summary": "Admin - Device usage report",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
+                        "description": "First day, YYYY-MM-DD; defaults to 30 days ago",
+                        "name": "from",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "[bundle id]",
-                        "name": "bid",
-                        "in": "formData",
-                        "required": true
+                        "description": "Last day, YYYY-MM-DD; defaults to today",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "device, user, or provider",
+                        "name": "by",
+                        "in": "query"
                     }
                 ]
             }
         },
-        "/device/launch": {
-            "post": {
-                "summary": "Device - Launch app",
+        "/admin/usage/export": {
+            "get": {
+                "description": "by=reservation exports the individual reservations instead of totals",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Admin - Export device usage",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
+                        "description": "First day, YYYY-MM-DD; defaults to 30 days ago",
+                        "name": "from",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "[bundle id]",
-                        "name": "bid",
-                        "in": "formData",
-                        "required": true
+                        "description": "Last day, YYYY-MM-DD; defaults to today",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "device, user, provider, or reservation",
+                        "name": "by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json or csv",
+                        "name": "format",
+                        "in": "query"
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SUsageReport"
+                        }
+                    }
+                }
             }
         },
-        "/device/listRestrictedApps": {
+        "/admin/users": {
             "get": {
-                "summary": "Device - List device restricted apps",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    }
-                ]
+                "summary": "Admin - User list"
             }
         },
-        "/device/longPress": {
+        "/admin/users/create": {
             "post": {
-                "summary": "Device - Long Press coordinate",
+                "summary": "Admin - Create user",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "description": "Username",
+                        "name": "user",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "x",
-                        "name": "x",
+                        "type": "string",
+                        "description": "Password",
+                        "name": "pass",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "y",
-                        "name": "y",
+                        "type": "string",
+                        "description": "viewer, tester, or admin",
+                        "name": "role",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/mouseDown": {
+        "/admin/users/disable": {
             "post": {
-                "summary": "Device - Mouse down",
+                "summary": "Admin - Disable or enable user",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "x",
-                        "name": "x",
+                        "description": "Username",
+                        "name": "user",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "y",
-                        "name": "y",
+                        "type": "boolean",
+                        "description": "Whether the user is disabled",
+                        "name": "disabled",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/mouseUp": {
+        "/admin/users/password": {
             "post": {
-                "summary": "Device - Mouse up",
+                "summary": "Admin - Set user password",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "x",
-                        "name": "x",
+                        "description": "Username",
+                        "name": "user",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "integer",
-                        "description": "y",
-                        "name": "y",
+                        "type": "string",
+                        "description": "New password",
+                        "name": "pass",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/restrictApp": {
+        "/admin/users/role": {
             "post": {
-                "summary": "Device - Restrict app",
+                "summary": "Admin - Change user role",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "description": "Username",
+                        "name": "user",
                         "in": "formData",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "[bundle id]",
-                        "name": "bid",
+                        "description": "viewer, tester, or admin",
+                        "name": "role",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/shake": {
+        "/admin/videos": {
+            "get": {
+                "summary": "Admin - Video recordings"
+            }
+        },
+        "/admin/videos/delete": {
             "post": {
-                "summary": "Device shake",
+                "summary": "Admin - Delete video recording",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "type": "integer",
+                        "description": "Video recording id",
+                        "name": "id",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/shutdown": {
+        "/admin/videos/download": {
             "get": {
-                "summary": "Device - Shutdown device provider",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "summary": "Admin - Download video recording",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
+                        "type": "integer",
+                        "description": "Video recording id",
+                        "name": "id",
+                        "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "index for the frame index",
+                        "name": "part",
+                        "in": "query"
                     }
                 ]
             }
         },
-        "/device/source": {
-            "get": {
-                "summary": "Device - Get device source",
+        "/adminLogout": {
+            "post": {
+                "description": "Admin - Logout"
+            }
+        },
+        "/adminlogin": {
+            "post": {
+                "description": "Admin - Login",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
+                        "description": "Username",
+                        "name": "user",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Password",
+                        "name": "pass",
                         "in": "formData",
                         "required": true
                     }
                 ]
             }
         },
-        "/device/status/exists": {
+        "/api/v1/device/assistiveTouch": {
             "post": {
-                "summary": "Device Status - Existence",
-                "parameters": [
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "ApiToken": []
                     }
-                ]
-            }
-        },
-        "/device/status/info": {
-            "post": {
-                "summary": "Device Status - Information",
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Assistive touch",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/status/videoStarted": {
+        "/api/v1/device/cc": {
             "post": {
-                "summary": "Device Status - Video Started",
-                "parameters": [
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "ApiToken": []
                     }
-                ]
-            }
-        },
-        "/device/status/videoStopped": {
-            "post": {
-                "summary": "Device Status - Video Stopped",
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Control center",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/status/wdaStarted": {
+        "/api/v1/device/cleanBrowser": {
             "post": {
-                "summary": "Device Status - WDA Started",
-                "parameters": [
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "ApiToken": []
                     }
-                ]
-            }
-        },
-        "/device/status/wdaStopped": {
-            "post": {
-                "summary": "Device Status - WDA Stopped",
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Clean up browser data",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Browser app",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiApp"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/swipe": {
+        "/api/v1/device/click": {
             "post": {
-                "summary": "Device - Swipe",
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Click coordinate",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
+                        "description": "Click",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiPoint"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
                     },
-                    {
-                        "type": "integer",
-                        "description": "x1",
-                        "name": "x1",
-                        "in": "formData",
-                        "required": true
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     },
-                    {
-                        "type": "integer",
-                        "description": "y1",
-                        "name": "y1",
-                        "in": "formData",
-                        "required": true
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     },
-                    {
-                        "type": "integer",
-                        "description": "x2",
-                        "name": "x2",
-                        "in": "formData",
-                        "required": true
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     },
-                    {
-                        "type": "integer",
-                        "description": "y2",
-                        "name": "y2",
-                        "in": "formData",
-                        "required": true
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     },
-                    {
-                        "type": "number",
-                        "description": "Time of swipe",
-                        "name": "delay",
-                        "in": "formData",
-                        "required": true
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     }
-                ]
+                }
             }
         },
-        "/device/taskSwitcher": {
+        "/api/v1/device/doubleclick": {
             "post": {
-                "summary": "Device task switcher",
-                "parameters": [
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
+                        "ApiToken": []
                     }
-                ]
-            }
-        },
-        "/device/text": {
-            "post": {
-                "summary": "Device - Simulate entering a block of text",
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Doubleclick coordinate",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Text to enter",
-                        "name": "text",
-                        "in": "formData",
-                        "required": true
+                        "description": "Doubleclick",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiPoint"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/video": {
-            "get": {
-                "summary": "Device - Video Page",
+        "/api/v1/device/hardPress": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "The provider does not acknowledge hard presses; success means the press was queued.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Hard press coordinate",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Hard press",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiPoint"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/videoStop": {
+        "/api/v1/device/home": {
             "post": {
-                "summary": "Device - Stop device video",
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Home button",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Device UDID",
-                        "name": "udid",
-                        "in": "query",
-                        "required": true
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
                     }
-                ]
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
             }
         },
-        "/device/ws": {
+        "/api/v1/device/info": {
             "get": {
-                "description": "Device - Device Command Websocket",
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Device info",
                 "parameters": [
                     {
                         "type": "string",
@@ -739,105 +1309,3292 @@ var doc = `{
                         "in": "query",
                         "required": true
                     }
-                ]
-            }
-        },
-        "/login": {
-            "post": {
-                "description": "User - Login",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Username",
-                        "name": "user",
-                        "in": "formData",
-                        "required": true
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SDeviceInfo"
+                        }
                     },
-                    {
-                        "type": "string",
-                        "description": "Password",
-                        "name": "pass",
-                        "in": "formData",
-                        "required": true
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     }
-                ]
-            }
-        },
-        "/logout": {
-            "post": {
-                "description": "User - Logout"
-            }
-        },
-        "/provider/imgStream": {
-            "get": {
-                "description": "Provider - Image Stream Websocket"
+                }
             }
         },
-        "/provider/login": {
+        "/api/v1/device/keys": {
             "post": {
-                "description": "Provider - Login",
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Simulate keystrokes",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Username",
-                        "name": "user",
-                        "in": "query",
-                        "required": true
+                        "description": "Keys",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiKeys"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
                     },
-                    {
-                        "type": "string",
-                        "description": "Password",
-                        "name": "pass",
-                        "in": "query",
-                        "required": true
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
                     }
-                ]
-            }
-        },
-        "/provider/logout": {
-            "get": {
-                "description": "Provider - Logout"
+                }
             }
         },
-        "/provider/register": {
+        "/api/v1/device/kill": {
             "post": {
-                "description": "Provider - Register",
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
+                "summary": "API - Kill app",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Registration password",
-                        "name": "regPass",
-                        "in": "formData",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Provider username",
-                        "name": "username",
-                        "in": "formData",
-                        "required": true
+                        "description": "App",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiApp"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.SProviderRegistration"
+                            "$ref": "#/definitions/main.SApiSuccess"
                         }
-                    }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/launch": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Launch app",
+                "parameters": [
+                    {
+                        "description": "App",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiApp"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/launchSafariUrl": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Launch url in safari app",
+                "parameters": [
+                    {
+                        "description": "Url",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiUrl"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/longPress": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Long press coordinate",
+                "parameters": [
+                    {
+                        "description": "Long press",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiLongPress"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/mouseDown": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Mouse down",
+                "parameters": [
+                    {
+                        "description": "Mouse down",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiPoint"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/mouseUp": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Mouse up",
+                "parameters": [
+                    {
+                        "description": "Mouse up",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiPoint"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/refresh": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Refresh device",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiRestart"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/restart": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Restart device",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiRestart"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Change orientation",
+                "parameters": [
+                    {
+                        "description": "portrait, portraitUpsideDown, landscapeLeft or landscapeRight",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiOrientation"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/shake": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Shake",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/shutdown": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Asks the provider to reset the device; the device drops offline while it restarts.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Shutdown device provider",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/source": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Returns the provider's source response as is.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Get device source",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/swipe": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Swipe",
+                "parameters": [
+                    {
+                        "description": "Swipe",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSwipe"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/taskSwitcher": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Task switcher",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/text": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Enter a block of text",
+                "parameters": [
+                    {
+                        "description": "Text",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiText"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/device/wifiIp": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "API - Device network address and WDA port",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiDevice"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiWifiIp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/allowApp": {
+            "post": {
+                "summary": "Device - Allow app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/assistiveTouch": {
+            "post": {
+                "summary": "Device assistive touch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/cc": {
+            "post": {
+                "summary": "Device control center",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/cleansafari": {
+            "post": {
+                "summary": "Device - Launch url in safari app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/click": {
+            "post": {
+                "summary": "Device - Click coordinate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/doubleclick": {
+            "post": {
+                "summary": "Device - Doubleclick coordinate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/hardPress": {
+            "post": {
+                "summary": "Device - Hard press coordinate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/home": {
+            "post": {
+                "summary": "Device click",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/imgStream": {
+            "get": {
+                "description": "Device - Image Stream Websocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Video Instance ID",
+                        "name": "rid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/info": {
+            "get": {
+                "summary": "Device - Device info page",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/info/json": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Device - Device info JSON",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SDeviceInfo"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/inspect": {
+            "get": {
+                "summary": "Device - Inspect Page",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/keys": {
+            "post": {
+                "summary": "Device - Simulate keystrokes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Incrementing unique ID",
+                        "name": "curid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Keys",
+                        "name": "keys",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Previous keys",
+                        "name": "prevkeys",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/kick": {
+            "get": {
+                "summary": "Device - Kick device user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/kill": {
+            "post": {
+                "summary": "Device - Kill app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/launch": {
+            "post": {
+                "summary": "Device - Launch app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/launchsafariurl": {
+            "post": {
+                "summary": "Device - Launch url in safari app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/list": {
+            "get": {
+                "description": "Registered devices merged with their live state",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Device list",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "online, offline, or healthy for online devices whose provider isn't degraded or reconnecting",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Provider id",
+                        "name": "provider",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Product type, e.g. iPhone13,2",
+                        "name": "productType",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "iOS version prefix, e.g. 14 or 14.2",
+                        "name": "iosVersion",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only online devices nobody has reserved",
+                        "name": "free",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SDevice"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/device/listRestrictedApps": {
+            "get": {
+                "summary": "Device - List device restricted apps",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/longPress": {
+            "post": {
+                "summary": "Device - Long Press coordinate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/mouseDown": {
+            "post": {
+                "summary": "Device - Mouse down",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/mouseUp": {
+            "post": {
+                "summary": "Device - Mouse up",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x",
+                        "name": "x",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y",
+                        "name": "y",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/notices": {
+            "get": {
+                "description": "Device - Device Notices Websocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Waiter id; notifies when the waitlist hands over a device instead",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ]
+            }
+        },
+        "/device/restrictApp": {
+            "post": {
+                "summary": "Device - Restrict app",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "[bundle id]",
+                        "name": "bid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/rotatedevice": {
+            "post": {
+                "summary": "Device - Change orientation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "portrait,portraitUpsideDown,landscapeLeft,landscapeRight",
+                        "name": "orientation",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/screenshot": {
+            "get": {
+                "description": "The newest frame of the device's video stream, or a fresh one from the provider when nothing is streaming",
+                "produces": [
+                    "image/jpeg",
+                    "image/png"
+                ],
+                "summary": "Device - Screenshot",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "jpeg (default) or png",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Shrink factor, above 0 and up to 1",
+                        "name": "scale",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Turn the image upright for the device's orientation",
+                        "name": "rotate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Always ask the provider instead of using the streamed frame",
+                        "name": "fresh",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/shake": {
+            "post": {
+                "summary": "Device shake",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/share": {
+            "post": {
+                "description": "Creates a signed link letting others watch the session, or with perm=control also send input. Links end with the reservation, at expiry, or when revoked from the notices websocket.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Device - Share the reserved session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "view (default) or control",
+                        "name": "perm",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Link lifetime, eg 30m; capped by share.maxTtl",
+                        "name": "ttl",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SShare"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/device/shutdown": {
+            "get": {
+                "summary": "Device - Shutdown device provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/source": {
+            "get": {
+                "summary": "Device - Get device source",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/exists": {
+            "post": {
+                "summary": "Device Status - Existence",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/info": {
+            "post": {
+                "summary": "Device Status - Information",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/videoStarted": {
+            "post": {
+                "summary": "Device Status - Video Started",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/videoStopped": {
+            "post": {
+                "summary": "Device Status - Video Stopped",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/wdaStarted": {
+            "post": {
+                "summary": "Device Status - WDA Started",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/status/wdaStopped": {
+            "post": {
+                "summary": "Device Status - WDA Stopped",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/swipe": {
+            "post": {
+                "summary": "Device - Swipe",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x1",
+                        "name": "x1",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y1",
+                        "name": "y1",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "x2",
+                        "name": "x2",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "y2",
+                        "name": "y2",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Time of swipe",
+                        "name": "delay",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/taskSwitcher": {
+            "post": {
+                "summary": "Device task switcher",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/text": {
+            "post": {
+                "summary": "Device - Simulate entering a block of text",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text to enter",
+                        "name": "text",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/video": {
+            "get": {
+                "summary": "Device - Video Page",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/videoNew": {
+            "get": {
+                "summary": "Device - New Video Page",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/device/videoStop": {
+            "post": {
+                "summary": "Device - Stop device video",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "idle when the page timed out",
+                        "name": "reason",
+                        "in": "query"
+                    }
+                ]
+            }
+        },
+        "/device/ws": {
+            "get": {
+                "description": "Device - Device Command Websocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/login": {
+            "post": {
+                "description": "User - Login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "user",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Password",
+                        "name": "pass",
+                        "in": "formData",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/logout": {
+            "post": {
+                "description": "User - Logout"
+            }
+        },
+        "/provider/device/orientation": {
+            "post": {
+                "summary": "Device Orientation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/provider/device/status/provisionStopped": {
+            "post": {
+                "summary": "Device Status - Provision Stopped",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UDID",
+                        "name": "udid",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/provider/imgStream": {
+            "get": {
+                "description": "Provider - Image Stream Websocket"
+            }
+        },
+        "/provider/login": {
+            "post": {
+                "description": "Provider - Login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "user",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Password",
+                        "name": "pass",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/provider/logout": {
+            "get": {
+                "description": "Provider - Logout"
+            }
+        },
+        "/provider/protocol": {
+            "get": {
+                "description": "Provider - Websocket protocol schema\nJSON Schema of every message sent over /provider/ws and of the\nresponses to them. Providers pick a version with the protocol\nquery parameter of /provider/ws.",
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SProviderProtocol"
+                        }
+                    }
+                }
+            }
+        },
+        "/provider/register": {
+            "post": {
+                "description": "Provider - Register",
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Registration password",
+                        "name": "regPass",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "One-time registration token, used instead of the password; re-registering an existing provider takes one issued for its username",
+                        "name": "regToken",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Provider username",
+                        "name": "username",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SProviderRegistration"
+                        }
+                    }
+                }
+            }
+        },
+        "/provider/rotate": {
+            "post": {
+                "description": "Provider - Rotate password\nReplaces the calling provider's password. A session logged in with\nthe password stays logged in; provider tokens issued before now stop working.",
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SProviderRotate"
+                        }
+                    }
+                }
+            }
+        },
+        "/provider/ws": {
+            "get": {
+                "description": "Provider - Websocket\nMessages follow the schema at /provider/protocol. The negotiated\nprotocol version is returned in the X-Provider-Protocol header.\nOnce connected, providers announce the message types they and\ntheir devices handle with a capabilities message. Device status,\norientation, log, alert and crash messages may be sent at any time\nin place of posting to /provider/device.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma separated protocol versions the provider speaks; 1 if absent",
+                        "name": "protocol",
+                        "in": "query"
+                    }
+                ]
+            }
+        },
+        "/recording": {
+            "get": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Every reservation in which you sent actions to the device, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Recording - List your recordings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SRecordingInfo"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/recording/{rid}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Recording - Get the script of a reservation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SRecording"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/recording/{rid}/replay": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Replays the recorded actions onto udid, which may be the recorded device or another one.\nCoordinates are scaled between the two devices' click sizes. Steps keep their recorded\nspacing divided by speed, starting at once. The replay runs in the background and stops\nat the first failed step or if someone else reserves the device.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Recording - Replay onto a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Target device",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SReplayRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.SReplay"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservation": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Reserves the given udid, or the first free online device matching the other fields when udid is empty.\nReserving a device you already hold renews it. The reservation expires unless renewed within the lease.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Reservation - Reserve a device",
+                "parameters": [
+                    {
+                        "description": "Device or criteria",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SReserveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SReservation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservation/{rid}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Reservation - Release a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reservation/{rid}/renew": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Reservation - Renew lease",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Reservation id",
+                        "name": "rid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SReservation"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/share/stream": {
+            "get": {
+                "description": "Share - Shared Image Stream Websocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Share key",
+                        "name": "key",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/share/watch": {
+            "get": {
+                "summary": "Share - Watch a shared session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Share key",
+                        "name": "key",
+                        "in": "query",
+                        "required": true
+                    }
+                ]
+            }
+        },
+        "/videos": {
+            "get": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Video - List your video recordings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SVideoRecording"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/videos/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "The video is MJPEG: JPEG frames back to back. part=index instead returns one line per frame\nwith its server time in unix milliseconds, byte offset, and size.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "summary": "Video - Download a video recording",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Video recording id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "index for the frame index",
+                        "name": "part",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Video - Delete a video recording",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Video recording id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/waitlist": {
+            "post": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "description": "Queues for the given udid, or for any device matching the other fields when udid is empty.\nIf a suitable device is free it is granted at once. Otherwise poll the waiter or\nlisten on /device/notices?wait={id} for a \"reservation\" \"granted\" notice.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Waitlist - Join",
+                "parameters": [
+                    {
+                        "description": "Device or pool criteria",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SReserveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SWaiter"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/waitlist/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Waitlist - Position",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Waiter id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SWaiter"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Waitlist - Leave",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Waiter id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiSuccess"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.SApiError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.SApiApp": {
+            "type": "object",
+            "required": [
+                "bid",
+                "udid"
+            ],
+            "properties": {
+                "bid": {
+                    "type": "string",
+                    "example": "com.apple.Preferences"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiDevice": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiError": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "provider_offline"
+                },
+                "reservedBy": {
+                    "type": "string",
+                    "example": "someuser"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "main.SApiKeys": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "curid": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "keys": {
+                    "type": "string",
+                    "example": "abc"
+                },
+                "prevkeys": {
+                    "type": "string"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiLongPress": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "time": {
+                    "type": "number",
+                    "example": 1.5
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "x": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "y": {
+                    "type": "integer",
+                    "example": 200
+                }
+            }
+        },
+        "main.SApiOrientation": {
+            "type": "object",
+            "required": [
+                "orientation",
+                "udid"
+            ],
+            "properties": {
+                "orientation": {
+                    "type": "string",
+                    "example": "portrait"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiPoint": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "x": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "y": {
+                    "type": "integer",
+                    "example": 200
+                }
+            }
+        },
+        "main.SApiRestart": {
+            "type": "object",
+            "properties": {
+                "result": {
+                    "type": "string",
+                    "example": "true"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiSuccess": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "main.SApiSwipe": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "delay": {
+                    "type": "number",
+                    "example": 0.5
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "x1": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "x2": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "y1": {
+                    "type": "integer",
+                    "example": 600
+                },
+                "y2": {
+                    "type": "integer",
+                    "example": 200
+                }
+            }
+        },
+        "main.SApiText": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "text": {
+                    "type": "string",
+                    "example": "hello"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SApiUrl": {
+            "type": "object",
+            "required": [
+                "udid",
+                "url"
+            ],
+            "properties": {
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com"
+                }
+            }
+        },
+        "main.SApiWifiIp": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "type": "string",
+                    "example": "192.168.1.20"
+                },
+                "mac": {
+                    "type": "string",
+                    "example": "b0:8c:75:75:aa:a4"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "wdaPort": {
+                    "type": "integer",
+                    "example": 8100
+                }
+            }
+        },
+        "main.SAuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "click"
+                },
+                "latencyMs": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "params": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "result": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "time": {
+                    "type": "string",
+                    "example": "2021-06-01 10:00:00"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "user": {
+                    "type": "string",
+                    "example": "someuser"
+                }
+            }
+        },
+        "main.SDevice": {
+            "type": "object",
+            "properties": {
+                "cfaStatus": {
+                    "type": "string",
+                    "example": "up"
+                },
+                "iosVersion": {
+                    "type": "string",
+                    "example": "14.2.1"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "My Device"
+                },
+                "online": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "orientation": {
+                    "type": "string",
+                    "example": "portrait"
+                },
+                "productType": {
+                    "type": "string",
+                    "example": "iPhone13,2"
+                },
+                "provider": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "providerPingMs": {
+                    "type": "integer",
+                    "example": 15
+                },
+                "providerState": {
+                    "type": "string",
+                    "example": "healthy"
+                },
+                "reservedBy": {
+                    "type": "string",
+                    "example": "someuser"
+                },
+                "reservedSeconds": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "videoStatus": {
+                    "type": "string",
+                    "example": "up"
+                },
+                "wdaStatus": {
+                    "type": "string",
+                    "example": "up"
                 }
             }
         },
-        "/provider/ws": {
-            "get": {
-                "description": "Provider - Websocket"
-            }
-        }
-    },
-    "definitions": {
         "main.SDeviceInfo": {
             "type": "object",
             "properties": {
@@ -857,6 +4614,18 @@ var doc = `{
                     "type": "string",
                     "example": "up"
                 },
+                "features": {
+                    "description": "Message types the device supports; absent when its provider didn't say",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "click",
+                        "home",
+                        "screenshot"
+                    ]
+                },
                 "name": {
                     "type": "string",
                     "example": "Phone Name"
@@ -890,6 +4659,73 @@ var doc = `{
                 }
             }
         },
+        "main.SProviderMessageType": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Tap a point"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "server"
+                },
+                "request": {
+                    "type": "string",
+                    "example": "#/definitions/click"
+                },
+                "response": {
+                    "type": "string",
+                    "example": "#/definitions/click.response"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "click"
+                }
+            }
+        },
+        "main.SProviderProtocol": {
+            "type": "object",
+            "properties": {
+                "$schema": {
+                    "type": "string"
+                },
+                "definitions": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "object",
+                        "additionalProperties": true
+                    }
+                },
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SProviderMessageType"
+                    }
+                },
+                "oneOf": {
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "additionalProperties": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "title": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                },
+                "versions": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
         "main.SProviderRegistration": {
             "type": "object",
             "properties": {
@@ -906,6 +4742,325 @@ var doc = `{
                     "example": true
                 }
             }
+        },
+        "main.SProviderRotate": {
+            "type": "object",
+            "properties": {
+                "Password": {
+                    "type": "string",
+                    "example": "huefw3fw3"
+                },
+                "Success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "main.SRecordedStep": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "click"
+                },
+                "offsetMs": {
+                    "type": "integer",
+                    "example": 1530
+                },
+                "params": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.SRecording": {
+            "type": "object",
+            "properties": {
+                "rid": {
+                    "type": "string",
+                    "example": "XVlBzgbaiC"
+                },
+                "start": {
+                    "type": "string",
+                    "example": "2021-06-01 10:00:00"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SRecordedStep"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "user": {
+                    "type": "string",
+                    "example": "someuser"
+                }
+            }
+        },
+        "main.SRecordingInfo": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "string",
+                    "example": "2021-06-01 10:30:00"
+                },
+                "rid": {
+                    "type": "string",
+                    "example": "XVlBzgbaiC"
+                },
+                "start": {
+                    "type": "string",
+                    "example": "2021-06-01 10:00:00"
+                },
+                "steps": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SReplay": {
+            "type": "object",
+            "properties": {
+                "seconds": {
+                    "type": "number",
+                    "example": 95.5
+                },
+                "steps": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "main.SReplayRequest": {
+            "type": "object",
+            "required": [
+                "udid"
+            ],
+            "properties": {
+                "speed": {
+                    "type": "number",
+                    "example": 1
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SReservation": {
+            "type": "object",
+            "properties": {
+                "expires": {
+                    "type": "string",
+                    "example": "2021-06-01T12:10:00Z"
+                },
+                "rid": {
+                    "type": "string",
+                    "example": "XVlBzgbaiC"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "user": {
+                    "type": "string",
+                    "example": "someuser"
+                }
+            }
+        },
+        "main.SReserveRequest": {
+            "type": "object",
+            "properties": {
+                "iosVersion": {
+                    "type": "string",
+                    "example": "14.2"
+                },
+                "productType": {
+                    "type": "string",
+                    "example": "iPhone13,2"
+                },
+                "provider": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        },
+        "main.SShare": {
+            "type": "object",
+            "properties": {
+                "expires": {
+                    "type": "integer",
+                    "example": 1617900000
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3f2a9c0d1b7e4a65"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "perm": {
+                    "type": "string",
+                    "example": "view"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.SUsageReport": {
+            "type": "object",
+            "properties": {
+                "by": {
+                    "type": "string",
+                    "example": "device"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "2021-06-01"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SUsageRow"
+                    }
+                },
+                "to": {
+                    "type": "string",
+                    "example": "2021-06-30"
+                }
+            }
+        },
+        "main.SUsageRow": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "My Device"
+                },
+                "reservations": {
+                    "type": "integer",
+                    "example": 14
+                },
+                "seconds": {
+                    "type": "integer",
+                    "example": 5400
+                },
+                "utilization": {
+                    "type": "number",
+                    "example": 0.0625
+                }
+            }
+        },
+        "main.SVideoRecording": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer",
+                    "example": 450000000
+                },
+                "end": {
+                    "type": "string",
+                    "example": "2021-06-01 10:30:00"
+                },
+                "frames": {
+                    "type": "integer",
+                    "example": 9000
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "recording": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "rid": {
+                    "type": "string",
+                    "example": "XVlBzgbaiC"
+                },
+                "start": {
+                    "type": "string",
+                    "example": "2021-06-01 10:00:00"
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                },
+                "user": {
+                    "type": "string",
+                    "example": "someuser"
+                }
+            }
+        },
+        "main.SWaiter": {
+            "type": "object",
+            "properties": {
+                "granted": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "position": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "rid": {
+                    "type": "string",
+                    "example": "XVlBzgbaiC"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "udid": {
+                    "type": "string",
+                    "example": "00008100-001338811EE10033"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiToken": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -926,7 +5081,7 @@ var SwaggerInfo = swaggerInfo{
 	BasePath:    "",
 	Schemes:     []string{},
 	Title:       "ControlFloor API",
-	Description: "ControlFloor Server API",
+	Description: "\"Bearer \" followed by a token created under /admin/tokens or with the tokenAdd command",
 }
 
 type s struct{}
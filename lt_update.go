@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -15,7 +16,7 @@ type ProvSafariTestMsg struct {
 	Id   int16  `json:"id"`
 	Type string `json:"type"`
 	Udid string `json:"udid"`
-	Url  string `json:"url"`
+	Url  string `json:"url" desc:"URL to open"`
 }
 
 type ProvSafariUrl struct {
@@ -43,7 +44,7 @@ type ProvRotateDeviceTestMsg struct {
 	Id          int16  `json:"id"`
 	Type        string `json:"type"`
 	Udid        string `json:"udid"`
-	Orientation string `json:"orientation"`
+	Orientation string `json:"orientation" desc:"Orientation to rotate the device to"`
 }
 type ProvRotateDevice struct {
 	udid        string
@@ -128,19 +129,26 @@ func (self *DevHandler) handleDeviceRefresh(c *gin.Context) {
 	provId := self.devTracker.getDevProvId(udid)
 	pc := self.devTracker.getProvConn(provId)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	refresh := "unknown"
+	done := make(chan string, 1)
 
-	pc.doRefresh(udid, func(_ uj.JNode, json []byte) {
-		root, _ := uj.Parse(json)
+	refresh := "unknown"
 
-		refresh = root.Get("refresh").String()
+	pc.doRefresh(ctx, udid, func(root uj.JNode, _ []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			refresh = root.Get("refresh").String()
+		}
 
-		done <- true
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	//
 
@@ -172,18 +180,25 @@ func (self *DevHandler) handleDeviceRestart(c *gin.Context) {
 	provId := self.devTracker.getDevProvId(udid)
 	pc := self.devTracker.getProvConn(provId)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	restart := "false"
-	pc.doRestart(udid, func(_ uj.JNode, json []byte) {
-		root, _ := uj.Parse(json)
+	done := make(chan string, 1)
 
-		restart = root.Get("restart").String()
+	restart := "false"
+	pc.doRestart(ctx, udid, func(root uj.JNode, _ []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			restart = root.Get("restart").String()
+		}
 
-		done <- true
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	//
 
@@ -201,13 +216,19 @@ func (self *DevHandler) handleSafariUrl(c *gin.Context) {
 	url := c.PostForm("url")
 	pc, udid := self.getPc(c)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doOpenSafariUrl(udid, url, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doOpenSafariUrl(ctx, udid, url, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -222,13 +243,19 @@ func (self *DevHandler) handleBrowserCleanup(c *gin.Context) {
 	bid := c.PostForm("bid")
 	pc, udid := self.getPc(c)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	pc.doBrowserCleanup(udid, bid, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doBrowserCleanup(ctx, udid, bid, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -243,13 +270,19 @@ func (self *DevHandler) handleRotateDevice(c *gin.Context) {
 	orientation := c.PostForm("orientation")
 	pc, udid := self.getPc(c)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
+
+	done := make(chan string, 1)
 
-	pc.doRotateDevice(udid, orientation, func(uj.JNode, []byte) {
-		done <- true
+	pc.doRotateDevice(ctx, udid, orientation, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	c.HTML(http.StatusOK, "error", gin.H{
 		"text": "ok",
@@ -278,18 +311,25 @@ func (self *DevHandler) handleStreamingRestart(c *gin.Context) {
 	provId := self.devTracker.getDevProvId(udid)
 	pc := self.devTracker.getProvConn(provId)
 
-	done := make(chan bool)
+	ctx, cancel := self.provCtx(c)
+	defer cancel()
 
-	restart := "false"
-	pc.doRestart(udid, func(_ uj.JNode, json []byte) {
-		root, _ := uj.Parse(json)
+	done := make(chan string, 1)
 
-		restart = root.Get("restart").String()
+	restart := "false"
+	pc.doRestart(ctx, udid, func(root uj.JNode, _ []byte) {
+		reqErr := resError(root)
+		if reqErr == "" {
+			restart = root.Get("restart").String()
+		}
 
-		done <- true
+		done <- reqErr
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		provFail(c, reqErr)
+		return
+	}
 
 	//
 
@@ -306,6 +346,8 @@ var upGrader = ws.Upgrader{
 }
 
 func (self *DevHandler) echo(c *gin.Context) {
+	user := self.apiUser(c)
+	base := withAuditUser(context.Background(), user)
 
 	conn, err := upGrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -325,13 +367,26 @@ func (self *DevHandler) echo(c *gin.Context) {
 		var msg map[string]interface{}
 		json.Unmarshal(message, &msg)
 
+		// Each message names its own device, so the route check on the udid
+		// the socket was opened with isn't enough; apply the same rule as the
+		// input routes to every message
+		if refusal := echoRefusal(msg, user); refusal != "" {
+			msg["error"] = refusal
+			refused, _ := json.Marshal(msg)
+			if err = conn.WriteMessage(msgType, refused); err != nil {
+				log.Println("write:", err)
+				return
+			}
+			continue
+		}
+
 		if msg["event"] == "click" {
 
 			udid := msg["udid"].(string)
 			x := int(msg["x"].(float64))
 			y := int(msg["y"].(float64))
 
-			self.handleDevClickWS(udid, x, y)
+			self.handleDevClickWS(base, udid, x, y)
 
 		} else if msg["event"] == "swipe" {
 
@@ -342,7 +397,7 @@ func (self *DevHandler) echo(c *gin.Context) {
 			y2 := int(msg["y2"].(float64))
 			delay := msg["delay"].(float64)
 
-			self.handleDevSwipeWS(udid, x1, y1, x2, y2, delay)
+			self.handleDevSwipeWS(base, udid, x1, y1, x2, y2, delay)
 
 		} else if msg["event"] == "keys" {
 
@@ -351,7 +406,7 @@ func (self *DevHandler) echo(c *gin.Context) {
 			curid := int(msg["curid"].(float64))
 			prevkeys := msg["prevkeys"].(string)
 
-			self.handleKeysWS(udid, keys, curid, prevkeys)
+			self.handleKeysWS(base, udid, keys, curid, prevkeys)
 
 		}
 
@@ -364,12 +419,27 @@ func (self *DevHandler) echo(c *gin.Context) {
 	}
 }
 
+// Why an echo message may not be acted on; "" if it may
+func echoRefusal(msg map[string]interface{}, user string) string {
+	if msg["event"] == nil {
+		return ""
+	}
+	udid, ok := msg["udid"].(string)
+	if !ok || udid == "" {
+		return "missing_udid"
+	}
+	if rv := getReservation(udid); rv != nil && rv.User != user {
+		return "reserved"
+	}
+	return ""
+}
+
 // @Summary Device - Click coordinate
 // @Router /device/click [POST]
 // @Param udid formData string true "Device UDID"
 // @Param x formData int true "x"
 // @Param y formData int true "y"
-func (self *DevHandler) handleDevClickWS(udid string, x int, y int) {
+func (self *DevHandler) handleDevClickWS(base context.Context, udid string, x int, y int) {
 
 	pc, udid := self.getPcWS(udid)
 	if pc == nil {
@@ -377,13 +447,18 @@ func (self *DevHandler) handleDevClickWS(udid string, x int, y int) {
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := context.WithTimeout(base, self.config.providerTimeout)
+	defer cancel()
 
-	pc.doClick(udid, x, y, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doClick(ctx, udid, x, y, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		fmt.Printf("click : %s\n", reqErr)
+	}
 
 }
 
@@ -395,7 +470,7 @@ func (self *DevHandler) handleDevClickWS(udid string, x int, y int) {
 // @Param x2 formData int true "x2"
 // @Param y2 formData int true "y2"
 // @Param delay formData number true "Time of swipe"
-func (self *DevHandler) handleDevSwipeWS(udid string, x1 int, y1 int, x2 int, y2 int, delay float64) {
+func (self *DevHandler) handleDevSwipeWS(base context.Context, udid string, x1 int, y1 int, x2 int, y2 int, delay float64) {
 
 	pc, udid := self.getPcWS(udid)
 	if pc == nil {
@@ -403,13 +478,18 @@ func (self *DevHandler) handleDevSwipeWS(udid string, x1 int, y1 int, x2 int, y2
 		return
 	}
 
-	done := make(chan bool)
+	ctx, cancel := context.WithTimeout(base, self.config.providerTimeout)
+	defer cancel()
 
-	pc.doSwipe(udid, x1, y1, x2, y2, delay, func(uj.JNode, []byte) {
-		done <- true
+	done := make(chan string, 1)
+
+	pc.doSwipe(ctx, udid, x1, y1, x2, y2, delay, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		fmt.Printf("swipe : %s\n", reqErr)
+	}
 
 }
 
@@ -419,9 +499,12 @@ func (self *DevHandler) handleDevSwipeWS(udid string, x1 int, y1 int, x2 int, y2
 // @Param curid formData int true "Incrementing unique ID"
 // @Param keys formData string true "Keys"
 // @Param prevkeys formData string true "Previous keys"
-func (self *DevHandler) handleKeysWS(udid string, keys string, curid int, prevkeys string) {
+func (self *DevHandler) handleKeysWS(base context.Context, udid string, keys string, curid int, prevkeys string) {
 
-	done := make(chan bool)
+	ctx, cancel := context.WithTimeout(base, self.config.providerTimeout)
+	defer cancel()
+
+	done := make(chan string, 1)
 
 	pc, udid := self.getPcWS(udid)
 	if pc == nil {
@@ -429,11 +512,13 @@ func (self *DevHandler) handleKeysWS(udid string, keys string, curid int, prevke
 		return
 	}
 
-	pc.doKeys(udid, keys, curid, prevkeys, func(uj.JNode, []byte) {
-		done <- true
+	pc.doKeys(ctx, udid, keys, curid, prevkeys, func(root uj.JNode, _ []byte) {
+		done <- resError(root)
 	})
 
-	<-done
+	if reqErr := <-done; reqErr != "" {
+		fmt.Printf("keys : %s\n", reqErr)
+	}
 
 }
 
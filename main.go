@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/nanoscopic/controlfloor/docs"
@@ -20,7 +23,73 @@ func main() {
 	uclop.AddCmd("run", "Run ControlFloor", runMain, nil)
 	uclop.AddCmd("devs", "List registered devices", runListDevs, nil)
 	uclop.AddCmd("prov", "List providers", runListProv, nil)
+	uclop.AddCmd("provRotate", "Give a provider a new password", runRotateProv, uc.OPTS{
+		uc.OPT("-user", "Provider username", uc.REQ),
+	})
+	uclop.AddCmd("provDisable", "Disable a provider", runDisableProv, uc.OPTS{
+		uc.OPT("-user", "Provider username", uc.REQ),
+	})
+	uclop.AddCmd("provEnable", "Re-enable a disabled provider", runEnableProv, uc.OPTS{
+		uc.OPT("-user", "Provider username", uc.REQ),
+	})
+	uclop.AddCmd("users", "List users", runListUsers, nil)
+	uclop.AddCmd("userAdd", "Create a user", runAddUser, uc.OPTS{
+		uc.OPT("-user", "Username", uc.REQ),
+		uc.OPT("-pass", "Password", uc.REQ),
+		uc.OPT("-role", "viewer, tester, or admin", uc.REQ),
+	})
+	uclop.AddCmd("userPass", "Set a user's password", runUserPass, uc.OPTS{
+		uc.OPT("-user", "Username", uc.REQ),
+		uc.OPT("-pass", "New password", uc.REQ),
+	})
+	uclop.AddCmd("userRole", "Change a user's role", runUserRole, uc.OPTS{
+		uc.OPT("-user", "Username", uc.REQ),
+		uc.OPT("-role", "viewer, tester, or admin", uc.REQ),
+	})
+	uclop.AddCmd("userDisable", "Disable a user", runDisableUser, uc.OPTS{
+		uc.OPT("-user", "Username", uc.REQ),
+	})
+	uclop.AddCmd("userEnable", "Re-enable a disabled user", runEnableUser, uc.OPTS{
+		uc.OPT("-user", "Username", uc.REQ),
+	})
+	uclop.AddCmd("provCert", "Issue a client certificate to a provider", runProvCert, uc.OPTS{
+		uc.OPT("-user", "Provider username", uc.REQ),
+		uc.OPT("-days", "Days the certificate is valid; defaults to providerAuth.certDays", 0),
+		uc.OPT("-out", "Path prefix for the .crt and .key files; defaults to the username", 0),
+	})
+	uclop.AddCmd("provCerts", "List provider certificates", runListProvCerts, nil)
+	uclop.AddCmd("provCertRevoke", "Revoke a provider certificate", runRevokeProvCert, uc.OPTS{
+		uc.OPT("-serial", "Certificate serial", uc.REQ),
+	})
+	uclop.AddCmd("provToken", "Issue a signed token a provider authenticates with", runProvToken, uc.OPTS{
+		uc.OPT("-user", "Provider username", uc.REQ),
+		uc.OPT("-ttl", "Lifetime, such as 720h; defaults to providerAuth.tokenTtl", 0),
+	})
+	uclop.AddCmd("protocol", "Print the JSON Schema of the provider websocket protocol", runProtocol, nil)
+	uclop.AddCmd("regPass", "Set the provider registration password", runSetRegPass, uc.OPTS{
+		uc.OPT("-pass", "New password", uc.REQ),
+	})
+	uclop.AddCmd("regOpen", "Allow providers to register with the password", runRegOpen, nil)
+	uclop.AddCmd("regClose", "Only allow providers to register with a token", runRegClose, nil)
+	uclop.AddCmd("regTokens", "List provider registration tokens", runListRegTokens, nil)
+	uclop.AddCmd("regTokenAdd", "Create a one-time provider registration token", runAddRegToken, uc.OPTS{
+		uc.OPT("-note", "Which provider host the token is for", 0),
+		uc.OPT("-user", "Only allow registering this provider username", 0),
+		uc.OPT("-ttl", "Lifetime, such as 24h; defaults to registration.tokenTtl", 0),
+	})
+	uclop.AddCmd("regTokenRevoke", "Revoke a provider registration token", runRevokeRegToken, uc.OPTS{
+		uc.OPT("-id", "Token id", uc.REQ),
+	})
 	uclop.AddCmd("conf", "Dump configuration", runDumpConf, nil)
+	uclop.AddCmd("tokens", "List API tokens", runListTokens, nil)
+	uclop.AddCmd("tokenAdd", "Create an API token", runAddToken, uc.OPTS{
+		uc.OPT("-user", "User the token acts as", uc.REQ),
+		uc.OPT("-name", "Token description", 0),
+		uc.OPT("-admin", "Allow the token on admin routes", uc.FLAG),
+	})
+	uclop.AddCmd("tokenRevoke", "Revoke an API token", runRevokeToken, uc.OPTS{
+		uc.OPT("-id", "Token id", uc.REQ),
+	})
 	uclop.Run()
 }
 
@@ -54,11 +123,301 @@ func runListProv(*uc.Cmd) {
 	}
 
 	for _, prov := range provs {
-		fmt.Printf("Username: %s\nProvider Id: %d\n\n",
-			prov.Username, prov.Id)
+		fmt.Printf("Username: %s\nProvider Id: %d\nDisabled: %t\nLast login: %s\nFailed logins: %d\n\n",
+			prov.Username, prov.Id, prov.Disabled, formatTokenTime(prov.LastLogin), prov.LoginFailures)
+	}
+}
+
+func runRotateProv(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	pass, ok := rotateProviderPassword(user)
+	if !ok {
+		fmt.Printf("No provider %s\n", user)
+		os.Exit(1)
+	}
+	fmt.Printf("Password: %s\nThe password is not stored and cannot be shown again.\n", pass)
+}
+
+func runDisableProv(cmd *uc.Cmd) {
+	setProvDisabled(cmd.Get("-user").String(), true)
+}
+
+func runEnableProv(cmd *uc.Cmd) {
+	setProvDisabled(cmd.Get("-user").String(), false)
+}
+
+func setProvDisabled(user string, disabled bool) {
+	openDbConnection()
+
+	if !disableProvider(user, disabled) {
+		fmt.Printf("No provider %s\n", user)
+		os.Exit(1)
+	}
+	if disabled {
+		fmt.Printf("Disabled provider %s\n", user)
+	} else {
+		fmt.Printf("Enabled provider %s\n", user)
+	}
+}
+
+func runListUsers(*uc.Cmd) {
+	openDbConnection()
+
+	users, err := getUsers()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, user := range users {
+		printUser(user)
+	}
+}
+
+// Report the outcome of a user change, exiting with an error status if it failed
+func userCmdResult(err error, done string) {
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", done)
+}
+
+func runAddUser(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	role := cmd.Get("-role").String()
+	userCmdResult(createUser(user, cmd.Get("-pass").String(), role), "Created "+role+" "+user)
+}
+
+func runUserPass(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	userCmdResult(changeUserPassword(user, cmd.Get("-pass").String()), "Set password of "+user)
+}
+
+func runUserRole(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	role := cmd.Get("-role").String()
+	userCmdResult(changeUserRole(user, role), "Made "+user+" "+role)
+}
+
+func runDisableUser(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	userCmdResult(changeUserDisabled(user, true), "Disabled "+user)
+}
+
+func runEnableUser(cmd *uc.Cmd) {
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	userCmdResult(changeUserDisabled(user, false), "Enabled "+user)
+}
+
+func runProvCert(cmd *uc.Cmd) {
+	conf := NewConfig("config.json", "default.json")
+	openDbConnection()
+
+	user := cmd.Get("-user").String()
+	days := conf.provCertDays
+	if str := cmd.Get("-days").String(); str != "" {
+		days = cmd.Get("-days").Int()
+	}
+	out := cmd.Get("-out").String()
+	if out == "" {
+		out = user
+	}
+
+	certPem, keyPem, dbCert, err := issueProviderCert(conf, user, days)
+	if err != nil {
+		fmt.Printf("Could not issue certificate: %s\n", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(out+".key", keyPem, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(out+".crt", certPem, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Could not write certificate: %s\n", err)
+		os.Exit(1)
+	}
+
+	printProviderCert(*dbCert)
+	fmt.Printf("Wrote %s.crt and %s.key\n", out, out)
+}
+
+func runListProvCerts(*uc.Cmd) {
+	openDbConnection()
+
+	certs, err := getProviderCerts()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, cert := range certs {
+		printProviderCert(cert)
+	}
+}
+
+func runRevokeProvCert(cmd *uc.Cmd) {
+	openDbConnection()
+
+	serial := cmd.Get("-serial").String()
+	if !revokeProviderCert(serial) {
+		fmt.Printf("No certificate with serial %s\n", serial)
+		os.Exit(1)
+	}
+	fmt.Printf("Revoked certificate %s\n", serial)
+}
+
+func runProtocol(cmd *uc.Cmd) {
+	text, _ := json.MarshalIndent(provProtocolSchema(), "", "  ")
+	fmt.Println(string(text))
+}
+
+func runProvToken(cmd *uc.Cmd) {
+	conf := NewConfig("config.json", "default.json")
+	openDbConnection()
+
+	ttl := conf.provTokenTtl
+	if str := cmd.Get("-ttl").String(); str != "" {
+		var err error
+		ttl, err = time.ParseDuration(str)
+		if err != nil || ttl <= 0 {
+			fmt.Printf("Invalid lifetime %s\n", str)
+			os.Exit(1)
+		}
+	}
+
+	user := cmd.Get("-user").String()
+	token, ok := issueProviderToken(conf, user, ttl)
+	if !ok {
+		fmt.Printf("No provider %s\n", user)
+		os.Exit(1)
+	}
+	fmt.Printf("Token: %s\nSend it as \"Authorization: Bearer <token>\". It stops working when the provider's password is rotated.\n", token)
+	if !conf.provAuthToken {
+		fmt.Printf("providerAuth.token is off in config.json, so the token is not accepted yet\n")
 	}
 }
 
+func runSetRegPass(cmd *uc.Cmd) {
+	conf := NewConfig("config.json", "default.json")
+	openDbConnection()
+
+	setRegPass(cmd.Get("-pass").String())
+	fmt.Printf("Set registration password\n")
+	if _, fromConfig := regPassword(conf); fromConfig {
+		fmt.Printf("registration.password in config.json takes precedence over it\n")
+	}
+}
+
+func runRegOpen(*uc.Cmd) {
+	conf := NewConfig("config.json", "default.json")
+	openDbConnection()
+
+	setRegClosed(false)
+	fmt.Printf("Opened password registration\n")
+	if !conf.regOpen {
+		fmt.Printf("registration.open in config.json still keeps it closed\n")
+	}
+}
+
+func runRegClose(*uc.Cmd) {
+	openDbConnection()
+
+	setRegClosed(true)
+	fmt.Printf("Closed password registration; providers need a token to register\n")
+}
+
+func runListRegTokens(*uc.Cmd) {
+	openDbConnection()
+
+	tokens, err := getRegTokens()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, token := range tokens {
+		printRegToken(token)
+	}
+}
+
+func runAddRegToken(cmd *uc.Cmd) {
+	conf := NewConfig("config.json", "default.json")
+	openDbConnection()
+
+	ttl := conf.regTokenTtl
+	if str := cmd.Get("-ttl").String(); str != "" {
+		var err error
+		ttl, err = time.ParseDuration(str)
+		if err != nil || ttl <= 0 {
+			fmt.Printf("Invalid lifetime %s\n", str)
+			os.Exit(1)
+		}
+	}
+
+	token, dbToken := createRegToken(cmd.Get("-note").String(), cmd.Get("-user").String(), ttl)
+
+	printRegToken(*dbToken)
+	fmt.Printf("Token: %s\nThe token is not stored and cannot be shown again.\n", token)
+}
+
+func runRevokeRegToken(cmd *uc.Cmd) {
+	openDbConnection()
+
+	id := int64(cmd.Get("-id").Int())
+	if !revokeRegToken(id) {
+		fmt.Printf("No registration token with id %d\n", id)
+		os.Exit(1)
+	}
+	fmt.Printf("Revoked registration token %d\n", id)
+}
+
+func runListTokens(*uc.Cmd) {
+	openDbConnection()
+
+	tokens, err := getApiTokens()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, token := range tokens {
+		printApiToken(token)
+	}
+}
+
+func runAddToken(cmd *uc.Cmd) {
+	openDbConnection()
+
+	token, dbToken := createApiToken(
+		cmd.Get("-user").String(),
+		cmd.Get("-name").String(),
+		cmd.Get("-admin").Bool(),
+	)
+
+	printApiToken(*dbToken)
+	fmt.Printf("Token: %s\nThe token is not stored and cannot be shown again.\n", token)
+}
+
+func runRevokeToken(cmd *uc.Cmd) {
+	openDbConnection()
+
+	id := int64(cmd.Get("-id").Int())
+	if !revokeApiToken(id) {
+		fmt.Printf("No token with id %d\n", id)
+		os.Exit(1)
+	}
+	fmt.Printf("Revoked token %d\n", id)
+}
+
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -80,6 +439,9 @@ func runMain(*uc.Cmd) {
 	conf := NewConfig("config.json", "default.json")
 
 	openDbConnection()
+	startAuditor(conf)
+	startRecorder()
+	startVideoRecorder(conf)
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
@@ -87,13 +449,15 @@ func runMain(*uc.Cmd) {
 
 	initTemplates(r, conf)
 	r.Static("/assets", "./assets")
-	sessionManager := NewSessionManager(r)
+	sessionManager := NewSessionManager(r, conf)
 
 	devTracker := NewDevTracker(conf)
 
 	var authHandler cfauth.AuthHandler
 	if conf.auth == "mod" {
 		authHandler = cfauth.NewAuthHandler(conf.root, sessionManager)
+	} else if users, _ := getUsers(); len(users) == 0 {
+		fmt.Printf("No users can log in yet; create one with: userAdd -user NAME -pass PASS -role admin\n")
 	}
 
 	var adminHandler adminauth.AuthHandler
@@ -107,11 +471,17 @@ func runMain(*uc.Cmd) {
 	ah := NewAdminHandler(adminHandler, r, devTracker, sessionManager, conf)
 	aAuth := ah.registerAdminRoutes()
 
-	ph := NewProviderHandler(r, devTracker, sessionManager)
+	ph := NewProviderHandler(r, devTracker, sessionManager, conf)
 	pAuth := ph.registerProviderRoutes()
 
-	dh := NewDevHandler(pAuth, uAuth, aAuth, devTracker, sessionManager, conf)
+	sAuth := uAuth.Group("/share")
+	if conf.shareAnonymous {
+		sAuth = r.Group("/share")
+	}
+
+	dh := NewDevHandler(pAuth, uAuth, aAuth, sAuth, devTracker, sessionManager, conf)
 	dh.registerDeviceRoutes()
+	startReservationReaper(devTracker, conf.reservationLease)
 
 	th := NewTestHandler(r, sessionManager)
 	th.registerTestRoutes()
@@ -133,7 +503,11 @@ func runMain(*uc.Cmd) {
 		if conf.crt == "server.crt" && !fileExists("server.crt") {
 			gen_cert()
 		}
-		err = http.ListenAndServeTLS(conf.listen, conf.crt, conf.key, r)
+		server := &http.Server{Addr: conf.listen, Handler: r}
+		if conf.provAuthCert {
+			server.TLSConfig = providerTLSConfig(conf)
+		}
+		err = server.ListenAndServeTLS(conf.crt, conf.key)
 	} else {
 		err = http.ListenAndServe(conf.listen, r)
 	}
@@ -164,3 +538,7 @@ func censorUuid(uuid string) string {
 // @title ControlFloor API
 // @version 1.0
 // @description ControlFloor Server API
+// @securityDefinitions.apikey ApiToken
+// @in header
+// @name Authorization
+// @description "Bearer " followed by a token created under /admin/tokens or with the tokenAdd command
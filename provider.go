@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,25 +17,30 @@ import (
 )
 
 type ProviderOb struct {
-	User string
-	Id   int64
+	User  string
+	Id    int64
+	Login time.Time
+	Cert  string // serial of the client certificate used, if any
 }
 
 type ProviderHandler struct {
 	r              *gin.Engine
 	devTracker     *DevTracker
 	sessionManager *cfSessionManager
+	config         *Config
 }
 
 func NewProviderHandler(
 	r *gin.Engine,
 	devTracker *DevTracker,
 	sessionManager *cfSessionManager,
+	config *Config,
 ) *ProviderHandler {
 	return &ProviderHandler{
 		r,
 		devTracker,
 		sessionManager,
+		config,
 	}
 }
 
@@ -46,10 +52,12 @@ func (self *ProviderHandler) registerProviderRoutes() *gin.RouterGroup {
 	r.GET("/provider/login", self.showProviderLogin)
 	r.GET("/provider/logout", self.handleProviderLogout)
 	r.POST("/provider/login", self.handleProviderLogin)
+	r.GET("/provider/protocol", self.showProtocol)
 
 	pAuth := r.Group("/provider")
 	pAuth.Use(self.NeedProviderAuth())
 	pAuth.GET("/", self.showProviderRoot)
+	pAuth.POST("/rotate", self.handleRotate)
 	pAuth.GET("/ws", func(c *gin.Context) {
 		self.handleProviderWS(c)
 	})
@@ -62,20 +70,29 @@ func (self *ProviderHandler) registerProviderRoutes() *gin.RouterGroup {
 
 func (self *ProviderHandler) NeedProviderAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sCtx := self.sessionManager.GetSession(c)
-
-		_, ok := self.sessionManager.session.Get(sCtx, "provider").(ProviderOb)
-		// provider not used
+		provider, reason := self.authProvider(c)
+
+		if reason != "" {
+			log.WithFields(log.Fields{
+				"type":   "provider_auth_fail",
+				"reason": reason,
+				"ip":     c.ClientIP(),
+			}).Warn("Provider credentials refused")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, SApiError{
+				Success: false,
+				Err:     reason,
+			})
+			return
+		}
 
-		if !ok {
+		if provider == nil {
 			c.Redirect(302, "/provider/login")
 			c.Abort()
 			fmt.Println("provider fail")
 			return
-		} else {
-			//fmt.Printf("provider user=%s\n", provider.User )
 		}
 
+		c.Set("provider", *provider)
 		c.Next()
 	}
 }
@@ -86,9 +103,7 @@ var wsupgrader = ws.Upgrader{
 }
 
 const (
-	CMKick  = iota
-	CMPing  = iota
-	CMFrame = iota
+	CMKick = iota
 )
 
 type ClientMsg struct {
@@ -96,12 +111,6 @@ type ClientMsg struct {
 	msg     string
 }
 
-type FrameMsg struct {
-	msg       int
-	frame     []byte
-	frameType int
-}
-
 // @Description Provider - Image Stream Websocket
 // @Router /provider/imgStream [GET]
 func (self *ProviderHandler) handleImgProvider(c *gin.Context) {
@@ -123,302 +132,186 @@ func (self *ProviderHandler) handleImgProvider(c *gin.Context) {
 
 	//dev := getDevice( udid )
 
-	provId := self.devTracker.getDevProvId(udid)
-	provConn := self.devTracker.getProvConn(provId)
-
 	writer := c.Writer
 	req := c.Request
 	conn, err := wsupgrader.Upgrade(writer, req, nil)
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
+		return
+	}
+
+	// A stream cut short by the provider dropping carries on with this one
+	if self.devTracker.resumeStream(udid, conn) {
+		log.WithFields(log.Fields{
+			"type": "provider_video_resume",
+			"udid": censorUuid(udid),
+		}).Info("Provider -> Server video resumed")
 		return
 	}
 
 	vidConn := self.devTracker.getVidStreamOutput(udid)
-	outSocket := vidConn.socket
-	clientOffset := vidConn.offset
+	if vidConn == nil {
+		fmt.Printf("No client waiting for video of udid:%s\n", udid)
+		conn.Close()
+		return
+	}
+	owner := vidConn.viewer
+	vidWriter := gVideo.open(udid, vidConn.rid)
+	relay := NewStreamRelay(udid, self.devTracker)
 
-	msgChan := make(chan ClientMsg)
+	msgChan := make(chan ClientMsg, 1)
 	self.devTracker.addClient(udid, msgChan)
 
-	frameChan := make(chan FrameMsg, 20)
-
-	// Consume incoming frames as fast as possible only ever holding onto the latest frame
+	// Pass messages for the client along; a kick ends the stream
 	go func() {
-		ingestDone := false
 		for {
-			if ingestDone == true {
-				break
-			}
-			t, data, err := conn.ReadMessage()
-			//fmt.Printf("Got frame\n")
-			if err != nil {
-				conn = nil
-				if frameChan != nil {
-					frameChan <- FrameMsg{
-						msg:       CMKick,
-						frame:     []byte{},
-						frameType: 0,
-					}
-				}
-				fmt.Printf("Frame receive error: %s\n", err)
-				break
-			}
-			if frameChan != nil {
-				frameChan <- FrameMsg{
-					msg:       CMFrame,
-					frame:     data,
-					frameType: t,
-				}
-			}
-
 			select {
+			case <-owner.done:
+				return
 			case msg := <-msgChan:
-				outSocket.WriteMessage(ws.TextMessage, []byte(msg.msg))
+				owner.offerText([]byte(msg.msg))
 				if msg.msgType == CMKick {
 					fmt.Printf("Got kick from client; ending ingest\n")
-					if frameChan != nil {
-						frameChan <- FrameMsg{
-							msg:       CMKick,
-							frame:     []byte{},
-							frameType: 0,
-						}
-					}
-					ingestDone = true
-					break
+					owner.close()
 				}
-			default:
 			}
 		}
 	}()
 
-	var frameSleep int32
-	frameSleep = 0
-
+	// Consume incoming frames as fast as possible. Nothing here waits on a
+	// viewer; each only ever gets offered the latest frame.
+	// The provider may drop and send a new stream while the viewers wait
+	connLock := &sync.Mutex{}
+	ingestDone := make(chan bool)
 	go func() {
+		defer close(ingestDone)
 		for {
-			_, data, err := outSocket.ReadMessage()
-			if err != nil {
-				break
-			}
-			root, _ := uj.Parse(data)
-			bpsNode := root.Get("bps")
-			if bpsNode != nil {
-				avgFrameStr := root.Get("avgFrame").String()
-				avgFrame, _ := strconv.ParseInt(avgFrameStr, 10, 64)
-
-				bpsStr := bpsNode.String()
-				bps, _ := strconv.ParseInt(bpsStr, 10, 64)
-				if bps != 10000000 {
-					fpsMax := (float64(bps) / float64(avgFrame)) * 0.75
-					delayMs := float32(1000) / float32(fpsMax)
-					//fmt.Printf("fpsMax: %d ; delayMs: %d\n", fpsMax, delayMs )
-					frameSleep = int32(delayMs)
+			t, data, err := conn.ReadMessage()
+			if err == nil {
+				if t == ws.BinaryMessage {
+					relay.ingest()
+					vidWriter.writeFrame(data)
+					self.devTracker.setLastFrame(udid, data)
+					owner.offer(data)
+					self.devTracker.fanOutFrame(udid, data)
+				} else {
+					owner.offerText(data)
 				}
+				continue
 			}
-		}
-	}()
-
-	abort := false
+			fmt.Printf("Frame receive error: %s\n", err)
 
-	go func() {
-		for {
-			if abort {
-				return
-			}
-			if frameChan != nil {
-				frameChan <- FrameMsg{
-					msg:       CMPing,
-					frame:     []byte{},
-					frameType: 0,
-				}
-			} else {
+			next := self.devTracker.awaitStream(udid, owner.done)
+			if next == nil {
 				break
 			}
-			time.Sleep(time.Second)
+			connLock.Lock()
+			conn.Close()
+			conn = next
+			connLock.Unlock()
+			relay.reset()
 		}
+		owner.close()
 	}()
 
-	// Whenever a frame is ready send the latest frame
-	for {
-		if abort {
-			fmt.Printf("Frame sender got CMKick. Aborting\n")
-			break
-		}
-
-		var frame FrameMsg
-		gotFrame := false
-		emptied := false
-		for {
-			select {
-			case msg := <-frameChan:
-				if msg.msg == CMKick {
-					frameChan = nil
-					abort = true
-				} else if msg.msg == CMPing {
-					awriter, err := outSocket.NextWriter(ws.TextMessage)
-					if err == nil {
-						_, err = awriter.Write([]byte("ping"))
-						if err == nil {
-							err = awriter.Close()
-						}
-					}
-					if err != nil {
-						frameChan <- FrameMsg{
-							msg:       CMKick,
-							frame:     []byte{},
-							frameType: 0,
-						}
-					}
-					continue
-				} else {
-					gotFrame = true
-					frame = msg
-				}
-				break
-			default:
-				emptied = true
-			}
-			if emptied {
-				break
-			}
-		}
-
-		if !gotFrame {
-			time.Sleep(time.Millisecond * time.Duration(20))
-			continue
-		}
-
-		if abort {
-			fmt.Printf("Frame sender got CMKick. Aborting\n")
-			break
-		}
-		toSend := frame.frame
-		t := frame.frameType
-
-		//fmt.Printf("Sending frame to client\n")
-
-		if t == ws.TextMessage {
-			// Just send the message and continue
-			outSocket.WriteMessage(ws.TextMessage, frame.frame)
-			continue
-		}
-
-		var timeBeforeSend int64
-		var writer io.WriteCloser
-		var err error
-		if t != ws.TextMessage {
-			writer, err = outSocket.NextWriter(ws.TextMessage)
-			if err == nil {
-				nowMilli := time.Now().UnixMilli() + clientOffset
-				nowBytes := []byte(strconv.FormatInt(nowMilli, 10))
-				writer.Write(nowBytes)
-				writer.Close()
-
-				writer, err = outSocket.NextWriter(t)
-				if err == nil {
-					timeBeforeSend = time.Now().UnixMilli()
-					nowMilli = timeBeforeSend + clientOffset
-
-					nowBytes = []byte(fmt.Sprintf("%*d", 100, nowMilli))
-					toSend = append(toSend, nowBytes...)
-				}
-			}
-		}
-		if err != nil {
-			fmt.Printf("Error creating outSocket writer: %s\n", err)
-			outSocket = nil
-			provConn.stopImgStream(udid)
-			break
-		}
+	go relay.run(self.devTracker, owner)
 
-		_, err = writer.Write(toSend)
-		if err == nil {
-			err = writer.Close()
-		}
-		if err != nil {
-			fmt.Printf("Error writing frame: %s\n", err)
-			outSocket = nil
+	if err := owner.run(); err != nil {
+		fmt.Printf("Error writing frame: %s\n", err)
+		if provConn := self.devTracker.devProvConn(udid); provConn != nil {
 			provConn.stopImgStream(udid)
-			frameChan <- FrameMsg{
-				msg:       CMKick,
-				frame:     []byte{},
-				frameType: 0,
-			}
-		}
-
-		if frameSleep == 0 {
-			continue
 		}
-
-		timeAfterSend := time.Now().UnixMilli()
-
-		timeSending := int32(timeAfterSend - timeBeforeSend)
-
-		if timeSending > frameSleep {
-			continue
-		}
-
-		milliToSleep := frameSleep - timeSending
-
-		time.Sleep(time.Millisecond * time.Duration(milliToSleep))
 	}
+	owner.close()
 
-	log.WithFields(log.Fields{
+	log.WithFields(owner.stats.logFields(log.Fields{
 		"type": "provider_video_end",
 		"udid": censorUuid(udid),
-	}).Info("Provider -> Server video disconnected")
+	})).Info("Provider -> Server video disconnected")
 
+	connLock.Lock()
+	conn.Close()
+	connLock.Unlock()
+	<-ingestDone
+
+	gVideo.close(vidWriter)
 	self.devTracker.delVidStreamOutput(udid, vidConn.rid)
 	self.devTracker.deleteClient(udid)
 
-	if conn != nil {
-		conn.Close()
-	}
-	if outSocket != nil {
-		outSocket.Close()
-	}
+	owner.socket.Close()
 }
 
 // @Description Provider - Websocket
+// @Description Messages follow the schema at /provider/protocol. The negotiated
+// @Description protocol version is returned in the X-Provider-Protocol header.
+// @Description Once connected, providers announce the message types they and
+// @Description their devices handle with a capabilities message. Device status,
+// @Description orientation, log, alert and crash messages may be sent at any time
+// @Description in place of posting to /provider/device.
 // @Router /provider/ws [GET]
+// @Param protocol query string false "Comma separated protocol versions the provider speaks; 1 if absent"
 func (self *ProviderHandler) handleProviderWS(c *gin.Context) {
-	s := self.sessionManager.GetSession(c)
+	provider := providerOf(c)
 
-	provider := self.sessionManager.session.Get(s, "provider").(ProviderOb)
+	protocol, ok := negotiateProtocol(c)
+	if !ok {
+		refuseProtocol(c, provider)
+		return
+	}
 
 	writer := c.Writer
 	req := c.Request
-	conn, err := wsupgrader.Upgrade(writer, req, nil)
+	conn, err := wsupgrader.Upgrade(writer, req, http.Header{
+		provProtoHeader: []string{strconv.Itoa(protocol)},
+	})
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
 		return
 	}
 
-	provChan := make(chan ProvBase)
-	provConn := NewProviderConnection(provChan)
+	provChan := make(chan *ProvRequest)
+	provConn := NewProviderConnection(provChan, self.config.heartbeat)
 	self.devTracker.setProvConn(provider.Id, provConn)
 	reqTracker := provConn.reqTracker
 	reqTracker.conn = conn
+	reqTracker.protocol = protocol
 
-	amDone := false
-
-	fmt.Printf("Provider Connection Established - Provider:%s\n", provider.User)
+	fmt.Printf("Provider Connection Established - Provider:%s Protocol:%d\n", provider.User, protocol)
 
 	go func() {
+		heartbeat := self.config.heartbeat
 		for {
-			time.Sleep(time.Second * 5)
-			provConn.doPing(func(root uj.JNode, raw []byte) {
-				text := root.Get("text").String()
-				if text != "pong" {
-					amDone = true
+			time.Sleep(heartbeat.interval)
+			ctx, cancel := context.WithTimeout(context.Background(), heartbeat.timeout)
+			sent := time.Now()
+			provConn.doPing(ctx, func(root uj.JNode, raw []byte) {
+				cancel()
+				if err := resError(root); err != "" {
+					if err == ReqErrProviderGone {
+						return
+					}
+					prev, lost := provConn.health.miss()
+					logHealthChange(provider, provConn.health, prev)
+					if lost {
+						fmt.Printf("Provider missed too many pings - Provider:%s\n", provider.User)
+						provConn.close()
+					}
+					return
 				}
+				if text := root.Get("text"); text == nil || text.String() != "pong" {
+					provConn.close()
+					return
+				}
+				logHealthChange(provider, provConn.health, provConn.health.pong(time.Since(sent)))
 			})
 
-			if amDone {
-				if provChan != nil {
-					provChan <- nil
-				}
+			// Drop the connection once the provider is disabled or rotated out
+			if !providerSessionValid(provConn.session(provider)) {
+				fmt.Printf("Provider credentials revoked - Provider:%s\n", provider.User)
+				provConn.close()
+			}
+
+			if provConn.closed() {
 				break
 			}
 		}
@@ -428,43 +321,41 @@ func (self *ProviderHandler) handleProviderWS(c *gin.Context) {
 		for {
 			t, msg, err := conn.ReadMessage()
 			if err != nil {
-				amDone = true
-			} else {
-				jsonroot := reqTracker.processResp(t, msg)
-				if jsonroot != nil {
-					// This is not a response; is a request from provider
-
-				}
-			}
-
-			if amDone {
-				if provChan != nil {
-					provChan <- nil
-				}
+				provConn.close()
 				break
 			}
+			jsonroot := reqTracker.processResp(t, msg)
+			if jsonroot != nil {
+				// This is not a response; is a request from provider
+				self.handleProviderMsg(provider, provConn, jsonroot, msg)
+			}
 		}
 	}()
 
+	// Closing the connection, from here or either goroutine above, ends the loop
 	for {
-		ev := <-provChan
+		var ev *ProvRequest
+		select {
+		case ev = <-provChan:
+		case <-provConn.done:
+		}
 		if ev == nil {
-			provChan = nil
 			break
 		}
 
-		err, reqText := reqTracker.sendReq(ev)
+		err, reqText := reqTracker.sendReq(ev.ctx, ev.msg)
 		if err != nil {
 			fmt.Printf("Failed to send request to provider\n")
 			fmt.Printf("  Request data:%s\n", reqText)
 			fmt.Printf("  Error:%s\n", err)
-			provConn.provChan = nil
-			amDone = true
 			break
 		}
 	}
 
-	self.devTracker.clearProvConn(provider.Id)
+	provConn.close()
+	conn.Close()
+	reqTracker.failAll(ReqErrProviderGone)
+	self.devTracker.clearProvConn(provider.Id, provConn)
 	fmt.Printf("Provider Connection Lost - Provider:%s\n", provider.User)
 }
 
@@ -486,24 +377,31 @@ type SProviderRegistration struct {
 
 // @Description Provider - Register
 // @Router /provider/register [POST]
-// @Param regPass formData string true "Registration password"
+// @Param regPass formData string false "Registration password"
+// @Param regToken formData string false "One-time registration token, used instead of the password; re-registering an existing provider takes one issued for its username"
 // @Param username formData string true "Provider username"
 // @Produce json
 // @Success 200 {object} SProviderRegistration
 func (self *ProviderHandler) handleRegister(c *gin.Context) {
-	pass := c.PostForm("regPass")
+	username := c.PostForm("username")
 
-	conf := getConf()
-	if pass != conf.RegPass {
-		var jsonf struct {
-			Success bool
-		}
-		jsonf.Success = false
-		c.JSON(http.StatusOK, jsonf)
+	cur := getProvider(username)
+	if cur != nil && cur.Disabled {
+		recordProviderLoginFail(c, username, cur, "disabled")
+		c.JSON(http.StatusOK, SProviderRegistration{Success: false})
 		return
 	}
 
-	username := c.PostForm("username")
+	if reason := checkRegistration(c, self.config, username, cur != nil); reason != "" {
+		log.WithFields(log.Fields{
+			"type":   "provider_register_fail",
+			"user":   username,
+			"reason": reason,
+			"ip":     c.ClientIP(),
+		}).Warn("Provider registration refused")
+		c.JSON(http.StatusOK, SProviderRegistration{Success: false})
+		return
+	}
 
 	var json struct {
 		Success  bool
@@ -528,34 +426,48 @@ func (self *ProviderHandler) handleProviderLogin(c *gin.Context) {
 
 	user := c.PostForm("user")
 	pass := c.PostForm("pass")
-	fmt.Printf("Provider login user=%s pass=%s\n", user, pass)
+
+	// Nothing submitted; show the form again
+	if user == "" && pass == "" {
+		self.showProviderLogin(c)
+		return
+	}
+
+	if !self.config.provAuthPassword {
+		recordProviderLoginFail(c, user, nil, "password_auth_disabled")
+		c.Redirect(302, "/provider/?fail=4")
+		return
+	}
 
 	// ensure the user is legit
 	provider := getProvider(user)
 	if provider == nil {
-		fmt.Printf("provider login failed 1\n")
+		recordProviderLoginFail(c, user, nil, "unknown_user")
 		c.Redirect(302, "/provider/?fail=1")
 		return
 	}
 
-	if pass == provider.Password {
-		//fmt.Printf("provider login ok\n")
-
-		self.sessionManager.session.Put(s, "provider", &ProviderOb{
-			User: user,
-			Id:   provider.Id,
-		})
-		self.sessionManager.WriteSession(c)
-
-		c.Redirect(302, "/provider/")
-		return
-	} else {
-		fmt.Printf("provider login failed [submit]%s != [db]%s\n", pass, provider.Password)
+	if !checkProviderPass(provider.Password, pass) {
+		recordProviderLoginFail(c, user, provider, "bad_password")
 		c.Redirect(302, "/provider/?fail=2")
 		return
 	}
 
-	self.showProviderLogin(c)
+	if provider.Disabled {
+		recordProviderLoginFail(c, user, provider, "disabled")
+		c.Redirect(302, "/provider/?fail=3")
+		return
+	}
+
+	noteProviderLogin(provider.Id)
+	self.sessionManager.session.Put(s, "provider", &ProviderOb{
+		User:  user,
+		Id:    provider.Id,
+		Login: time.Now(),
+	})
+	self.sessionManager.WriteSession(c)
+
+	c.Redirect(302, "/provider/")
 }
 
 // @Description Provider - Logout
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const providerTokenPrefix = "cfp_"
+
+// Make the CA that signs provider certificates
+func gen_provider_ca(config *Config) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          newCertSerial(),
+		Subject:               pkix.Name{Organization: []string{"ControlFloor"}, CommonName: "ControlFloor Provider CA"},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(config.provCaKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(config.provCaCrt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"type": "provider_ca_created",
+		"crt":  config.provCaCrt,
+	}).Info("Created provider CA")
+	return nil
+}
+
+func newCertSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
+
+func loadProviderCa(config *Config) (*x509.Certificate, crypto.Signer, error) {
+	if !fileExists(config.provCaCrt) && !fileExists(config.provCaKey) {
+		err := gen_provider_ca(config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	crtPem, err := ioutil.ReadFile(config.provCaCrt)
+	if err != nil {
+		return nil, nil, err
+	}
+	crtBlock, _ := pem.Decode(crtPem)
+	if crtBlock == nil {
+		return nil, nil, errors.New("no certificate in " + config.provCaCrt)
+	}
+	ca, err := x509.ParseCertificate(crtBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPem, err := ioutil.ReadFile(config.provCaKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPem)
+	if keyBlock == nil {
+		return nil, nil, errors.New("no key in " + config.provCaKey)
+	}
+	// Also take keys made with openssl
+	var key interface{}
+	if key, err = x509.ParseECPrivateKey(keyBlock.Bytes); err != nil {
+		if key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes); err != nil {
+			if key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unusable key in " + config.provCaKey)
+	}
+	return ca, signer, nil
+}
+
+// TLS settings asking for, but not requiring, a client certificate from the
+// provider CA; browsers connect without one
+func providerTLSConfig(config *Config) *tls.Config {
+	ca, _, err := loadProviderCa(config)
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}
+}
+
+// Issue a client certificate for a provider; returns the certificate and
+// its key as PEM. The key is not kept.
+func issueProviderCert(config *Config, username string, days int) ([]byte, []byte, *DbProviderCert, error) {
+	provider := getProvider(username)
+	if provider == nil {
+		return nil, nil, nil, errors.New("no provider " + username)
+	}
+	ca, caKey, err := loadProviderCa(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+	serial := newCertSerial()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"ControlFloor Provider"}, CommonName: username},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.AddDate(0, 0, days),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dbCert := &DbProviderCert{
+		ProviderId: provider.Id,
+		Serial:     serial.Text(16),
+		Created:    now,
+		Expires:    tmpl.NotAfter,
+	}
+	addProviderCert(dbCert)
+
+	log.WithFields(log.Fields{
+		"type":    "provider_cert_issue",
+		"user":    username,
+		"serial":  dbCert.Serial,
+		"expires": formatTokenTime(dbCert.Expires),
+	}).Info("Issued provider certificate")
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPem, keyPem, dbCert, nil
+}
+
+func printProviderCert(cert DbProviderCert) {
+	user := ""
+	if provider := getProviderById(cert.ProviderId); provider != nil {
+		user = provider.Username
+	}
+	state := "valid"
+	if cert.Revoked {
+		state = "revoked"
+	} else if time.Now().After(cert.Expires) {
+		state = "expired"
+	}
+	fmt.Printf("Serial: %s\nProvider: %s\nCreated: %s\nExpires: %s\nState: %s\n\n",
+		cert.Serial, user, formatTokenTime(cert.Created), formatTokenTime(cert.Expires), state)
+}
+
+// What a provider token grants. Tokens aren't stored; the ones issued
+// before a provider's password was last rotated stop working.
+type ProviderClaims struct {
+	Id      int64  `json:"id"`
+	User    string `json:"user"`
+	Issued  int64  `json:"iat"`
+	Expires int64  `json:"exp"`
+}
+
+func providerTokenSecret(config *Config) []byte {
+	if config.provTokenSecret != "" {
+		return []byte(config.provTokenSecret)
+	}
+	secret := getConf().ProvTokenSecret
+	if secret == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		secret = hex.EncodeToString(buf)
+		setProvTokenSecret(secret)
+	}
+	return []byte(secret)
+}
+
+func providerTokenSig(config *Config, payload string) string {
+	mac := hmac.New(sha256.New, providerTokenSecret(config))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func issueProviderToken(config *Config, username string, ttl time.Duration) (string, bool) {
+	provider := getProvider(username)
+	if provider == nil {
+		return "", false
+	}
+	now := time.Now()
+	data, _ := json.Marshal(&ProviderClaims{
+		Id:      provider.Id,
+		User:    provider.Username,
+		Issued:  now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	log.WithFields(log.Fields{
+		"type":    "provider_token_issue",
+		"user":    username,
+		"expires": formatTokenTime(now.Add(ttl)),
+	}).Info("Issued provider token")
+
+	return providerTokenPrefix + payload + "." + providerTokenSig(config, payload), true
+}
+
+// Decode a provider token; the error names why it can't be used
+func checkProviderToken(config *Config, token string) (*ProviderOb, string) {
+	if !strings.HasPrefix(token, providerTokenPrefix) {
+		return nil, "invalid_provider_token"
+	}
+	parts := strings.Split(token[len(providerTokenPrefix):], ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(providerTokenSig(config, parts[0]))) {
+		return nil, "invalid_provider_token"
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, "invalid_provider_token"
+	}
+	claims := &ProviderClaims{}
+	if json.Unmarshal(data, claims) != nil {
+		return nil, "invalid_provider_token"
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, "provider_token_expired"
+	}
+
+	ob := &ProviderOb{
+		User:  claims.User,
+		Id:    claims.Id,
+		Login: time.Unix(claims.Issued, 0),
+	}
+	if !providerSessionValid(*ob) {
+		return nil, "provider_token_revoked"
+	}
+	return ob, ""
+}
+
+// The provider a verified client certificate was issued to
+func providerFromCert(leaf *x509.Certificate) (*ProviderOb, string) {
+	serial := leaf.SerialNumber.Text(16)
+	cert := getProviderCert(serial)
+	if cert == nil {
+		return nil, "unknown_provider_cert"
+	}
+	provider := getProviderById(cert.ProviderId)
+	if provider == nil {
+		return nil, "unknown_provider_cert"
+	}
+	ob := &ProviderOb{
+		User: provider.Username,
+		Id:   provider.Id,
+		Cert: serial,
+	}
+	if !providerSessionValid(*ob) {
+		return nil, "provider_cert_revoked"
+	}
+	return ob, ""
+}
+
+// Work out which provider a request comes from, trying each enabled way of
+// authenticating. A reason is returned when credentials were presented but
+// refused; nil without a reason means none were presented.
+func (self *ProviderHandler) authProvider(c *gin.Context) (*ProviderOb, string) {
+	config := self.config
+
+	if config.provAuthCert {
+		if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+			return providerFromCert(state.VerifiedChains[0][0])
+		}
+	}
+
+	if config.provAuthToken {
+		if token := bearerToken(c); token != "" {
+			return checkProviderToken(config, token)
+		}
+	}
+
+	if config.provAuthPassword {
+		sCtx := self.sessionManager.GetSession(c)
+		provider, ok := self.sessionManager.session.Get(sCtx, "provider").(ProviderOb)
+		if ok && providerSessionValid(provider) {
+			return &provider, ""
+		}
+		if ok {
+			self.sessionManager.session.Remove(sCtx, "provider")
+			self.sessionManager.WriteSession(c)
+		}
+	}
+
+	return nil, ""
+}
+
+// The provider NeedProviderAuth let the request through as
+func providerOf(c *gin.Context) ProviderOb {
+	return c.MustGet("provider").(ProviderOb)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Providers "on", "off" (disabled) and "rotated", whose password changed
+// at the given time
+func useTestProviders(t *testing.T, rotated time.Time) {
+	useTestDb(t, new(DbProvider), new(DbProviderCert))
+	gDb.Insert(&DbProvider{Id: 1, Username: "on"})
+	gDb.Insert(&DbProvider{Id: 2, Username: "off", Disabled: true})
+	gDb.Insert(&DbProvider{Id: 3, Username: "rotated", PassChanged: rotated})
+}
+
+func signProviderClaims(config *Config, claims *ProviderClaims) string {
+	data, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return providerTokenPrefix + payload + "." + providerTokenSig(config, payload)
+}
+
+func TestCheckProviderToken(t *testing.T) {
+	// Mid-second, so a token issued in the same second comes before it
+	rotated := time.Unix(time.Now().Unix()-60, 500000000)
+	useTestProviders(t, rotated)
+	config := &Config{provTokenSecret: "secret"}
+	other := &Config{provTokenSecret: "other"}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := signProviderClaims(config, &ProviderClaims{Id: 1, User: "on", Issued: time.Now().Unix(), Expires: exp})
+	notBase64 := "!!!"
+	notJson := base64.RawURLEncoding.EncodeToString([]byte("nope"))
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid", valid, ""},
+		{"bad signature", valid[:len(valid)-1] + "x", "invalid_provider_token"},
+		{"signed with another secret", signProviderClaims(other, &ProviderClaims{Id: 1, User: "on", Expires: exp}), "invalid_provider_token"},
+		{"no prefix", strings.TrimPrefix(valid, providerTokenPrefix), "invalid_provider_token"},
+		{"wrong prefix", "cf_" + strings.TrimPrefix(valid, providerTokenPrefix), "invalid_provider_token"},
+		{"no signature", strings.Split(valid, ".")[0], "invalid_provider_token"},
+		{"empty", "", "invalid_provider_token"},
+		{"signed payload that isn't base64", providerTokenPrefix + notBase64 + "." + providerTokenSig(config, notBase64), "invalid_provider_token"},
+		{"signed payload that isn't json", providerTokenPrefix + notJson + "." + providerTokenSig(config, notJson), "invalid_provider_token"},
+		{"expired", signProviderClaims(config, &ProviderClaims{Id: 1, User: "on", Expires: time.Now().Unix()}), "provider_token_expired"},
+		{"disabled provider", signProviderClaims(config, &ProviderClaims{Id: 2, User: "off", Expires: exp}), "provider_token_revoked"},
+		{"unknown provider", signProviderClaims(config, &ProviderClaims{Id: 9, User: "gone", Expires: exp}), "provider_token_revoked"},
+		{"issued before the password changed", signProviderClaims(config, &ProviderClaims{Id: 3, User: "rotated", Issued: rotated.Unix() - 1, Expires: exp}), "provider_token_revoked"},
+		{"issued in the second the password changed", signProviderClaims(config, &ProviderClaims{Id: 3, User: "rotated", Issued: rotated.Unix(), Expires: exp}), ""},
+		{"issued after the password changed", signProviderClaims(config, &ProviderClaims{Id: 3, User: "rotated", Issued: rotated.Unix() + 1, Expires: exp}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, err := checkProviderToken(config, tt.token)
+			if err != tt.err {
+				t.Fatalf("got error %q, want %q", err, tt.err)
+			}
+			if err == "" && ob == nil {
+				t.Errorf("no provider for a valid token")
+			}
+		})
+	}
+}
+
+func TestProviderFromCert(t *testing.T) {
+	useTestProviders(t, time.Time{})
+	addProviderCert(&DbProviderCert{ProviderId: 1, Serial: "a1"})
+	addProviderCert(&DbProviderCert{ProviderId: 1, Serial: "a2", Revoked: true})
+	addProviderCert(&DbProviderCert{ProviderId: 2, Serial: "b1"})
+	addProviderCert(&DbProviderCert{ProviderId: 9, Serial: "c1"})
+
+	tests := []struct {
+		name   string
+		serial int64
+		user   string
+		err    string
+	}{
+		{"valid", 0xa1, "on", ""},
+		{"revoked", 0xa2, "", "provider_cert_revoked"},
+		{"disabled provider", 0xb1, "", "provider_cert_revoked"},
+		{"deleted provider", 0xc1, "", "unknown_provider_cert"},
+		{"unknown serial", 0xd1, "", "unknown_provider_cert"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, err := providerFromCert(&x509.Certificate{SerialNumber: big.NewInt(tt.serial)})
+			if err != tt.err {
+				t.Fatalf("got error %q, want %q", err, tt.err)
+			}
+			if err == "" && ob.User != tt.user {
+				t.Errorf("got provider %q, want %q", ob.User, tt.user)
+			}
+		})
+	}
+}
+
+func TestProviderSessionValid(t *testing.T) {
+	rotated := time.Unix(time.Now().Unix()-60, 500000000)
+	useTestProviders(t, rotated)
+	addProviderCert(&DbProviderCert{ProviderId: 1, Serial: "a1"})
+	addProviderCert(&DbProviderCert{ProviderId: 1, Serial: "a2", Revoked: true})
+
+	tests := []struct {
+		name string
+		ob   ProviderOb
+		ok   bool
+	}{
+		{"password login", ProviderOb{Id: 1, User: "on", Login: time.Now()}, true},
+		{"disabled provider", ProviderOb{Id: 2, User: "off", Login: time.Now()}, false},
+		{"deleted provider", ProviderOb{Id: 9, User: "gone", Login: time.Now()}, false},
+		{"login before the password changed", ProviderOb{Id: 3, User: "rotated", Login: rotated.Add(-time.Second)}, false},
+		{"login earlier in the second the password changed", ProviderOb{Id: 3, User: "rotated", Login: rotated.Add(-time.Millisecond)}, true},
+		{"login after the password changed", ProviderOb{Id: 3, User: "rotated", Login: rotated.Add(time.Second)}, true},
+		{"certificate", ProviderOb{Id: 1, User: "on", Cert: "a1"}, true},
+		{"revoked certificate", ProviderOb{Id: 1, User: "on", Cert: "a2"}, false},
+		{"unknown certificate", ProviderOb{Id: 1, User: "on", Cert: "ff"}, false},
+		{"certificate of another provider", ProviderOb{Id: 3, User: "rotated", Cert: "a1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := providerSessionValid(tt.ob); got != tt.ok {
+				t.Errorf("got %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Request error code for messages the provider or device said it can't handle
+const ReqErrUnsupported = "unsupported"
+
+// Message types every provider has to handle to stay connected
+var provCoreTypes = map[string]bool{
+	"ping": true,
+}
+
+// Message types for the provider as a whole, which device lists don't restrict
+var provWideTypes = map[string]bool{
+	"shutdown": true,
+}
+
+// Sent by a provider once connected, and again whenever its devices change
+type ProvCapabilitiesMsg struct {
+	Id           int16               `json:"id"`
+	Type         string              `json:"type"`
+	Capabilities []string            `json:"capabilities"      desc:"Message types the provider handles"`
+	Devices      map[string][]string `json:"devices,omitempty" desc:"Message types each device supports, by UDID; replaces what was announced for those devices. Devices not listed support everything the provider handles."`
+}
+
+// What a provider announced it and its devices can do. Providers that never
+// announce anything are taken to support every message type.
+type ProvCaps struct {
+	lock      *sync.Mutex
+	announced bool
+	types     map[string]bool
+	devices   map[string]map[string]bool
+}
+
+func NewProvCaps() *ProvCaps {
+	return &ProvCaps{
+		lock:    &sync.Mutex{},
+		devices: make(map[string]map[string]bool),
+	}
+}
+
+func capsSet(list []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range list {
+		set[item] = true
+	}
+	return set
+}
+
+func (self *ProvCaps) set(msg *ProvCapabilitiesMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.announced = true
+	self.types = capsSet(msg.Capabilities)
+	for udid, types := range msg.Devices {
+		self.devices[udid] = capsSet(types)
+	}
+}
+
+// Whether a message type can be sent to a device's provider
+func (self *ProvCaps) supports(udid string, msgType string) bool {
+	if provCoreTypes[msgType] {
+		return true
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.announced {
+		return true
+	}
+	if !self.types[msgType] {
+		return false
+	}
+	if devTypes, ok := self.devices[udid]; ok && !provWideTypes[msgType] {
+		return devTypes[msgType]
+	}
+	return true
+}
+
+// The message types a device supports, sorted; nil if nothing was announced
+func (self *ProvCaps) features(udid string) []string {
+	self.lock.Lock()
+	announced := self.announced
+	self.lock.Unlock()
+	if !announced {
+		return nil
+	}
+
+	features := []string{}
+	for _, spec := range provMessageSpecs {
+		if !provCoreTypes[spec.Type] && self.supports(udid, spec.Type) {
+			features = append(features, spec.Type)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+func (self *ProviderConnection) setCapabilities(provider ProviderOb, msg *ProvCapabilitiesMsg) {
+	self.caps.set(msg)
+
+	log.WithFields(log.Fields{
+		"type":         "provider_capabilities",
+		"user":         provider.User,
+		"capabilities": len(msg.Capabilities),
+		"devices":      len(msg.Devices),
+	}).Info("Provider announced capabilities")
+}
+
+// Whether a device can perform a message type; devices whose provider isn't
+// connected are given the benefit of the doubt, as sending fails anyway
+func (self *DevTracker) devSupports(udid string, msgType string) bool {
+	pc := self.getProvConn(self.getDevProvId(udid))
+	if pc == nil {
+		return true
+	}
+	return pc.caps.supports(udid, msgType)
+}
+
+// The features of a device by message type, for templates to show only the
+// controls a device can perform
+func (self *DevTracker) devFeatures(udid string) map[string]bool {
+	can := make(map[string]bool)
+	for _, spec := range provMessageSpecs {
+		can[spec.Type] = self.devSupports(udid, spec.Type)
+	}
+	return can
+}
+
+// The message types a device supports; nil if its provider announced nothing
+func (self *DevTracker) devFeatureList(udid string) []string {
+	pc := self.getProvConn(self.getDevProvId(udid))
+	if pc == nil {
+		return nil
+	}
+	return pc.caps.features(udid)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvCapsSupports(t *testing.T) {
+	silent := NewProvCaps()
+
+	caps := NewProvCaps()
+	caps.set(&ProvCapabilitiesMsg{
+		Capabilities: []string{"click", "swipe", "shutdown"},
+		Devices: map[string][]string{
+			"dev1": {"click"},
+			"dev2": {},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		caps    *ProvCaps
+		udid    string
+		msgType string
+		ok      bool
+	}{
+		{"never announced", silent, "dev1", "restart", true},
+		{"never announced, core type", silent, "dev1", "ping", true},
+		{"announced without the type", caps, "dev1", "restart", false},
+		{"announced without the type, device missing from Devices", caps, "dev3", "restart", false},
+		{"core type not announced", caps, "dev2", "ping", true},
+		{"device lists the type", caps, "dev1", "click", true},
+		{"device list excludes the type", caps, "dev1", "swipe", false},
+		{"empty device list", caps, "dev2", "click", false},
+		{"device missing from Devices", caps, "dev3", "swipe", true},
+		{"provider wide type with a device list", caps, "dev1", "shutdown", true},
+		{"provider wide type with an empty device list", caps, "dev2", "shutdown", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.caps.supports(tt.udid, tt.msgType); got != tt.ok {
+				t.Errorf("got %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProvCapsAnnounceAgain(t *testing.T) {
+	caps := NewProvCaps()
+	caps.set(&ProvCapabilitiesMsg{
+		Capabilities: []string{"click", "swipe"},
+		Devices:      map[string][]string{"dev1": {"click"}, "dev2": {"click"}},
+	})
+	// Devices left out of a later announcement keep what they had
+	caps.set(&ProvCapabilitiesMsg{
+		Capabilities: []string{"click", "swipe"},
+		Devices:      map[string][]string{"dev1": {"click", "swipe"}},
+	})
+	if !caps.supports("dev1", "swipe") {
+		t.Errorf("dev1 didn't gain swipe")
+	}
+	if caps.supports("dev2", "swipe") {
+		t.Errorf("dev2 gained swipe")
+	}
+
+	if got := NewProvCaps().features("dev1"); got != nil {
+		t.Errorf("features without an announcement: got %v, want nil", got)
+	}
+	if got, want := caps.features("dev2"), []string{"click"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("features of dev2: got %v, want %v", got, want)
+	}
+}
@@ -1,190 +1,225 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	uj "github.com/nanoscopic/ujsonin/v2/mod"
 )
 
+// A message queued for the provider along with the context bounding its round-trip
+type ProvRequest struct {
+	ctx context.Context
+	msg ProvBase
+}
+
 type ProviderConnection struct {
-	provChan   chan ProvBase
+	provChan   chan *ProvRequest
 	reqTracker *ReqTracker
+	caps       *ProvCaps
+	health     *ProvHealth
+	done       chan bool
+	closeOnce  *sync.Once
+	loginLock  *sync.Mutex
+	login      time.Time
 }
 
-func NewProviderConnection(provChan chan ProvBase) *ProviderConnection {
+func NewProviderConnection(provChan chan *ProvRequest, heartbeat *HeartbeatConfig) *ProviderConnection {
 	self := &ProviderConnection{
 		provChan:   provChan,
 		reqTracker: NewReqTracker(),
+		caps:       NewProvCaps(),
+		health:     NewProvHealth(heartbeat),
+		done:       make(chan bool),
+		closeOnce:  &sync.Once{},
+		loginLock:  &sync.Mutex{},
 	}
 
 	return self
 }
 
+// Note that the provider rotated its own password while connected, so the
+// connection stays good past the rotation
+func (self *ProviderConnection) relogin(login time.Time) {
+	self.loginLock.Lock()
+	defer self.loginLock.Unlock()
+	self.login = login
+}
+
+// The login of the provider as it stands for this connection
+func (self *ProviderConnection) session(provider ProviderOb) ProviderOb {
+	self.loginLock.Lock()
+	defer self.loginLock.Unlock()
+	if self.login.After(provider.Login) {
+		provider.Login = self.login
+	}
+	return provider
+}
+
 func errorChannelGone(message ProvBase) {
 	fmt.Printf("Failed to send message to provider:\n")
 	fmt.Printf("  %s\n", message.asText(0))
 }
 
-func (self *ProviderConnection) doPing(onDone func(uj.JNode, []byte)) {
+// Mark the connection as gone so queued and future messages fail instead of blocking
+func (self *ProviderConnection) close() {
+	self.closeOnce.Do(func() {
+		close(self.done)
+	})
+}
+
+// Whether the connection has been closed
+func (self *ProviderConnection) closed() bool {
+	select {
+	case <-self.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (self *ProviderConnection) send(ctx context.Context, message ProvBase) {
+	if self == nil || self.provChan == nil {
+		errorChannelGone(message)
+		gAudit.finish(gAudit.begin(ctx, message), ReqErrProviderGone)
+		// Callers wait for the result only once send returns
+		go failUnsent(message, ReqErrProviderGone)
+		return
+	}
+
+	if msgType, udid, _ := provMessageFields(message); !self.caps.supports(udid, msgType) {
+		fmt.Printf("Provider does not support %s on udid:%s\n", msgType, udid)
+		gAudit.finish(gAudit.begin(ctx, message), ReqErrUnsupported)
+		go failUnsent(message, ReqErrUnsupported)
+		return
+	}
+
+	select {
+	case self.provChan <- &ProvRequest{ctx: ctx, msg: message}:
+	case <-self.done:
+		errorChannelGone(message)
+		gAudit.finish(gAudit.begin(ctx, message), ReqErrProviderGone)
+		go failUnsent(message, ReqErrProviderGone)
+	case <-ctx.Done():
+		gAudit.finish(gAudit.begin(ctx, message), ctxError(ctx))
+		go failUnsent(message, ctxError(ctx))
+	}
+}
+
+func (self *ProviderConnection) doPing(ctx context.Context, onDone func(uj.JNode, []byte)) {
 	ping := &ProvPing{
 		onRes: func(root uj.JNode, raw []byte) {
 			onDone(root, raw)
 		},
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(ping)
-		return
-	}
-	self.provChan <- ping
+	self.send(ctx, ping)
 }
 
-func (self *ProviderConnection) doClick(udid string, x int, y int, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doClick(ctx context.Context, udid string, x int, y int, onDone func(uj.JNode, []byte)) {
 	click := &ProvClick{
 		udid:  udid,
 		x:     x,
 		y:     y,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) doDoubleclick(udid string, x int, y int, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doDoubleclick(ctx context.Context, udid string, x int, y int, onDone func(uj.JNode, []byte)) {
 	click := &ProvDoubleclick{
 		udid:  udid,
 		x:     x,
 		y:     y,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) doLaunch(udid string, bid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doLaunch(ctx context.Context, udid string, bid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvLaunch{
 		udid:  udid,
 		bid:   bid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doKill(udid string, bid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doKill(ctx context.Context, udid string, bid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvKill{
 		udid:  udid,
 		bid:   bid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doAllowApp(udid string, bid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doAllowApp(ctx context.Context, udid string, bid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvAllowApp{
 		udid:  udid,
 		bid:   bid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doRestrictApp(udid string, bid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doRestrictApp(ctx context.Context, udid string, bid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvRestrictApp{
 		udid:  udid,
 		bid:   bid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doListRestrictedApps(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doListRestrictedApps(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvListRestrictedApps{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doMouseDown(udid string, x int, y int, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doMouseDown(ctx context.Context, udid string, x int, y int, onDone func(uj.JNode, []byte)) {
 	click := &ProvMouseDown{
 		udid:  udid,
 		x:     x,
 		y:     y,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) doMouseUp(udid string, x int, y int, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doMouseUp(ctx context.Context, udid string, x int, y int, onDone func(uj.JNode, []byte)) {
 	click := &ProvMouseUp{
 		udid:  udid,
 		x:     x,
 		y:     y,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) doHardPress(udid string, x int, y int) {
+func (self *ProviderConnection) doHardPress(ctx context.Context, udid string, x int, y int) {
 	click := &ProvHardPress{
 		udid: udid,
 		x:    x,
 		y:    y,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) initWebrtc(udid string, offer string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) initWebrtc(ctx context.Context, udid string, offer string, onDone func(uj.JNode, []byte)) {
 	action := &ProvInitWebrtc{
 		udid:  udid,
 		offer: offer,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doLongPress(udid string, x int, y int, time float64, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doLongPress(ctx context.Context, udid string, x int, y int, time float64, onDone func(uj.JNode, []byte)) {
 	click := &ProvLongPress{
 		udid:  udid,
 		x:     x,
@@ -192,109 +227,81 @@ func (self *ProviderConnection) doLongPress(udid string, x int, y int, time floa
 		time:  time,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(click)
-		return
-	}
-	self.provChan <- click
+	self.send(ctx, click)
 }
 
-func (self *ProviderConnection) doTaskSwitcher(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doTaskSwitcher(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvTaskSwitcher{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doShake(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doShake(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvShake{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doCC(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doCC(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvCC{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doAssistiveTouch(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doAssistiveTouch(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvAssistiveTouch{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doHome(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doHome(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	home := &ProvHome{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(home)
-		return
-	}
-	self.provChan <- home
+	self.send(ctx, home)
 }
 
-func (self *ProviderConnection) doSource(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doSource(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	source := &ProvSource{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(source)
-		return
+	self.send(ctx, source)
+}
+
+func (self *ProviderConnection) doScreenshot(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
+	action := &ProvScreenshot{
+		udid:  udid,
+		onRes: onDone,
 	}
-	self.provChan <- source
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doWifiIp(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doWifiIp(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvWifiIp{
 		udid:  udid,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doShutdown(onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doShutdown(ctx context.Context, onDone func(uj.JNode, []byte)) {
 	msg := &ProvShutdown{
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(msg)
-		return
-	}
-	self.provChan <- msg
+	self.send(ctx, msg)
 }
 
-func (self *ProviderConnection) doKeys(udid string, keys string, curid int, prevkeys string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doKeys(ctx context.Context, udid string, keys string, curid int, prevkeys string, onDone func(uj.JNode, []byte)) {
 	action := &ProvKeys{
 		udid:     udid,
 		keys:     keys,
@@ -302,27 +309,19 @@ func (self *ProviderConnection) doKeys(udid string, keys string, curid int, prev
 		prevkeys: prevkeys,
 		onRes:    onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doText(udid string, text string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doText(ctx context.Context, udid string, text string, onDone func(uj.JNode, []byte)) {
 	action := &ProvText{
 		udid:  udid,
 		text:  text,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(action)
-		return
-	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doSwipe(udid string, x1 int, y1 int, x2 int, y2 int, delay float64, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doSwipe(ctx context.Context, udid string, x1 int, y1 int, x2 int, y2 int, delay float64, onDone func(uj.JNode, []byte)) {
 	swipe := &ProvSwipe{
 		udid:  udid,
 		x1:    x1,
@@ -332,69 +331,61 @@ func (self *ProviderConnection) doSwipe(udid string, x1 int, y1 int, x2 int, y2
 		delay: delay,
 		onRes: onDone,
 	}
-	if self == nil || self.provChan == nil {
-		errorChannelGone(swipe)
-		return
-	}
-	self.provChan <- swipe
+	self.send(ctx, swipe)
 }
 
 func (self *ProviderConnection) startImgStream(udid string) {
-	if self == nil || self.provChan == nil {
-		errorChannelGone(&ProvStartStream{udid: udid})
-		return
-	}
-	self.provChan <- &ProvStartStream{udid: udid}
+	self.send(context.Background(), &ProvStartStream{udid: udid})
 }
 
 func (self *ProviderConnection) stopImgStream(udid string) {
-	if self == nil || self.provChan == nil {
-		errorChannelGone(&ProvStopStream{udid: udid})
-		return
-	}
-	self.provChan <- &ProvStopStream{udid: udid}
+	self.send(context.Background(), &ProvStopStream{udid: udid})
+}
+
+func (self *ProviderConnection) setStreamQuality(udid string, maxFps int, quality string) {
+	self.send(context.Background(), &ProvStreamQuality{udid: udid, maxFps: maxFps, quality: quality})
 }
 
 //=====================LT Changes==========================
-func (self *ProviderConnection) doRefresh(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doRefresh(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvRefresh{
 		udid:  udid,
 		onRes: onDone,
 	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doRestart(udid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doRestart(ctx context.Context, udid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvRestart{
 		udid:  udid,
 		onRes: onDone,
 	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doOpenSafariUrl(udid string, url string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doOpenSafariUrl(ctx context.Context, udid string, url string, onDone func(uj.JNode, []byte)) {
 	action := &ProvSafariUrl{
 		udid:  udid,
 		url:   url,
 		onRes: onDone,
 	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doBrowserCleanup(udid string, bid string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doBrowserCleanup(ctx context.Context, udid string, bid string, onDone func(uj.JNode, []byte)) {
 	action := &ProvBrowserCleanup{
 		udid:  udid,
 		bid:   bid,
 		onRes: onDone,
 	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
 
-func (self *ProviderConnection) doRotateDevice(udid string, orientation string, onDone func(uj.JNode, []byte)) {
+func (self *ProviderConnection) doRotateDevice(ctx context.Context, udid string, orientation string, onDone func(uj.JNode, []byte)) {
 	action := &ProvRotateDevice{
 		udid:        udid,
 		orientation: orientation,
 		onRes:       onDone,
 	}
-	self.provChan <- action
+	self.send(ctx, action)
 }
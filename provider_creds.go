@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+const providerHashScheme = "sha256"
+
+// Provider passwords are random, so a salted sha256 is enough to keep
+// a leaked db from handing out working credentials
+func hashProviderPass(pass string) string {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		panic(err)
+	}
+	return providerPassHash(hex.EncodeToString(salt), pass)
+}
+
+func providerPassHash(salt string, pass string) string {
+	sum := sha256.Sum256([]byte(salt + pass))
+	return providerHashScheme + "$" + salt + "$" + hex.EncodeToString(sum[:])
+}
+
+func checkProviderPass(stored string, pass string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 3 || parts[0] != providerHashScheme {
+		return false
+	}
+	calc := providerPassHash(parts[1], pass)
+	return subtle.ConstantTimeCompare([]byte(calc), []byte(stored)) == 1
+}
+
+// Replace passwords stored in plaintext by earlier versions with their hash
+func hashPlainProviderPasswords(engine *xorm.Engine) {
+	var provs []DbProvider
+	err := engine.Find(&provs)
+	if err != nil {
+		panic(err)
+	}
+	for _, prov := range provs {
+		if strings.HasPrefix(prov.Password, providerHashScheme+"$") {
+			continue
+		}
+		_, err = engine.ID(prov.Id).Cols("password").Update(&DbProvider{
+			Password: hashProviderPass(prov.Password),
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Hashed stored password of provider %s\n", prov.Username)
+	}
+}
+
+// Whether a provider login is still good; it is not once the provider is
+// disabled, its certificate revoked, or its password rotated since it logged in
+func providerSessionValid(ob ProviderOb) bool {
+	provider := getProviderById(ob.Id)
+	if provider == nil || provider.Disabled {
+		return false
+	}
+	if ob.Cert != "" {
+		cert := getProviderCert(ob.Cert)
+		return cert != nil && !cert.Revoked && cert.ProviderId == ob.Id
+	}
+	// Tokens only carry the second they were issued in
+	return ob.Login.Unix() >= provider.PassChanged.Unix()
+}
+
+func recordProviderLoginFail(c *gin.Context, user string, provider *DbProvider, reason string) {
+	log.WithFields(log.Fields{
+		"type":   "provider_login_fail",
+		"user":   user,
+		"reason": reason,
+		"ip":     c.ClientIP(),
+	}).Warn("Provider login failed")
+
+	if provider != nil {
+		noteProviderLoginFail(provider.Id)
+	}
+}
+
+// Give a provider a new random password; the old one and every session
+// logged in with it stop working
+func rotateProviderPassword(username string) (string, bool) {
+	provider := getProvider(username)
+	if provider == nil {
+		return "", false
+	}
+	pass := randHex()
+	setProviderPassword(provider.Id, hashProviderPass(pass))
+
+	log.WithFields(log.Fields{
+		"type": "provider_rotate",
+		"user": username,
+	}).Info("Rotated provider password")
+
+	return pass, true
+}
+
+func disableProvider(username string, disabled bool) bool {
+	provider := getProvider(username)
+	if provider == nil {
+		return false
+	}
+	setProviderDisabled(provider.Id, disabled)
+
+	log.WithFields(log.Fields{
+		"type":     "provider_disable",
+		"user":     username,
+		"disabled": disabled,
+	}).Info("Changed provider status")
+
+	return true
+}
+
+type SProviderRotate struct {
+	Success  bool   `json:"Success"  example:"true"`
+	Password string `json:"Password" example:"huefw3fw3"`
+}
+
+// @Description Provider - Rotate password
+// @Description Replaces the calling provider's password. A session logged in with
+// @Description the password stays logged in; provider tokens issued before now stop working.
+// @Router /provider/rotate [POST]
+// @Produce json
+// @Success 200 {object} SProviderRotate
+func (self *ProviderHandler) handleRotate(c *gin.Context) {
+	provider := providerOf(c)
+
+	pass, ok := rotateProviderPassword(provider.User)
+	if !ok {
+		c.JSON(http.StatusOK, SProviderRotate{Success: false})
+		return
+	}
+
+	// Keep its open websocket from being dropped as rotated out
+	if cur := getProviderById(provider.Id); cur != nil {
+		if provConn := self.devTracker.getProvConn(provider.Id); provConn != nil {
+			provConn.relogin(cur.PassChanged)
+		}
+	}
+
+	s := self.sessionManager.GetSession(c)
+	if _, inSession := self.sessionManager.session.Get(s, "provider").(ProviderOb); inSession {
+		provider.Login = time.Now()
+		self.sessionManager.session.Put(s, "provider", &provider)
+		self.sessionManager.WriteSession(c)
+	}
+
+	c.JSON(http.StatusOK, SProviderRotate{
+		Success:  true,
+		Password: pass,
+	})
+}
+
+type SProviderRow struct {
+	Id            int64
+	Username      string
+	Disabled      bool
+	Online        bool
+	Health        *SProviderHealth
+	LastLogin     string
+	LoginFailures int
+	LastFailure   string
+}
+
+type SProviderCertRow struct {
+	Serial   string
+	Provider string
+	Created  string
+	Expires  string
+	Expired  bool
+	Revoked  bool
+}
+
+// Show the provider list along with credentials just issued, which are
+// given under the keys newPass, newCert, newKey or newToken plus newFor
+func (self *AdminHandler) showProviderList(c *gin.Context, issued gin.H) {
+	provs, err := getProviders()
+	if err != nil {
+		panic(err)
+	}
+	names := map[int64]string{}
+
+	rows := []SProviderRow{}
+	for _, prov := range provs {
+		names[prov.Id] = prov.Username
+		row := SProviderRow{
+			Id:            prov.Id,
+			Username:      prov.Username,
+			Disabled:      prov.Disabled,
+			LastLogin:     formatTokenTime(prov.LastLogin),
+			LoginFailures: prov.LoginFailures,
+			LastFailure:   formatTokenTime(prov.LastFailure),
+		}
+		if pc := self.devTracker.getProvConn(prov.Id); pc != nil {
+			row.Online = true
+			row.Health = pc.health.snapshot()
+		}
+		rows = append(rows, row)
+	}
+
+	certs, err := getProviderCerts()
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	certRows := []SProviderCertRow{}
+	for _, cert := range certs {
+		certRows = append(certRows, SProviderCertRow{
+			Serial:   cert.Serial,
+			Provider: names[cert.ProviderId],
+			Created:  formatTokenTime(cert.Created),
+			Expires:  formatTokenTime(cert.Expires),
+			Expired:  now.After(cert.Expires),
+			Revoked:  cert.Revoked,
+		})
+	}
+
+	data := gin.H{
+		"providers":   rows,
+		"certs":       certRows,
+		"certAuth":    self.config.provAuthCert,
+		"tokenAuth":   self.config.provAuthToken,
+		"deviceVideo": self.config.text.deviceVideo,
+	}
+	for key, val := range issued {
+		data[key] = val
+	}
+	c.HTML(http.StatusOK, "adminProviders", data)
+}
+
+// @Summary Admin - Provider list
+// @Router /admin/providers [GET]
+func (self *AdminHandler) showProviders(c *gin.Context) {
+	self.showProviderList(c, nil)
+}
+
+func (self *AdminHandler) formProvider(c *gin.Context) *DbProvider {
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	var provider *DbProvider
+	if err == nil {
+		provider = getProviderById(id)
+	}
+	if provider == nil {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "invalid provider id",
+		})
+	}
+	return provider
+}
+
+// @Summary Admin - Rotate provider password
+// @Router /admin/providers/rotate [POST]
+// @Param id formData int true "Provider id"
+func (self *AdminHandler) handleProviderRotate(c *gin.Context) {
+	provider := self.formProvider(c)
+	if provider == nil {
+		return
+	}
+
+	pass, _ := rotateProviderPassword(provider.Username)
+	self.showProviderList(c, gin.H{
+		"newPass": pass,
+		"newFor":  provider.Username,
+	})
+}
+
+// @Summary Admin - Issue provider certificate
+// @Router /admin/providers/cert [POST]
+// @Param id formData int true "Provider id"
+func (self *AdminHandler) handleProviderCert(c *gin.Context) {
+	provider := self.formProvider(c)
+	if provider == nil {
+		return
+	}
+
+	certPem, keyPem, _, err := issueProviderCert(self.config, provider.Username, self.config.provCertDays)
+	if err != nil {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "could not issue certificate: " + err.Error(),
+		})
+		return
+	}
+	self.showProviderList(c, gin.H{
+		"newCert": string(certPem),
+		"newKey":  string(keyPem),
+		"newFor":  provider.Username,
+	})
+}
+
+// @Summary Admin - Revoke provider certificate
+// @Router /admin/providers/cert/revoke [POST]
+// @Param serial formData string true "Certificate serial"
+func (self *AdminHandler) handleProviderCertRevoke(c *gin.Context) {
+	serial := c.PostForm("serial")
+	if revokeProviderCert(serial) {
+		log.WithFields(log.Fields{
+			"type":   "provider_cert_revoke",
+			"serial": serial,
+		}).Info("Revoked provider certificate")
+	}
+
+	c.Redirect(http.StatusFound, "/admin/providers")
+}
+
+// @Summary Admin - Issue provider token
+// @Router /admin/providers/token [POST]
+// @Param id formData int true "Provider id"
+func (self *AdminHandler) handleProviderToken(c *gin.Context) {
+	provider := self.formProvider(c)
+	if provider == nil {
+		return
+	}
+
+	token, _ := issueProviderToken(self.config, provider.Username, self.config.provTokenTtl)
+	self.showProviderList(c, gin.H{
+		"newToken": token,
+		"newFor":   provider.Username,
+	})
+}
+
+// @Summary Admin - Disable or enable provider
+// @Router /admin/providers/disable [POST]
+// @Param id formData int true "Provider id"
+// @Param disabled formData bool true "Whether the provider is disabled"
+func (self *AdminHandler) handleProviderDisable(c *gin.Context) {
+	provider := self.formProvider(c)
+	if provider == nil {
+		return
+	}
+
+	disabled, _ := strconv.ParseBool(c.PostForm("disabled"))
+	disableProvider(provider.Username, disabled)
+
+	c.Redirect(http.StatusFound, "/admin/providers")
+}
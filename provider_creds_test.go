@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckProviderPass(t *testing.T) {
+	hash := hashProviderPass("secret")
+	tests := []struct {
+		name   string
+		stored string
+		pass   string
+		ok     bool
+	}{
+		{"right password", hash, "secret", true},
+		{"wrong password", hash, "secreT", false},
+		{"empty password", hash, "", false},
+		{"legacy plaintext", "secret", "secret", false},
+		{"plaintext containing separators", "sha256$x$secret", "secret", false},
+		{"unknown scheme", strings.Replace(hash, "sha256", "md5", 1), "secret", false},
+		{"extra field", hash + "$x", "secret", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkProviderPass(tt.stored, tt.pass); got != tt.ok {
+				t.Errorf("got %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHashProviderPassSalted(t *testing.T) {
+	a := hashProviderPass("secret")
+	b := hashProviderPass("secret")
+	if a == b {
+		t.Fatalf("two hashes of one password are both %s", a)
+	}
+	if strings.Split(a, "$")[1] == strings.Split(b, "$")[1] {
+		t.Errorf("two hashes share the salt of %s", a)
+	}
+	if strings.Contains(a, "secret") {
+		t.Errorf("hash %s contains the password", a)
+	}
+}
+
+func TestHashPlainProviderPasswords(t *testing.T) {
+	useTestDb(t, new(DbProvider))
+	hashed := hashProviderPass("already")
+	gDb.Insert(&DbProvider{Username: "old", Password: "plain"})
+	gDb.Insert(&DbProvider{Username: "new", Password: hashed})
+
+	hashPlainProviderPasswords(gDb)
+	first := getProvider("old").Password
+	if !checkProviderPass(first, "plain") {
+		t.Fatalf("plaintext password became %s, which doesn't check", first)
+	}
+	if got := getProvider("new").Password; got != hashed {
+		t.Errorf("hashed password became %s", got)
+	}
+
+	// A second run, as on the next start, leaves the hashes alone
+	hashPlainProviderPasswords(gDb)
+	if got := getProvider("old").Password; got != first {
+		t.Errorf("second run rehashed %s into %s", first, got)
+	}
+	if got := getProvider("new").Password; got != hashed {
+		t.Errorf("second run rehashed %s into %s", hashed, got)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+	log "github.com/sirupsen/logrus"
+)
+
+// Wire forms of the messages providers send on their own over /provider/ws
+
+type ProvStatusEvent struct {
+	Id          int16  `json:"id"`
+	Type        string `json:"type"`
+	Udid        string `json:"udid"`
+	Status      string `json:"status"                desc:"exists, info, wdaStarted, wdaStopped, cfaStarted, cfaStopped, videoStarted, videoStopped or provisionStopped"`
+	Width       int    `json:"width,omitempty"       desc:"Video width; exists only"`
+	Height      int    `json:"height,omitempty"      desc:"Video height; exists only"`
+	ClickWidth  int    `json:"clickWidth,omitempty"  desc:"Width in device points; exists only"`
+	ClickHeight int    `json:"clickHeight,omitempty" desc:"Height in device points; exists only"`
+	Info        string `json:"info,omitempty"        desc:"Device info as JSON text; info only"`
+	Port        int    `json:"port,omitempty"        desc:"WDA port; wdaStarted only"`
+}
+
+type ProvOrientationEvent struct {
+	Id          int16  `json:"id"`
+	Type        string `json:"type"`
+	Udid        string `json:"udid"`
+	Orientation string `json:"orientation" desc:"Orientation the device is now in"`
+}
+
+type ProvLogEvent struct {
+	Id    int16  `json:"id"`
+	Type  string `json:"type"`
+	Udid  string `json:"udid"`
+	Level string `json:"level,omitempty" desc:"Severity, such as info or error"`
+	Text  string `json:"text"            desc:"Log line"`
+}
+
+type ProvAlertEvent struct {
+	Id      int16    `json:"id"`
+	Type    string   `json:"type"`
+	Udid    string   `json:"udid"`
+	Text    string   `json:"text"              desc:"Text of the alert shown on the device; empty once it is dismissed"`
+	Buttons []string `json:"buttons,omitempty" desc:"Buttons the alert offers"`
+}
+
+type ProvCrashEvent struct {
+	Id     int16  `json:"id"`
+	Type   string `json:"type"`
+	Udid   string `json:"udid"`
+	Bid    string `json:"bid,omitempty" desc:"Bundle id of the app that crashed"`
+	Reason string `json:"reason"        desc:"What the crash report says happened"`
+}
+
+// A message a provider sent on its own rather than in response
+type ProvEvent struct {
+	provider ProviderOb
+	conn     *ProviderConnection
+	msgType  string
+	raw      []byte
+	root     uj.JNode
+}
+
+// Decode the event into its wire form. Providers on the relaxed protocol may
+// not send strict JSON, so their messages are decoded from the parsed form.
+func (self *ProvEvent) decode(out interface{}) bool {
+	err := json.Unmarshal(self.raw, out)
+	if err != nil && self.conn.reqTracker.protocol < ProvProtoJson {
+		err = json.Unmarshal([]byte(self.root.JsonSave()), out)
+	}
+	if err != nil {
+		fmt.Printf("Could not decode %s message from %s: %s\n", self.msgType, self.provider.User, err)
+		return false
+	}
+	return true
+}
+
+// Whether the provider is the one providing a device; events about devices
+// of other providers are dropped
+func (self *ProvEvent) owns(devTracker *DevTracker, udid string) bool {
+	if devTracker.getDevProvId(udid) == self.provider.Id {
+		return true
+	}
+	fmt.Printf("Dropping %s message from %s for a device it doesn't provide: %s\n",
+		self.msgType, self.provider.User, censorUuid(udid))
+	return false
+}
+
+var provEventHandlers = map[string]func(*ProviderHandler, *ProvEvent){
+	"capabilities": (*ProviderHandler).onCapabilities,
+	"status":       (*ProviderHandler).onDevStatus,
+	"orientation":  (*ProviderHandler).onDevOrientation,
+	"log":          (*ProviderHandler).onDevLog,
+	"alert":        (*ProviderHandler).onDevAlert,
+	"crash":        (*ProviderHandler).onDevCrash,
+}
+
+// Act on a message the provider sent on its own rather than in response
+func (self *ProviderHandler) handleProviderMsg(provider ProviderOb, provConn *ProviderConnection, root uj.JNode, raw []byte) {
+	typeNode := root.Get("type")
+	if typeNode == nil {
+		fmt.Printf("Provider message without type from %s\n", provider.User)
+		return
+	}
+	handler, ok := provEventHandlers[typeNode.String()]
+	if !ok {
+		fmt.Printf("Unknown message type %s from %s\n", typeNode.String(), provider.User)
+		return
+	}
+	handler(self, &ProvEvent{
+		provider: provider,
+		conn:     provConn,
+		msgType:  typeNode.String(),
+		raw:      raw,
+		root:     root,
+	})
+}
+
+func (self *ProviderHandler) onCapabilities(ev *ProvEvent) {
+	msg := &ProvCapabilitiesMsg{}
+	if ev.decode(msg) {
+		ev.conn.setCapabilities(ev.provider, msg)
+	}
+}
+
+func (self *ProviderHandler) onDevStatus(ev *ProvEvent) {
+	msg := &ProvStatusEvent{}
+	if !ev.decode(msg) {
+		return
+	}
+	// A device only becomes the provider's once it says the device exists
+	if msg.Status != "exists" && !ev.owns(self.devTracker, msg.Udid) {
+		return
+	}
+	if !self.devTracker.applyDevStatus(ev.provider, msg) {
+		fmt.Printf("Unknown device status %s from %s\n", msg.Status, ev.provider.User)
+	}
+}
+
+func (self *ProviderHandler) onDevOrientation(ev *ProvEvent) {
+	msg := &ProvOrientationEvent{}
+	if ev.decode(msg) && ev.owns(self.devTracker, msg.Udid) {
+		self.devTracker.setOrientation(msg.Udid, msg.Orientation)
+	}
+}
+
+func (self *ProviderHandler) onDevLog(ev *ProvEvent) {
+	msg := &ProvLogEvent{}
+	if ev.decode(msg) && ev.owns(self.devTracker, msg.Udid) {
+		self.devTracker.sendNotice(msg.Udid, []byte(provMsgText(msg)))
+	}
+}
+
+func (self *ProviderHandler) onDevAlert(ev *ProvEvent) {
+	msg := &ProvAlertEvent{}
+	if !ev.decode(msg) || !ev.owns(self.devTracker, msg.Udid) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"type": "device_alert",
+		"udid": censorUuid(msg.Udid),
+		"text": msg.Text,
+	}).Info("Device alert")
+
+	self.devTracker.sendNotice(msg.Udid, []byte(provMsgText(msg)))
+}
+
+func (self *ProviderHandler) onDevCrash(ev *ProvEvent) {
+	msg := &ProvCrashEvent{}
+	if !ev.decode(msg) || !ev.owns(self.devTracker, msg.Udid) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"type":   "device_crash",
+		"udid":   censorUuid(msg.Udid),
+		"bid":    msg.Bid,
+		"reason": msg.Reason,
+	}).Warn("App crashed on device")
+
+	self.devTracker.sendNotice(msg.Udid, []byte(provMsgText(msg)))
+}
+
+// Apply a device status change, whether the provider sent it over its
+// websocket or posted it to /provider/device/status. False if the status is
+// unknown.
+func (self *DevTracker) applyDevStatus(provider ProviderOb, msg *ProvStatusEvent) bool {
+	udid := msg.Udid
+	switch msg.Status {
+	case "exists":
+		fmt.Printf("Device Status: Provider started - udid: %s - provider: %s\n", udid, provider.User)
+		// Any provider may say a device exists, but one already provided
+		// stays with its provider
+		if holder := self.claimDev(udid, provider.Id); holder != 0 {
+			log.WithFields(log.Fields{
+				"type":   "device_claim_refused",
+				"udid":   censorUuid(udid),
+				"user":   provider.User,
+				"holder": holder,
+			}).Warn("Provider claimed a device another provider is providing")
+			return true
+		}
+		addDevice(udid, "unknown", provider.Id, provider.User, msg.Width, msg.Height, msg.ClickWidth, msg.ClickHeight)
+		self.waitlist.handOver(udid)
+	case "info":
+		fmt.Printf("Device Status: Info - udid: %s\n%s\n", udid, msg.Info)
+		updateDeviceInfo(udid, msg.Info, provider.Id)
+	case "wdaStarted":
+		fmt.Printf("Device Status: WDA started - udid: %s\n - port %d\n", udid, msg.Port)
+		self.setDevStatus(udid, "wda", true)
+		updateDeviceWdaPort(udid, msg.Port)
+	case "wdaStopped":
+		fmt.Printf("Device Status: WDA stopped - udid: %s\n\n", udid)
+		self.setDevStatus(udid, "wda", false)
+	case "cfaStarted":
+		fmt.Printf("Device Status: CFA started - udid: %s\n\n", udid)
+		self.setDevStatus(udid, "cfa", true)
+	case "cfaStopped":
+		fmt.Printf("Device Status: CFA stopped - udid: %s\n\n", udid)
+		self.setDevStatus(udid, "cfa", false)
+	case "videoStarted":
+		fmt.Printf("Video started for %s\n", udid)
+		self.setDevStatus(udid, "video", true)
+	case "videoStopped":
+		fmt.Printf("Device Status: Video stopped - udid: %s\n\n", udid)
+		self.setDevStatus(udid, "video", false)
+	case "provisionStopped":
+		fmt.Printf("Device Status: Provider stopped - udid: %s\n\n", udid)
+		self.clearDevProv(udid)
+		notice := DevStatusNotice{
+			Type:   "status",
+			Status: "offline",
+			Reason: "provision_stopped",
+		}
+		self.sendNotice(udid, notice.asBytes())
+		return true
+	default:
+		return false
+	}
+
+	notice := DevStatusNotice{
+		Type:   "status",
+		Status: msg.Status,
+	}
+	self.sendNotice(udid, notice.asBytes())
+	return true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// The devices of a disconnected provider, held for it until it reconnects
+// or the grace period runs out
+type ProvGrace struct {
+	timer *time.Timer
+	since time.Time
+}
+
+// The devices a provider is providing; the caller holds the lock
+func (self *DevTracker) provDevices(provId int64) []string {
+	udids := []string{}
+	for udid, devProvId := range self.devToProv {
+		if devProvId == provId {
+			udids = append(udids, udid)
+		}
+	}
+	return udids
+}
+
+func (self *DevTracker) devProvConn(udid string) *ProviderConnection {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.provConns[self.devToProv[udid]]
+}
+
+// Whether the provider of a device dropped and hasn't come back yet
+func (self *DevTracker) devReconnecting(udid string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	provId, ok := self.devToProv[udid]
+	return ok && self.provGrace[provId] != nil
+}
+
+// Keep the devices of a provider that dropped until the grace period is over;
+// the caller holds the lock and has already removed the connection
+func (self *DevTracker) startGrace(provId int64) []string {
+	grace := &ProvGrace{since: time.Now()}
+	grace.timer = time.AfterFunc(self.config.providerGrace, func() {
+		self.expireGrace(provId, grace)
+	})
+	self.provGrace[provId] = grace
+	return self.provDevices(provId)
+}
+
+func (self *DevTracker) expireGrace(provId int64, grace *ProvGrace) {
+	self.lock.Lock()
+	if self.provGrace[provId] != grace {
+		self.lock.Unlock()
+		return
+	}
+	delete(self.provGrace, provId)
+	self.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"type":     "provider_grace_expired",
+		"provider": provId,
+	}).Info("Provider did not reconnect in time")
+
+	self.provOffline(provId)
+}
+
+// Take every device of a provider offline
+func (self *DevTracker) provOffline(provId int64) {
+	self.lock.Lock()
+	udids := self.provDevices(provId)
+	for _, udid := range udids {
+		delete(self.devToProv, udid)
+		delete(self.DevStatus, udid)
+		// Video streams waiting on the provider end with it
+		if wait := self.streamWaits[udid]; wait != nil {
+			delete(self.streamWaits, udid)
+			close(wait)
+		}
+	}
+	self.lock.Unlock()
+
+	notice := DevStatusNotice{
+		Type:   "status",
+		Status: "offline",
+		Reason: ReqErrProviderGone,
+	}
+	for _, udid := range udids {
+		log.WithFields(log.Fields{
+			"type":     "device_offline",
+			"udid":     censorUuid(udid),
+			"provider": provId,
+		}).Info("Device offline; provider disconnected")
+
+		self.sendNotice(udid, notice.asBytes())
+	}
+}
+
+// Pick up where a provider that reconnected within its grace period left off:
+// tell browsers its devices are back and ask for the video streams that were
+// cut short
+func (self *DevTracker) reattachProv(provId int64, provConn *ProviderConnection, grace *ProvGrace, udids []string) {
+	self.lock.Lock()
+	streams := []string{}
+	for _, udid := range udids {
+		if self.streamWaits[udid] != nil {
+			streams = append(streams, udid)
+		}
+	}
+	self.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"type":     "provider_reattached",
+		"provider": provId,
+		"devices":  len(udids),
+		"streams":  len(streams),
+		"awayMs":   time.Since(grace.since).Milliseconds(),
+	}).Info("Provider reconnected within grace period")
+
+	notice := DevStatusNotice{
+		Type:   "status",
+		Status: "reconnected",
+	}
+	for _, udid := range udids {
+		self.sendNotice(udid, notice.asBytes())
+	}
+
+	// The send loop of the new connection isn't running yet
+	go func() {
+		for _, udid := range streams {
+			provConn.startImgStream(udid)
+		}
+	}()
+}
+
+// Wait for a provider whose image stream dropped to send a new one, for as
+// long as its devices are held for it. Nil if the stream isn't coming back.
+func (self *DevTracker) awaitStream(udid string, done chan bool) *ws.Conn {
+	if self.config.providerGrace <= 0 {
+		return nil
+	}
+	self.lock.Lock()
+	if _, ok := self.devToProv[udid]; !ok {
+		self.lock.Unlock()
+		return nil
+	}
+	wait := make(chan *ws.Conn, 1)
+	self.streamWaits[udid] = wait
+	self.lock.Unlock()
+
+	var conn *ws.Conn
+	select {
+	case conn = <-wait:
+	case <-done:
+	case <-time.After(self.config.providerGrace + self.config.providerTimeout):
+	}
+
+	self.lock.Lock()
+	if self.streamWaits[udid] == wait {
+		delete(self.streamWaits, udid)
+	}
+	self.lock.Unlock()
+
+	if conn == nil {
+		// A stream may have been handed over just as the wait ended
+		select {
+		case late := <-wait:
+			if late != nil {
+				late.Close()
+			}
+		default:
+		}
+	}
+	return conn
+}
+
+// Hand a new image stream of a device to the ingest waiting for one; false
+// if there is none
+func (self *DevTracker) resumeStream(udid string, conn *ws.Conn) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	wait := self.streamWaits[udid]
+	if wait == nil {
+		return false
+	}
+	delete(self.streamWaits, udid)
+	wait <- conn
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// States of a provider as judged from its pings, or while it reconnects
+const (
+	ProvHealthy      = "healthy"
+	ProvDegraded     = "degraded"
+	ProvReconnecting = "reconnecting"
+)
+
+// Whether devices of a provider in a state are best avoided
+func provUnreliable(state string) bool {
+	return state == ProvDegraded || state == ProvReconnecting
+}
+
+// Round trips and missed pings of a provider connection
+type ProvHealth struct {
+	lock        *sync.Mutex
+	config      *HeartbeatConfig
+	rtts        []time.Duration
+	missed      int
+	totalMissed int
+	pings       int
+	lastPong    time.Time
+	state       string
+}
+
+func NewProvHealth(config *HeartbeatConfig) *ProvHealth {
+	return &ProvHealth{
+		lock:   &sync.Mutex{},
+		config: config,
+		state:  ProvHealthy,
+	}
+}
+
+type SProviderHealth struct {
+	State        string  `json:"state"        example:"healthy"`
+	LatencyMs    int64   `json:"latencyMs"    example:"12"`
+	AvgLatencyMs int64   `json:"avgLatencyMs" example:"15"`
+	MaxLatencyMs int64   `json:"maxLatencyMs" example:"40"`
+	Missed       int     `json:"missed"       example:"0"`
+	TotalMissed  int     `json:"totalMissed"  example:"3"`
+	Pings        int     `json:"pings"        example:"120"`
+	LastPong     string  `json:"lastPong"     example:"2021-06-01 12:00:00"`
+	History      []int64 `json:"history"`
+}
+
+func (self *ProvHealth) avgRtt() time.Duration {
+	if len(self.rtts) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, rtt := range self.rtts {
+		total += rtt
+	}
+	return total / time.Duration(len(self.rtts))
+}
+
+// Work out the state after a ping; the caller holds the lock. Returns the
+// previous state if it changed, or "" if not.
+func (self *ProvHealth) judge() string {
+	state := ProvHealthy
+	if self.missed >= self.config.degradedMisses && self.config.degradedMisses > 0 {
+		state = ProvDegraded
+	}
+	if self.config.degradedLatency > 0 && self.avgRtt() > self.config.degradedLatency {
+		state = ProvDegraded
+	}
+	if state == self.state {
+		return ""
+	}
+	prev := self.state
+	self.state = state
+	return prev
+}
+
+// Record a ping answered after rtt; returns the previous state if it changed
+func (self *ProvHealth) pong(rtt time.Duration) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.pings++
+	self.missed = 0
+	self.lastPong = time.Now()
+	self.rtts = append(self.rtts, rtt)
+	if len(self.rtts) > self.config.history {
+		self.rtts = self.rtts[len(self.rtts)-self.config.history:]
+	}
+	return self.judge()
+}
+
+// Record a ping that went unanswered; returns the previous state if it
+// changed, and whether enough were missed in a row to give up on the provider
+func (self *ProvHealth) miss() (string, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.pings++
+	self.missed++
+	self.totalMissed++
+	lost := self.config.disconnectMisses > 0 && self.missed >= self.config.disconnectMisses
+	return self.judge(), lost
+}
+
+func (self *ProvHealth) getState() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.state
+}
+
+func (self *ProvHealth) snapshot() *SProviderHealth {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	res := &SProviderHealth{
+		State:        self.state,
+		AvgLatencyMs: self.avgRtt().Milliseconds(),
+		Missed:       self.missed,
+		TotalMissed:  self.totalMissed,
+		Pings:        self.pings,
+		LastPong:     formatTokenTime(self.lastPong),
+		History:      []int64{},
+	}
+	for _, rtt := range self.rtts {
+		ms := rtt.Milliseconds()
+		res.History = append(res.History, ms)
+		if ms > res.MaxLatencyMs {
+			res.MaxLatencyMs = ms
+		}
+	}
+	if len(res.History) > 0 {
+		res.LatencyMs = res.History[len(res.History)-1]
+	}
+	return res
+}
+
+func logHealthChange(provider ProviderOb, health *ProvHealth, prev string) {
+	if prev == "" {
+		return
+	}
+	stat := health.snapshot()
+	fields := log.Fields{
+		"user":         provider.User,
+		"missed":       stat.Missed,
+		"avgLatencyMs": stat.AvgLatencyMs,
+	}
+	if stat.State == ProvDegraded {
+		fields["type"] = "provider_degraded"
+		log.WithFields(fields).Warn("Provider degraded")
+	} else {
+		fields["type"] = "provider_recovered"
+		log.WithFields(fields).Info("Provider recovered")
+	}
+}
+
+// Health of the provider of a device; nil if the provider isn't connected
+func (self *DevTracker) devProvHealth(udid string) *ProvHealth {
+	pc := self.getProvConn(self.getDevProvId(udid))
+	if pc == nil {
+		return nil
+	}
+	return pc.health
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProvHealthMiss(t *testing.T) {
+	tests := []struct {
+		name             string
+		degradedMisses   int
+		disconnectMisses int
+		misses           int
+		state            string
+		lost             bool
+	}{
+		{"one miss", 2, 6, 1, ProvHealthy, false},
+		{"degraded", 2, 6, 2, ProvDegraded, false},
+		{"lost", 2, 6, 6, ProvDegraded, true},
+		{"past lost", 2, 6, 7, ProvDegraded, true},
+		{"degraded on first miss", 1, 6, 1, ProvDegraded, false},
+		{"never degraded by misses", 0, 6, 5, ProvHealthy, false},
+		{"never lost", 2, 0, 100, ProvDegraded, false},
+		{"neither", 0, 0, 100, ProvHealthy, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewProvHealth(&HeartbeatConfig{
+				history:          10,
+				degradedMisses:   tt.degradedMisses,
+				disconnectMisses: tt.disconnectMisses,
+			})
+			lost := false
+			for i := 0; i < tt.misses; i++ {
+				_, lost = health.miss()
+			}
+			if state := health.getState(); state != tt.state {
+				t.Errorf("got state %s, want %s", state, tt.state)
+			}
+			if lost != tt.lost {
+				t.Errorf("got lost %t, want %t", lost, tt.lost)
+			}
+		})
+	}
+}
+
+func TestProvHealthJudge(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name            string
+		history         int
+		degradedLatency time.Duration
+		rtts            []time.Duration
+		state           string
+	}{
+		{"fast", 10, 100 * ms, []time.Duration{10 * ms, 20 * ms}, ProvHealthy},
+		{"slow on average", 10, 100 * ms, []time.Duration{50 * ms, 200 * ms}, ProvDegraded},
+		{"at the limit", 10, 100 * ms, []time.Duration{100 * ms}, ProvHealthy},
+		{"slow one aged out", 2, 100 * ms, []time.Duration{time.Second, 10 * ms, 10 * ms}, ProvHealthy},
+		{"latency not checked", 10, 0, []time.Duration{10 * time.Second}, ProvHealthy},
+		{"no history", 0, 100 * ms, []time.Duration{time.Second}, ProvHealthy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewProvHealth(&HeartbeatConfig{
+				history:         tt.history,
+				degradedMisses:  2,
+				degradedLatency: tt.degradedLatency,
+			})
+			for _, rtt := range tt.rtts {
+				health.pong(rtt)
+			}
+			if state := health.getState(); state != tt.state {
+				t.Errorf("got state %s, want %s", state, tt.state)
+			}
+		})
+	}
+}
+
+// judge reports the state left behind only when it changes
+func TestProvHealthTransitions(t *testing.T) {
+	health := NewProvHealth(&HeartbeatConfig{
+		history:          10,
+		degradedMisses:   2,
+		disconnectMisses: 6,
+	})
+	if prev, _ := health.miss(); prev != "" {
+		t.Errorf("first miss changed state from %s", prev)
+	}
+	if prev, _ := health.miss(); prev != ProvHealthy {
+		t.Errorf("second miss gave %q, want %q", prev, ProvHealthy)
+	}
+	if prev, _ := health.miss(); prev != "" {
+		t.Errorf("third miss changed state from %s", prev)
+	}
+	if prev := health.pong(time.Millisecond); prev != ProvDegraded {
+		t.Errorf("pong gave %q, want %q", prev, ProvDegraded)
+	}
+	if stat := health.snapshot(); stat.Missed != 0 || stat.TotalMissed != 3 || stat.Pings != 4 {
+		t.Errorf("got missed %d, total %d, pings %d; want 0, 3, 4", stat.Missed, stat.TotalMissed, stat.Pings)
+	}
+}
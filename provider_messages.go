@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"strconv"
 
 	uj "github.com/nanoscopic/ujsonin/v2/mod"
 )
@@ -13,6 +13,79 @@ type ProvBase interface {
 	resHandler() func(uj.JNode, []byte)
 }
 
+// Wire forms of the messages sent to providers. Field descriptions are
+// carried into the protocol schema served at /provider/protocol.
+type ProvMsg struct {
+	Id   int16  `json:"id"   desc:"Request id echoed in the response; 0 when no response is expected"`
+	Type string `json:"type" desc:"Message type"`
+}
+
+type ProvUdidMsg struct {
+	Id   int16  `json:"id"`
+	Type string `json:"type"`
+	Udid string `json:"udid" desc:"Device UDID"`
+}
+
+type ProvPointMsg struct {
+	Id   int16  `json:"id"`
+	Type string `json:"type"`
+	Udid string `json:"udid"`
+	X    int    `json:"x" desc:"X coordinate in device points"`
+	Y    int    `json:"y" desc:"Y coordinate in device points"`
+}
+
+type ProvAppMsg struct {
+	Id   int16  `json:"id"`
+	Type string `json:"type"`
+	Udid string `json:"udid"`
+	Bid  string `json:"bid" desc:"App bundle id"`
+}
+
+type ProvLongPressMsg struct {
+	Id   int16  `json:"id"`
+	Type string `json:"type"`
+	Udid string `json:"udid"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Time string `json:"time" desc:"Seconds to hold, as a decimal string"`
+}
+
+type ProvKeysMsg struct {
+	Id       int16  `json:"id"`
+	Type     string `json:"type"`
+	Udid     string `json:"udid"`
+	Keys     string `json:"keys"     desc:"Comma separated key codes"`
+	Curid    int    `json:"curid"    desc:"Sequence number of this batch of keys"`
+	Prevkeys string `json:"prevkeys" desc:"Keys of the previous batch"`
+}
+
+type ProvSwipeMsg struct {
+	Id    int16  `json:"id"`
+	Type  string `json:"type"`
+	Udid  string `json:"udid"`
+	X1    int    `json:"x1"`
+	Y1    int    `json:"y1"`
+	X2    int    `json:"x2"`
+	Y2    int    `json:"y2"`
+	Delay int    `json:"delay" desc:"Swipe duration in hundredths of a second"`
+}
+
+type ProvStreamQualityMsg struct {
+	Id      int16  `json:"id"`
+	Type    string `json:"type"`
+	Udid    string `json:"udid"`
+	MaxFps  int    `json:"maxFps"  desc:"Frame rate cap; 0 for none"`
+	Quality string `json:"quality" desc:"Image quality level"`
+}
+
+func provMsgText(msg interface{}) string {
+	res, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return string(res)
+}
+
 type ProvPing struct {
 	blah  string
 	onRes func(uj.JNode, []byte)
@@ -21,7 +94,7 @@ type ProvPing struct {
 func (self *ProvPing) resHandler() func(uj.JNode, []byte) { return self.onRes }
 func (self *ProvPing) needsResponse() bool                { return true }
 func (self *ProvPing) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"ping\"}\n", id)
+	return provMsgText(ProvMsg{Id: id, Type: "ping"})
 }
 
 type ProvDoubleclick struct {
@@ -36,7 +109,7 @@ func (self *ProvDoubleclick) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvDoubleclick) needsResponse() bool { return true }
 func (self *ProvDoubleclick) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"doubleclick\",udid:\"%s\",x:%d,y:%d}\n", id, self.udid, self.x, self.y)
+	return provMsgText(ProvPointMsg{Id: id, Type: "doubleclick", Udid: self.udid, X: self.x, Y: self.y})
 }
 
 type ProvClick struct {
@@ -51,7 +124,7 @@ func (self *ProvClick) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvClick) needsResponse() bool { return true }
 func (self *ProvClick) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"click\",udid:\"%s\",x:%d,y:%d}\n", id, self.udid, self.x, self.y)
+	return provMsgText(ProvPointMsg{Id: id, Type: "click", Udid: self.udid, X: self.x, Y: self.y})
 }
 
 type ProvLaunch struct {
@@ -65,7 +138,7 @@ func (self *ProvLaunch) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvLaunch) needsResponse() bool { return true }
 func (self *ProvLaunch) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"launch\",udid:\"%s\",bid:\"%s\"}\n", id, self.udid, self.bid)
+	return provMsgText(ProvAppMsg{Id: id, Type: "launch", Udid: self.udid, Bid: self.bid})
 }
 
 type ProvKill struct {
@@ -79,7 +152,7 @@ func (self *ProvKill) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvKill) needsResponse() bool { return true }
 func (self *ProvKill) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"kill\",udid:\"%s\",bid:\"%s\"}\n", id, self.udid, self.bid)
+	return provMsgText(ProvAppMsg{Id: id, Type: "kill", Udid: self.udid, Bid: self.bid})
 }
 
 type ProvAllowApp struct {
@@ -93,7 +166,7 @@ func (self *ProvAllowApp) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvAllowApp) needsResponse() bool { return true }
 func (self *ProvAllowApp) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"allowApp\",udid:\"%s\",bid:\"%s\"}\n", id, self.udid, self.bid)
+	return provMsgText(ProvAppMsg{Id: id, Type: "allowApp", Udid: self.udid, Bid: self.bid})
 }
 
 type ProvRestrictApp struct {
@@ -107,7 +180,7 @@ func (self *ProvRestrictApp) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvRestrictApp) needsResponse() bool { return true }
 func (self *ProvRestrictApp) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"restrictApp\",udid:\"%s\",bid:\"%s\"}\n", id, self.udid, self.bid)
+	return provMsgText(ProvAppMsg{Id: id, Type: "restrictApp", Udid: self.udid, Bid: self.bid})
 }
 
 type ProvListRestrictedApps struct {
@@ -121,7 +194,7 @@ func (self *ProvListRestrictedApps) resHandler() func(data uj.JNode, rawData []b
 }
 func (self *ProvListRestrictedApps) needsResponse() bool { return true }
 func (self *ProvListRestrictedApps) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"listRestrictedApps\",udid:\"%s\"}\n", id, self.udid, self.bid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "listRestrictedApps", Udid: self.udid})
 }
 
 type ProvMouseDown struct {
@@ -136,7 +209,7 @@ func (self *ProvMouseDown) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvMouseDown) needsResponse() bool { return true }
 func (self *ProvMouseDown) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"mouseDown\",udid:\"%s\",x:%d,y:%d}\n", id, self.udid, self.x, self.y)
+	return provMsgText(ProvPointMsg{Id: id, Type: "mouseDown", Udid: self.udid, X: self.x, Y: self.y})
 }
 
 type ProvMouseUp struct {
@@ -151,7 +224,7 @@ func (self *ProvMouseUp) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvMouseUp) needsResponse() bool { return true }
 func (self *ProvMouseUp) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"mouseUp\",udid:\"%s\",x:%d,y:%d}\n", id, self.udid, self.x, self.y)
+	return provMsgText(ProvPointMsg{Id: id, Type: "mouseUp", Udid: self.udid, X: self.x, Y: self.y})
 }
 
 type ProvHardPress struct {
@@ -163,14 +236,14 @@ type ProvHardPress struct {
 func (self *ProvHardPress) resHandler() func(uj.JNode, []byte) { return nil }
 func (self *ProvHardPress) needsResponse() bool                { return false }
 func (self *ProvHardPress) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"hardPress\",udid:\"%s\",x:%d,y:%d}\n", id, self.udid, self.x, self.y)
+	return provMsgText(ProvPointMsg{Id: id, Type: "hardPress", Udid: self.udid, X: self.x, Y: self.y})
 }
 
 type ProvInitWebrtcMsg struct{
     Id int16 `json:"id"`
     Type string `json:"type"`
     Udid string `json:"udid"`
-    Offer string `json:"offer"`
+    Offer string `json:"offer" desc:"WebRTC session description offer"`
 }
 type ProvInitWebrtc struct {
     udid string
@@ -182,14 +255,12 @@ func (self *ProvInitWebrtc) resHandler() (func(uj.JNode,[]byte) ) {
 }
 func (self *ProvInitWebrtc) needsResponse() (bool) { return true }
 func (self *ProvInitWebrtc) asText( id int16 ) (string) {
-    msg := ProvInitWebrtcMsg{
+    return provMsgText( ProvInitWebrtcMsg{
         Id: id,
         Type: "initWebrtc",
         Udid: self.udid,
         Offer: self.offer,
-    }
-    res, _ := json.Marshal( msg )
-    return string(res)
+    } )
 }
 
 type ProvLongPress struct {
@@ -205,7 +276,14 @@ func (self *ProvLongPress) resHandler() func(uj.JNode, []byte) {
 }
 func (self *ProvLongPress) needsResponse() bool { return true }
 func (self *ProvLongPress) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"longPress\",udid:\"%s\",x:%d,y:%d,time:\"%f\"}\n", id, self.udid, self.x, self.y, self.time)
+	return provMsgText(ProvLongPressMsg{
+		Id:   id,
+		Type: "longPress",
+		Udid: self.udid,
+		X:    self.x,
+		Y:    self.y,
+		Time: strconv.FormatFloat(self.time, 'f', 6, 64),
+	})
 }
 
 type ProvHome struct {
@@ -218,7 +296,7 @@ func (self *ProvHome) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvHome) needsResponse() bool { return true }
 func (self *ProvHome) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"home\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "home", Udid: self.udid})
 }
 
 type ProvShake struct {
@@ -231,7 +309,7 @@ func (self *ProvShake) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvShake) needsResponse() bool { return true }
 func (self *ProvShake) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"shake\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "shake", Udid: self.udid})
 }
 
 type ProvCC struct {
@@ -244,7 +322,7 @@ func (self *ProvCC) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvCC) needsResponse() bool { return true }
 func (self *ProvCC) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"cc\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "cc", Udid: self.udid})
 }
 
 type ProvAssistiveTouch struct {
@@ -257,7 +335,7 @@ func (self *ProvAssistiveTouch) resHandler() func(data uj.JNode, rawData []byte)
 }
 func (self *ProvAssistiveTouch) needsResponse() bool { return true }
 func (self *ProvAssistiveTouch) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"assistiveTouch\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "assistiveTouch", Udid: self.udid})
 }
 
 type ProvTaskSwitcher struct {
@@ -270,7 +348,7 @@ func (self *ProvTaskSwitcher) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvTaskSwitcher) needsResponse() bool { return true }
 func (self *ProvTaskSwitcher) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"taskSwitcher\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "taskSwitcher", Udid: self.udid})
 }
 
 type ProvWifiIp struct {
@@ -283,7 +361,7 @@ func (self *ProvWifiIp) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvWifiIp) needsResponse() bool { return true }
 func (self *ProvWifiIp) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"wifiIp\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "wifiIp", Udid: self.udid})
 }
 
 type ProvRefresh struct {
@@ -296,7 +374,7 @@ func (self *ProvRefresh) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvRefresh) needsResponse() bool { return true }
 func (self *ProvRefresh) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"refresh\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "refresh", Udid: self.udid})
 }
 
 type ProvRestart struct {
@@ -309,7 +387,7 @@ func (self *ProvRestart) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvRestart) needsResponse() bool { return true }
 func (self *ProvRestart) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"restart\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "restart", Udid: self.udid})
 }
 
 type ProvSource struct {
@@ -322,7 +400,21 @@ func (self *ProvSource) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvSource) needsResponse() bool { return true }
 func (self *ProvSource) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"source\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "source", Udid: self.udid})
+}
+
+// Grab one screen image; the provider answers with base64 JPEG or PNG in data
+type ProvScreenshot struct {
+	udid  string
+	onRes func(uj.JNode, []byte)
+}
+
+func (self *ProvScreenshot) resHandler() func(data uj.JNode, rawData []byte) {
+	return self.onRes
+}
+func (self *ProvScreenshot) needsResponse() bool { return true }
+func (self *ProvScreenshot) asText(id int16) string {
+	return provMsgText(ProvUdidMsg{Id: id, Type: "screenshot", Udid: self.udid})
 }
 
 type ProvShutdown struct {
@@ -332,7 +424,7 @@ type ProvShutdown struct {
 func (self *ProvShutdown) resHandler() func(uj.JNode, []byte) { return nil }
 func (self *ProvShutdown) needsResponse() bool                { return false }
 func (self *ProvShutdown) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"shutdown\"}\n", id)
+	return provMsgText(ProvMsg{Id: id, Type: "shutdown"})
 }
 
 type ProvKeys struct {
@@ -348,15 +440,21 @@ func (self *ProvKeys) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvKeys) needsResponse() bool { return true }
 func (self *ProvKeys) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"keys\",udid:\"%s\",keys:\"%s\",curid:%d,prevkeys:\"%s\"}\n",
-		id, self.udid, self.keys, self.curid, self.prevkeys)
+	return provMsgText(ProvKeysMsg{
+		Id:       id,
+		Type:     "keys",
+		Udid:     self.udid,
+		Keys:     self.keys,
+		Curid:    self.curid,
+		Prevkeys: self.prevkeys,
+	})
 }
 
 type ProvTestMsg struct {
 	Id   int16  `json:"id"`
 	Type string `json:"type"`
 	Udid string `json:"udid"`
-	Text string `json:"text"`
+	Text string `json:"text" desc:"Text to type"`
 }
 type ProvText struct {
 	udid  string
@@ -369,14 +467,12 @@ func (self *ProvText) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvText) needsResponse() bool { return true }
 func (self *ProvText) asText(id int16) string {
-	msg := ProvTestMsg{
+	return provMsgText(ProvTestMsg{
 		Id:   id,
 		Type: "text",
 		Udid: self.udid,
 		Text: self.text,
-	}
-	res, _ := json.Marshal(msg)
-	return string(res)
+	})
 }
 
 type ProvSwipe struct {
@@ -394,9 +490,16 @@ func (self *ProvSwipe) resHandler() func(data uj.JNode, rawData []byte) {
 }
 func (self *ProvSwipe) needsResponse() bool { return true }
 func (self *ProvSwipe) asText(id int16) string {
-	delayBy100 := int(self.delay * 100)
-	return fmt.Sprintf("{id:%d,type:\"swipe\",udid:\"%s\",x1:%d,y1:%d,x2:%d,y2:%d,delay:%d}\n",
-		id, self.udid, self.x1, self.y1, self.x2, self.y2, delayBy100)
+	return provMsgText(ProvSwipeMsg{
+		Id:    id,
+		Type:  "swipe",
+		Udid:  self.udid,
+		X1:    self.x1,
+		Y1:    self.y1,
+		X2:    self.x2,
+		Y2:    self.y2,
+		Delay: int(self.delay * 100),
+	})
 }
 
 type ProvStartStream struct {
@@ -406,7 +509,7 @@ type ProvStartStream struct {
 func (self *ProvStartStream) resHandler() func(uj.JNode, []byte) { return nil }
 func (self *ProvStartStream) needsResponse() bool                { return false }
 func (self *ProvStartStream) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"startStream\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "startStream", Udid: self.udid})
 }
 
 type ProvStopStream struct {
@@ -418,9 +521,29 @@ func (self *ProvStopStream) resHandler() func(uj.JNode, []byte) {
 }
 
 func (self *ProvStopStream) asText(id int16) string {
-	return fmt.Sprintf("{id:%d,type:\"stopStream\",udid:\"%s\"}\n", id, self.udid)
+	return provMsgText(ProvUdidMsg{Id: id, Type: "stopStream", Udid: self.udid})
 }
 
 func (self *ProvStopStream) needsResponse() bool {
 	return false
 }
+
+// Cap the frame rate and quality of a running image stream; a maxFps of 0
+// lifts the cap
+type ProvStreamQuality struct {
+	udid    string
+	maxFps  int
+	quality string
+}
+
+func (self *ProvStreamQuality) resHandler() func(uj.JNode, []byte) { return nil }
+func (self *ProvStreamQuality) needsResponse() bool                { return false }
+func (self *ProvStreamQuality) asText(id int16) string {
+	return provMsgText(ProvStreamQualityMsg{
+		Id:      id,
+		Type:    "streamQuality",
+		Udid:    self.udid,
+		MaxFps:  self.maxFps,
+		Quality: self.quality,
+	})
+}
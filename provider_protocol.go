@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Versions of the provider websocket protocol
+const (
+	// Providers that predate versioning; their messages may be relaxed JSON
+	ProvProtoRelaxed = 1
+	// Every message in both directions is strict JSON matching the schema
+	ProvProtoJson = 2
+)
+
+var provProtoVersions = []int{ProvProtoRelaxed, ProvProtoJson}
+
+// Header the negotiated version is returned in when /provider/ws is upgraded,
+// and the supported versions when none of the offered ones are
+const provProtoHeader = "X-Provider-Protocol"
+
+// Pick the newest version both sides speak from the comma separated list a
+// provider offers in the protocol query parameter. Providers that offer none
+// speak the relaxed protocol.
+func negotiateProtocol(c *gin.Context) (int, bool) {
+	offer := c.Query("protocol")
+	if offer == "" {
+		return ProvProtoRelaxed, true
+	}
+	best := 0
+	for _, str := range strings.Split(offer, ",") {
+		version, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil || version <= best {
+			continue
+		}
+		for _, supported := range provProtoVersions {
+			if version == supported {
+				best = version
+			}
+		}
+	}
+	return best, best != 0
+}
+
+// Refuse a websocket whose provider speaks no version we do
+func refuseProtocol(c *gin.Context, provider ProviderOb) {
+	supported := []string{}
+	for _, version := range provProtoVersions {
+		supported = append(supported, strconv.Itoa(version))
+	}
+	log.WithFields(log.Fields{
+		"type":    "provider_protocol_unsupported",
+		"user":    provider.User,
+		"offered": c.Query("protocol"),
+	}).Warn("Provider offered no supported protocol version")
+
+	c.Header(provProtoHeader, strings.Join(supported, ","))
+	c.JSON(http.StatusBadRequest, SApiError{
+		Success: false,
+		Err:     "unsupported_protocol",
+	})
+}
+
+// Wire forms of the responses providers send back
+type ProvRes struct {
+	Id    int16  `json:"id"              desc:"Id of the request answered"`
+	Error string `json:"error,omitempty" desc:"Why the request failed; absent on success"`
+}
+
+type ProvPongRes struct {
+	ProvRes
+	Text string `json:"text" desc:"Always pong"`
+}
+
+type ProvWifiIpRes struct {
+	ProvRes
+	Ip  string `json:"ip"  desc:"Wifi IP address of the device"`
+	Mac string `json:"mac" desc:"Wifi MAC address of the device"`
+}
+
+type ProvScreenshotRes struct {
+	ProvRes
+	Data string `json:"data" desc:"Base64 JPEG or PNG image"`
+}
+
+type ProvRefreshRes struct {
+	ProvRes
+	Refresh string `json:"refresh" desc:"Result of the refresh"`
+}
+
+type ProvRestartRes struct {
+	ProvRes
+	Restart string `json:"restart" desc:"Result of the restart"`
+}
+
+// A message type providers handle; Response is nil for those they don't answer
+type ProvMessageSpec struct {
+	Type        string
+	Description string
+	Message     interface{}
+	Response    interface{}
+}
+
+var provMessageSpecs = []ProvMessageSpec{
+	{"ping", "Keepalive sent every 5 seconds", ProvMsg{}, ProvPongRes{}},
+	{"click", "Tap a point", ProvPointMsg{}, ProvRes{}},
+	{"doubleclick", "Double tap a point", ProvPointMsg{}, ProvRes{}},
+	{"mouseDown", "Start touching a point", ProvPointMsg{}, ProvRes{}},
+	{"mouseUp", "Stop touching a point", ProvPointMsg{}, ProvRes{}},
+	{"hardPress", "Force touch a point", ProvPointMsg{}, nil},
+	{"longPress", "Touch and hold a point", ProvLongPressMsg{}, ProvRes{}},
+	{"swipe", "Swipe between two points", ProvSwipeMsg{}, ProvRes{}},
+	{"keys", "Type a batch of keys", ProvKeysMsg{}, ProvRes{}},
+	{"text", "Type text", ProvTestMsg{}, ProvRes{}},
+	{"home", "Press the home button", ProvUdidMsg{}, ProvRes{}},
+	{"taskSwitcher", "Open the task switcher", ProvUdidMsg{}, ProvRes{}},
+	{"shake", "Shake the device", ProvUdidMsg{}, ProvRes{}},
+	{"cc", "Open control center", ProvUdidMsg{}, ProvRes{}},
+	{"assistiveTouch", "Toggle assistive touch", ProvUdidMsg{}, ProvRes{}},
+	{"launch", "Launch an app", ProvAppMsg{}, ProvRes{}},
+	{"kill", "Kill an app", ProvAppMsg{}, ProvRes{}},
+	{"allowApp", "Lift the restriction on an app", ProvAppMsg{}, ProvRes{}},
+	{"restrictApp", "Keep an app from being launched", ProvAppMsg{}, ProvRes{}},
+	{"listRestrictedApps", "List restricted apps; further response fields are passed through as is", ProvUdidMsg{}, ProvRes{}},
+	{"source", "Fetch the UI element tree; further response fields are passed through as is", ProvUdidMsg{}, ProvRes{}},
+	{"screenshot", "Grab one screen image", ProvUdidMsg{}, ProvScreenshotRes{}},
+	{"wifiIp", "Fetch the wifi addresses of the device", ProvUdidMsg{}, ProvWifiIpRes{}},
+	{"refresh", "Refresh the device's state", ProvUdidMsg{}, ProvRefreshRes{}},
+	{"restart", "Restart the device", ProvUdidMsg{}, ProvRestartRes{}},
+	{"launchsafariurl", "Open a URL in Safari", ProvSafariTestMsg{}, ProvRes{}},
+	{"cleanbrowser", "Clear a browser's data", ProvBrowserCleanUpMsg{}, ProvRes{}},
+	{"rotatedevice", "Rotate the device", ProvRotateDeviceTestMsg{}, ProvRes{}},
+	{"initWebrtc", "Start a WebRTC stream; further response fields are passed through to the browser", ProvInitWebrtcMsg{}, ProvRes{}},
+	{"startStream", "Start the image stream of a device", ProvUdidMsg{}, nil},
+	{"stopStream", "Stop the image stream of a device", ProvUdidMsg{}, nil},
+	{"streamQuality", "Cap the frame rate and quality of a running image stream", ProvStreamQualityMsg{}, nil},
+	{"shutdown", "Shut the provider down", ProvMsg{}, nil},
+}
+
+// Messages providers send on their own, with an id of 0
+var provEventSpecs = []ProvMessageSpec{
+	{"capabilities", "Announce the message types the provider and its devices handle", ProvCapabilitiesMsg{}, nil},
+	{"status", "A device appeared, went away, or had a service start or stop", ProvStatusEvent{}, nil},
+	{"orientation", "A device turned", ProvOrientationEvent{}, nil},
+	{"log", "A log line from a device, passed on to whoever is watching it", ProvLogEvent{}, nil},
+	{"alert", "An alert appeared on a device, or was dismissed", ProvAlertEvent{}, nil},
+	{"crash", "An app crashed on a device", ProvCrashEvent{}, nil},
+}
+
+type SProviderMessageType struct {
+	Type        string `json:"type"               example:"click"`
+	From        string `json:"from"               example:"server"`
+	Description string `json:"description"        example:"Tap a point"`
+	Request     string `json:"request"            example:"#/definitions/click"`
+	Response    string `json:"response,omitempty" example:"#/definitions/click.response"`
+}
+
+// JSON Schema of the messages sent over /provider/ws; the document as a whole
+// matches any message that isn't a response
+type SProviderProtocol struct {
+	Schema      string                            `json:"$schema"`
+	Title       string                            `json:"title"`
+	Version     int                               `json:"version"`
+	Versions    []int                             `json:"versions"`
+	Messages    []SProviderMessageType            `json:"messages"`
+	Definitions map[string]map[string]interface{} `json:"definitions"`
+	OneOf       []map[string]string               `json:"oneOf"`
+}
+
+func provProtocolSchema() SProviderProtocol {
+	doc := SProviderProtocol{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		Title:       "ControlFloor provider websocket protocol",
+		Version:     provProtoVersions[len(provProtoVersions)-1],
+		Versions:    provProtoVersions,
+		Messages:    []SProviderMessageType{},
+		Definitions: map[string]map[string]interface{}{},
+		OneOf:       []map[string]string{},
+	}
+	for _, spec := range provMessageSpecs {
+		doc.addSpec(spec, "server")
+	}
+	for _, spec := range provEventSpecs {
+		doc.addSpec(spec, "provider")
+	}
+	return doc
+}
+
+func (doc *SProviderProtocol) addSpec(spec ProvMessageSpec, from string) {
+	msg := jsonSchemaOf(reflect.TypeOf(spec.Message))
+	msg["description"] = spec.Description
+	msg["properties"].(map[string]interface{})["type"] = map[string]interface{}{
+		"const": spec.Type,
+	}
+	doc.Definitions[spec.Type] = msg
+
+	entry := SProviderMessageType{
+		Type:        spec.Type,
+		From:        from,
+		Description: spec.Description,
+		Request:     "#/definitions/" + spec.Type,
+	}
+	if spec.Response != nil {
+		doc.Definitions[spec.Type+".response"] = jsonSchemaOf(reflect.TypeOf(spec.Response))
+		entry.Response = "#/definitions/" + spec.Type + ".response"
+	}
+	doc.Messages = append(doc.Messages, entry)
+	doc.OneOf = append(doc.OneOf, map[string]string{"$ref": entry.Request})
+}
+
+// Describe a message struct as a JSON Schema object; fields come from the
+// json tags, descriptions from desc tags
+func jsonSchemaOf(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	addSchemaFields(t, props, &required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+func jsonSchemaType(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaType(t.Elem())}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func addSchemaFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			addSchemaFields(field.Type, props, required)
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+
+		prop := jsonSchemaType(field.Type)
+		if desc := field.Tag.Get("desc"); desc != "" {
+			prop["description"] = desc
+		}
+		props[tag[0]] = prop
+
+		if len(tag) < 2 || tag[1] != "omitempty" {
+			*required = append(*required, tag[0])
+		}
+	}
+}
+
+// @Description Provider - Websocket protocol schema
+// @Description JSON Schema of every message sent over /provider/ws and of the
+// @Description responses to them. Providers pick a version with the protocol
+// @Description query parameter of /provider/ws.
+// @Router /provider/protocol [GET]
+// @Produce json
+// @Success 200 {object} SProviderProtocol
+func (self *ProviderHandler) showProtocol(c *gin.Context) {
+	c.JSON(http.StatusOK, provProtocolSchema())
+}
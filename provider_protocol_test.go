@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	ws "github.com/gorilla/websocket"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	tests := []struct {
+		offer   string
+		version int
+		ok      bool
+	}{
+		{"", ProvProtoRelaxed, true},
+		{"1", ProvProtoRelaxed, true},
+		{"1,2", ProvProtoJson, true},
+		{"2,1", ProvProtoJson, true},
+		{" 2 ", ProvProtoJson, true},
+		{"3", 0, false},
+		{"3,1", ProvProtoRelaxed, true},
+		{"x,2", ProvProtoJson, true},
+		{"x", 0, false},
+		{",", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.offer, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/provider/ws?protocol="+url.QueryEscape(tt.offer), nil)
+			version, ok := negotiateProtocol(c)
+			if version != tt.version || ok != tt.ok {
+				t.Errorf("got %d %v, want %d %v", version, ok, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProcessRespStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType int
+		text    string
+		kept    bool
+	}{
+		{"event", ws.TextMessage, `{"type":"status","udid":"dev1"}`, true},
+		{"event with surrounding space", ws.TextMessage, " {\"type\":\"status\"}\n", true},
+		{"binary frame", ws.BinaryMessage, `{"type":"status","udid":"dev1"}`, false},
+		{"unquoted keys", ws.TextMessage, `{type:"status"}`, false},
+		{"trailing comma", ws.TextMessage, `{"type":"status",}`, false},
+		{"truncated", ws.TextMessage, `{"type":"sta`, false},
+		{"not an object", ws.TextMessage, `"status"`, false},
+		{"empty", ws.TextMessage, "  ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqTracker := NewReqTracker()
+			reqTracker.protocol = ProvProtoJson
+			root := reqTracker.processResp(tt.msgType, []byte(tt.text))
+			if (root != nil) != tt.kept {
+				t.Errorf("got %v, want kept %v", root != nil, tt.kept)
+			}
+		})
+	}
+}
+
+func TestProvMsgEscaping(t *testing.T) {
+	odd := `a"b\c` + "\né"
+	tests := []struct {
+		name string
+		msg  ProvBase
+		into interface{}
+		got  func(interface{}) string
+	}{
+		{"bundle id", &ProvLaunch{udid: "dev1", bid: odd}, &ProvAppMsg{}, func(m interface{}) string {
+			return m.(*ProvAppMsg).Bid
+		}},
+		{"keys", &ProvKeys{udid: "dev1", keys: odd, prevkeys: odd}, &ProvKeysMsg{}, func(m interface{}) string {
+			return m.(*ProvKeysMsg).Keys
+		}},
+		{"text", &ProvText{udid: "dev1", text: odd}, &ProvTestMsg{}, func(m interface{}) string {
+			return m.(*ProvTestMsg).Text
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.msg.asText(7)
+			if err := json.Unmarshal([]byte(text), tt.into); err != nil {
+				t.Fatalf("%s is not JSON: %s", text, err)
+			}
+			if got := tt.got(tt.into); got != odd {
+				t.Errorf("got %q back, want %q", got, odd)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+	log "github.com/sirupsen/logrus"
+)
+
+// Provider message types that change device state and so are worth replaying
+var recordedActions = map[string]bool{
+	"click":           true,
+	"doubleclick":     true,
+	"mouseDown":       true,
+	"mouseUp":         true,
+	"hardPress":       true,
+	"longPress":       true,
+	"swipe":           true,
+	"home":            true,
+	"taskSwitcher":    true,
+	"shake":           true,
+	"cc":              true,
+	"assistiveTouch":  true,
+	"keys":            true,
+	"text":            true,
+	"launch":          true,
+	"kill":            true,
+	"launchsafariurl": true,
+	"rotatedevice":    true,
+}
+
+// An action as sent, waiting to be matched to the reservation it belongs to
+type RecordedSend struct {
+	user   string
+	udid   string
+	action string
+	params string
+	stamp  time.Time
+}
+
+// Records what users do to the devices they hold and replays it later
+type Recorder struct {
+	sends     chan *RecordedSend
+	replaying map[string]bool
+	lock      *sync.Mutex
+}
+
+var gRecorder *Recorder
+
+func startRecorder() {
+	gRecorder = &Recorder{
+		sends:     make(chan *RecordedSend, 1000),
+		replaying: make(map[string]bool),
+		lock:      &sync.Mutex{},
+	}
+	go func() {
+		for send := range gRecorder.sends {
+			gRecorder.store(send)
+		}
+	}()
+}
+
+// Note an action sent on behalf of a user; it is kept only if the user holds the device
+func (self *Recorder) record(ctx context.Context, req ProvBase) {
+	if self == nil {
+		return
+	}
+	user := auditUser(ctx)
+	if user == "" {
+		return
+	}
+	action, udid, params := provMessageFields(req)
+	if !recordedActions[action] {
+		return
+	}
+	text, _ := json.Marshal(params)
+
+	select {
+	case self.sends <- &RecordedSend{
+		user:   user,
+		udid:   udid,
+		action: action,
+		params: string(text),
+		stamp:  time.Now(),
+	}:
+	default:
+		fmt.Printf("Recording queue full; dropping %s by %s\n", action, user)
+	}
+}
+
+func (self *Recorder) store(send *RecordedSend) {
+	rv := getReservation(send.udid)
+	if rv == nil || rv.User != send.user {
+		return
+	}
+	hist := getReservationHistoryWithRid(rv.Rid)
+	if hist == nil {
+		return
+	}
+	addRecordedAction(&DbRecordedAction{
+		Rid:    rv.Rid,
+		Udid:   send.udid,
+		User:   send.user,
+		Offset: send.stamp.Sub(hist.Start).Milliseconds(),
+		Action: send.action,
+		Params: send.params,
+	})
+}
+
+// Claim a device for a replay; false if one is already running on it
+func (self *Recorder) beginReplay(udid string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.replaying[udid] {
+		return false
+	}
+	self.replaying[udid] = true
+	return true
+}
+
+func (self *Recorder) endReplay(udid string) {
+	self.lock.Lock()
+	delete(self.replaying, udid)
+	self.lock.Unlock()
+}
+
+// Maps coordinates from the recorded device's click space onto the target's
+type ReplayScale struct {
+	fromWidth  int
+	fromHeight int
+	toWidth    int
+	toHeight   int
+}
+
+func NewReplayScale(from *DbDevice, to *DbDevice) *ReplayScale {
+	scale := &ReplayScale{}
+	if from != nil && to != nil {
+		scale.fromWidth, scale.fromHeight = from.ClickWidth, from.ClickHeight
+		scale.toWidth, scale.toHeight = to.ClickWidth, to.ClickHeight
+	}
+	return scale
+}
+
+func (self *ReplayScale) x(val string) int {
+	n, _ := strconv.Atoi(val)
+	if self.fromWidth <= 0 || self.toWidth <= 0 {
+		return n
+	}
+	return n * self.toWidth / self.fromWidth
+}
+
+func (self *ReplayScale) y(val string) int {
+	n, _ := strconv.Atoi(val)
+	if self.fromHeight <= 0 || self.toHeight <= 0 {
+		return n
+	}
+	return n * self.toHeight / self.fromHeight
+}
+
+// Send one recorded action to a device and wait for the result; "" on success
+func replayStep(ctx context.Context, pc *ProviderConnection, udid string, step *DbRecordedAction, scale *ReplayScale) string {
+	params := make(map[string]string)
+	json.Unmarshal([]byte(step.Params), &params)
+
+	done := make(chan uj.JNode, 1)
+	onDone := func(root uj.JNode, _ []byte) {
+		done <- root
+	}
+
+	switch step.Action {
+	case "click":
+		pc.doClick(ctx, udid, scale.x(params["x"]), scale.y(params["y"]), onDone)
+	case "doubleclick":
+		pc.doDoubleclick(ctx, udid, scale.x(params["x"]), scale.y(params["y"]), onDone)
+	case "mouseDown":
+		pc.doMouseDown(ctx, udid, scale.x(params["x"]), scale.y(params["y"]), onDone)
+	case "mouseUp":
+		pc.doMouseUp(ctx, udid, scale.x(params["x"]), scale.y(params["y"]), onDone)
+	case "hardPress":
+		// Fire-and-forget; there is no answer to wait for
+		pc.doHardPress(ctx, udid, scale.x(params["x"]), scale.y(params["y"]))
+		return ""
+	case "longPress":
+		secs, _ := strconv.ParseFloat(params["time"], 64)
+		pc.doLongPress(ctx, udid, scale.x(params["x"]), scale.y(params["y"]), secs, onDone)
+	case "swipe":
+		// The wire format carries the delay in hundredths of a second
+		delay, _ := strconv.Atoi(params["delay"])
+		pc.doSwipe(ctx, udid,
+			scale.x(params["x1"]), scale.y(params["y1"]),
+			scale.x(params["x2"]), scale.y(params["y2"]),
+			float64(delay)/100, onDone)
+	case "home":
+		pc.doHome(ctx, udid, onDone)
+	case "taskSwitcher":
+		pc.doTaskSwitcher(ctx, udid, onDone)
+	case "shake":
+		pc.doShake(ctx, udid, onDone)
+	case "cc":
+		pc.doCC(ctx, udid, onDone)
+	case "assistiveTouch":
+		pc.doAssistiveTouch(ctx, udid, onDone)
+	case "keys":
+		curid, _ := strconv.Atoi(params["curid"])
+		pc.doKeys(ctx, udid, params["keys"], curid, params["prevkeys"], onDone)
+	case "text":
+		pc.doText(ctx, udid, params["text"], onDone)
+	case "launch":
+		pc.doLaunch(ctx, udid, params["bid"], onDone)
+	case "kill":
+		pc.doKill(ctx, udid, params["bid"], onDone)
+	case "launchsafariurl":
+		pc.doOpenSafariUrl(ctx, udid, params["url"], onDone)
+	case "rotatedevice":
+		pc.doRotateDevice(ctx, udid, params["orientation"], onDone)
+	default:
+		return "unknown_action"
+	}
+
+	return resError(<-done)
+}
+
+type SRecordingInfo struct {
+	Rid   string `json:"rid"   example:"XVlBzgbaiC"`
+	Udid  string `json:"udid"  example:"00008100-001338811EE10033"`
+	Start string `json:"start" example:"2021-06-01 10:00:00"`
+	End   string `json:"end"   example:"2021-06-01 10:30:00"`
+	Steps int64  `json:"steps" example:"42"`
+}
+
+type SRecordedStep struct {
+	OffsetMs int64             `json:"offsetMs" example:"1530"`
+	Action   string            `json:"action"   example:"click"`
+	Params   map[string]string `json:"params"`
+}
+
+type SRecording struct {
+	Success bool            `json:"success" example:"true"`
+	Rid     string          `json:"rid"     example:"XVlBzgbaiC"`
+	Udid    string          `json:"udid"    example:"00008100-001338811EE10033"`
+	User    string          `json:"user"    example:"someuser"`
+	Start   string          `json:"start"   example:"2021-06-01 10:00:00"`
+	Steps   []SRecordedStep `json:"steps"`
+}
+
+type SReplayRequest struct {
+	Udid  string  `json:"udid"  binding:"required" example:"00008100-001338811EE10033"`
+	Speed float64 `json:"speed" example:"1"`
+}
+
+type SReplay struct {
+	Success bool    `json:"success" example:"true"`
+	Steps   int     `json:"steps"   example:"42"`
+	Seconds float64 `json:"seconds" example:"95.5"`
+}
+
+func (self *DevHandler) registerRecordingRoutes() {
+	uAuth := self.userAuthGroup
+
+	uAuth.GET("/recording", self.showRecordings)
+	uAuth.GET("/recording/:rid", self.showRecording)
+	uAuth.POST("/recording/:rid/replay", self.NeedPerm(PermControl), self.handleReplay)
+}
+
+// Fetch the reservation a recording belongs to, replying 404 or 403 if the caller can't use it
+func (self *DevHandler) ownRecording(c *gin.Context) *DbReservationHistory {
+	hist := getReservationHistoryWithRid(c.Param("rid"))
+	if hist == nil {
+		apiFail(c, http.StatusNotFound, "unknown_recording")
+		return nil
+	}
+	if hist.User != self.apiUser(c) {
+		apiFail(c, http.StatusForbidden, "not_recording_owner")
+		return nil
+	}
+	return hist
+}
+
+// @Summary Recording - List your recordings
+// @Description Every reservation in which you sent actions to the device, newest first
+// @Router /recording [GET]
+// @Security ApiToken
+// @Produce json
+// @Success 200 {array} SRecordingInfo
+func (self *DevHandler) showRecordings(c *gin.Context) {
+	history, err := getUserReservationHistory(self.apiUser(c))
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := []SRecordingInfo{}
+	for _, hist := range history {
+		steps := countRecordedActions(hist.Rid)
+		if steps == 0 {
+			continue
+		}
+		end := ""
+		if hist.Reason != "" {
+			end = hist.End.Format("2006-01-02 15:04:05")
+		}
+		out = append(out, SRecordingInfo{
+			Rid:   hist.Rid,
+			Udid:  hist.Udid,
+			Start: hist.Start.Format("2006-01-02 15:04:05"),
+			End:   end,
+			Steps: steps,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// @Summary Recording - Get the script of a reservation
+// @Router /recording/{rid} [GET]
+// @Security ApiToken
+// @Param rid path string true "Reservation id"
+// @Produce json
+// @Success 200 {object} SRecording
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) showRecording(c *gin.Context) {
+	hist := self.ownRecording(c)
+	if hist == nil {
+		return
+	}
+
+	actions, err := getRecordedActions(hist.Rid)
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	steps := []SRecordedStep{}
+	for _, action := range actions {
+		params := make(map[string]string)
+		json.Unmarshal([]byte(action.Params), &params)
+		steps = append(steps, SRecordedStep{
+			OffsetMs: action.Offset,
+			Action:   action.Action,
+			Params:   params,
+		})
+	}
+
+	c.JSON(http.StatusOK, SRecording{
+		Success: true,
+		Rid:     hist.Rid,
+		Udid:    hist.Udid,
+		User:    hist.User,
+		Start:   hist.Start.Format("2006-01-02 15:04:05"),
+		Steps:   steps,
+	})
+}
+
+// @Summary Recording - Replay onto a device
+// @Description Replays the recorded actions onto udid, which may be the recorded device or another one.
+// @Description Coordinates are scaled between the two devices' click sizes. Steps keep their recorded
+// @Description spacing divided by speed, starting at once. The replay runs in the background and stops
+// @Description at the first failed step or if someone else reserves the device.
+// @Router /recording/{rid}/replay [POST]
+// @Security ApiToken
+// @Param rid path string true "Reservation id"
+// @Param request body SReplayRequest true "Target device"
+// @Accept json
+// @Produce json
+// @Success 202 {object} SReplay
+// @Failure 400 {object} SApiError
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 503 {object} SApiError
+func (self *DevHandler) handleReplay(c *gin.Context) {
+	hist := self.ownRecording(c)
+	if hist == nil {
+		return
+	}
+	var req SReplayRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	speed := req.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	user := self.apiUser(c)
+	if _, ok := self.apiDevice(c, req.Udid, user); !ok {
+		return
+	}
+
+	steps, err := getRecordedActions(hist.Rid)
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(steps) == 0 {
+		apiFail(c, http.StatusNotFound, "empty_recording")
+		return
+	}
+
+	if !gRecorder.beginReplay(req.Udid) {
+		apiFail(c, http.StatusConflict, "replay_running")
+		return
+	}
+
+	scale := NewReplayScale(getDevice(hist.Udid), getDevice(req.Udid))
+	go self.runReplay(user, req.Udid, steps, scale, speed)
+
+	span := steps[len(steps)-1].Offset - steps[0].Offset
+	c.JSON(http.StatusAccepted, SReplay{
+		Success: true,
+		Steps:   len(steps),
+		Seconds: float64(span) / 1000 / speed,
+	})
+}
+
+func (self *DevHandler) runReplay(user string, udid string, steps []DbRecordedAction, scale *ReplayScale, speed float64) {
+	defer gRecorder.endReplay(udid)
+
+	fields := log.Fields{
+		"type": "replay",
+		"udid": censorUuid(udid),
+		"user": user,
+		"rid":  steps[0].Rid,
+	}
+	base := withAuditUser(context.Background(), user)
+	start := time.Now()
+
+	for i := range steps {
+		step := &steps[i]
+
+		// Time before the first action is idle lead-in; skip it
+		due := time.Duration(float64(step.Offset-steps[0].Offset)/speed) * time.Millisecond
+		if wait := due - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		if rv := getReservation(udid); rv != nil && rv.User != user {
+			log.WithFields(fields).Warn("Replay stopped; device reserved by another user")
+			return
+		}
+		pc := self.devTracker.getProvConn(self.devTracker.getDevProvId(udid))
+		if pc == nil {
+			log.WithFields(fields).Warn("Replay stopped; provider offline")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(base, self.config.providerTimeout)
+		reqErr := replayStep(ctx, pc, udid, step, scale)
+		cancel()
+		if reqErr != "" {
+			fields["step"] = i
+			fields["action"] = step.Action
+			fields["error"] = reqErr
+			log.WithFields(fields).Warn("Replay stopped; step failed")
+			return
+		}
+	}
+
+	log.WithFields(fields).Info("Replay finished")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const regTokenPrefix = "cfr_"
+
+// The password providers register with and whether it comes from config.json,
+// which takes precedence over the one stored in the db
+func regPassword(config *Config) (string, bool) {
+	if config.regPass != "" {
+		return config.regPass, true
+	}
+	return getConf().RegPass, false
+}
+
+// Whether providers may register with the password rather than a token
+func regOpen(config *Config) bool {
+	return config.regOpen && !getConf().RegClosed
+}
+
+func createRegToken(note string, username string, ttl time.Duration) (string, *DbRegToken) {
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	token := regTokenPrefix + hex.EncodeToString(buf)
+
+	now := time.Now()
+	dbToken := &DbRegToken{
+		Note:     note,
+		Username: username,
+		Prefix:   token[:len(regTokenPrefix)+8],
+		Hash:     hashApiToken(token),
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+	addRegToken(dbToken)
+
+	log.WithFields(log.Fields{
+		"type":     "reg_token_add",
+		"id":       dbToken.Id,
+		"note":     note,
+		"username": username,
+		"expires":  formatTokenTime(dbToken.Expires),
+	}).Info("Created registration token")
+
+	return token, dbToken
+}
+
+// Check the credential a registering provider sent, using up a one-time
+// token if that is what it is. Returns why registration is refused, or an
+// empty string if it may go ahead. Registering again replaces the password
+// of an existing provider, so that takes a token issued for its username.
+func checkRegistration(c *gin.Context, config *Config, username string, exists bool) string {
+	if token := c.PostForm("regToken"); token != "" {
+		dbToken := getRegTokenByHash(hashApiToken(token))
+		switch {
+		case dbToken == nil:
+			return "invalid_token"
+		case dbToken.Revoked:
+			return "token_revoked"
+		case time.Now().After(dbToken.Expires):
+			return "token_expired"
+		case dbToken.Username != "" && dbToken.Username != username:
+			return "wrong_username"
+		case exists && dbToken.Username != username:
+			return "username_taken"
+		case dbToken.Used || !useRegToken(dbToken.Id, username):
+			return "token_used"
+		}
+		return ""
+	}
+
+	if !regOpen(config) {
+		return "registration_closed"
+	}
+	pass, _ := regPassword(config)
+	if pass == "" {
+		return "no_password"
+	}
+	if subtle.ConstantTimeCompare([]byte(c.PostForm("regPass")), []byte(pass)) != 1 {
+		return "bad_password"
+	}
+	if exists {
+		return "username_taken"
+	}
+	return ""
+}
+
+func printRegToken(token DbRegToken) {
+	state := "unused"
+	if token.Revoked {
+		state = "revoked"
+	} else if token.Used {
+		state = fmt.Sprintf("used by %s at %s", token.UsedBy, formatTokenTime(token.UsedAt))
+	} else if time.Now().After(token.Expires) {
+		state = "expired"
+	}
+	fmt.Printf("Id: %d\nNote: %s\nUsername: %s\nToken: %s...\nExpires: %s\nState: %s\n\n",
+		token.Id, token.Note, token.Username, token.Prefix, formatTokenTime(token.Expires), state)
+}
+
+type SRegTokenRow struct {
+	Id       int64
+	Note     string
+	Username string
+	Prefix   string
+	Created  string
+	Expires  string
+	Expired  bool
+	Used     bool
+	UsedBy   string
+	UsedAt   string
+	Revoked  bool
+}
+
+func (self *AdminHandler) showRegistrationPage(c *gin.Context, newToken string) {
+	tokens, err := getRegTokens()
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	rows := []SRegTokenRow{}
+	for _, token := range tokens {
+		rows = append(rows, SRegTokenRow{
+			Id:       token.Id,
+			Note:     token.Note,
+			Username: token.Username,
+			Prefix:   token.Prefix,
+			Created:  formatTokenTime(token.Created),
+			Expires:  formatTokenTime(token.Expires),
+			Expired:  now.After(token.Expires),
+			Used:     token.Used,
+			UsedBy:   token.UsedBy,
+			UsedAt:   formatTokenTime(token.UsedAt),
+			Revoked:  token.Revoked,
+		})
+	}
+
+	pass, passFromConfig := regPassword(self.config)
+
+	c.HTML(http.StatusOK, "adminRegistration", gin.H{
+		"tokens":         rows,
+		"newToken":       newToken,
+		"hasPass":        pass != "",
+		"passFromConfig": passFromConfig,
+		"open":           regOpen(self.config),
+		"closedByConfig": !self.config.regOpen,
+		"tokenTtl":       self.config.regTokenTtl.String(),
+		"deviceVideo":    self.config.text.deviceVideo,
+	})
+}
+
+// @Summary Admin - Provider registration settings and tokens
+// @Router /admin/registration [GET]
+func (self *AdminHandler) showRegistration(c *gin.Context) {
+	self.showRegistrationPage(c, "")
+}
+
+// @Summary Admin - Set provider registration password
+// @Router /admin/registration/password [POST]
+// @Param password formData string true "New registration password"
+func (self *AdminHandler) handleRegPass(c *gin.Context) {
+	if _, fromConfig := regPassword(self.config); fromConfig {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "the registration password is set in config.json",
+		})
+		return
+	}
+
+	pass := c.PostForm("password")
+	if pass == "" {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "password is required",
+		})
+		return
+	}
+	setRegPass(pass)
+
+	log.WithFields(log.Fields{
+		"type": "reg_pass_set",
+	}).Info("Changed registration password")
+
+	c.Redirect(http.StatusFound, "/admin/registration")
+}
+
+// @Summary Admin - Open or close password registration
+// @Router /admin/registration/open [POST]
+// @Param open formData bool true "Whether providers may register with the password"
+func (self *AdminHandler) handleRegOpen(c *gin.Context) {
+	open, _ := strconv.ParseBool(c.PostForm("open"))
+	setRegClosed(!open)
+
+	log.WithFields(log.Fields{
+		"type": "reg_open",
+		"open": open,
+	}).Info("Changed registration status")
+
+	c.Redirect(http.StatusFound, "/admin/registration")
+}
+
+// @Summary Admin - Create registration token
+// @Router /admin/registration/tokens/create [POST]
+// @Param note formData string false "Which provider host the token is for"
+// @Param username formData string false "Only allow registering this provider username"
+// @Param ttl formData string false "Lifetime, such as 24h"
+func (self *AdminHandler) handleRegTokenCreate(c *gin.Context) {
+	ttl := self.config.regTokenTtl
+	if str := c.PostForm("ttl"); str != "" {
+		var err error
+		ttl, err = time.ParseDuration(str)
+		if err != nil || ttl <= 0 {
+			c.HTML(http.StatusOK, "error", gin.H{
+				"text": "invalid lifetime",
+			})
+			return
+		}
+	}
+
+	token, _ := createRegToken(c.PostForm("note"), c.PostForm("username"), ttl)
+	self.showRegistrationPage(c, token)
+}
+
+// @Summary Admin - Revoke registration token
+// @Router /admin/registration/tokens/revoke [POST]
+// @Param id formData int true "Token id"
+func (self *AdminHandler) handleRegTokenRevoke(c *gin.Context) {
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": "invalid token id",
+		})
+		return
+	}
+
+	if revokeRegToken(id) {
+		log.WithFields(log.Fields{
+			"type": "reg_token_revoke",
+			"id":   id,
+		}).Info("Revoked registration token")
+	}
+
+	c.Redirect(http.StatusFound, "/admin/registration")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A context for a registration form posting the given fields
+func regContext(form url.Values) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/provider/register", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c
+}
+
+func TestCheckRegistration(t *testing.T) {
+	useTestDb(t, new(DbConf), new(DbRegToken))
+	addConf(gDb, "stored")
+	config := &Config{regOpen: true}
+
+	issue := func(username string, ttl time.Duration) string {
+		token, _ := createRegToken("test", username, ttl)
+		return token
+	}
+	used := issue("", time.Hour)
+	if err := checkRegistration(regContext(url.Values{"regToken": {used}}), config, "first", false); err != "" {
+		t.Fatalf("first use of a token refused with %s", err)
+	}
+	revoked, dbToken := createRegToken("test", "", time.Hour)
+	revokeRegToken(dbToken.Id)
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		username string
+		exists   bool
+		err      string
+	}{
+		{"password", url.Values{"regPass": {"stored"}}, "new", false, ""},
+		{"wrong password", url.Values{"regPass": {"Stored"}}, "new", false, "bad_password"},
+		{"no password sent", url.Values{}, "new", false, "bad_password"},
+		{"token", url.Values{"regToken": {issue("", time.Hour)}}, "new", false, ""},
+		{"token bound to the username", url.Values{"regToken": {issue("new", time.Hour)}}, "new", false, ""},
+		{"token bound to another username", url.Values{"regToken": {issue("other", time.Hour)}}, "new", false, "wrong_username"},
+		{"unknown token", url.Values{"regToken": {regTokenPrefix + "00"}}, "new", false, "invalid_token"},
+		{"revoked token", url.Values{"regToken": {revoked}}, "new", false, "token_revoked"},
+		{"expired token", url.Values{"regToken": {issue("", -time.Second)}}, "new", false, "token_expired"},
+		{"used token", url.Values{"regToken": {used}}, "new", false, "token_used"},
+		{"existing username with the password", url.Values{"regPass": {"stored"}}, "old", true, "username_taken"},
+		{"existing username with an unbound token", url.Values{"regToken": {issue("", time.Hour)}}, "old", true, "username_taken"},
+		{"existing username with a token bound to it", url.Values{"regToken": {issue("old", time.Hour)}}, "old", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRegistration(regContext(tt.form), config, tt.username, tt.exists); err != tt.err {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckRegistrationClosed(t *testing.T) {
+	useTestDb(t, new(DbConf), new(DbRegToken))
+	addConf(gDb, "stored")
+	form := url.Values{"regPass": {"stored"}}
+
+	if err := checkRegistration(regContext(form), &Config{}, "new", false); err != "registration_closed" {
+		t.Errorf("closed in config.json: got %q, want registration_closed", err)
+	}
+
+	config := &Config{regOpen: true}
+	setRegClosed(true)
+	if err := checkRegistration(regContext(form), config, "new", false); err != "registration_closed" {
+		t.Errorf("closed by an admin: got %q, want registration_closed", err)
+	}
+	// Tokens still work while registration with the password is closed
+	token, _ := createRegToken("test", "", time.Hour)
+	if err := checkRegistration(regContext(url.Values{"regToken": {token}}), config, "new", false); err != "" {
+		t.Errorf("token while closed: got %q, want none", err)
+	}
+	setRegClosed(false)
+
+	// An empty password registers nobody, even when one is sent
+	gDb.Exec("UPDATE conf SET reg_pass = ''")
+	if err := checkRegistration(regContext(url.Values{"regPass": {""}}), config, "new", false); err != "no_password" {
+		t.Errorf("empty password: got %q, want no_password", err)
+	}
+	config.regPass = "configured"
+	if err := checkRegistration(regContext(url.Values{"regPass": {"configured"}}), config, "new", false); err != "" {
+		t.Errorf("password from config.json: got %q, want none", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	StreamQualityNormal = "normal"
+	StreamQualityLow    = "low"
+)
+
+// How often the relay compares viewers against the incoming frame rate
+const relayCheckInterval = 2 * time.Second
+
+// Viewers below this frame rate also get lower quality frames
+const relayLowQualityFps = 5
+
+// Watches how fast frames of one device come in against how fast its
+// viewers take them, and asks the provider for fewer or smaller frames
+// while every viewer falls behind
+type StreamRelay struct {
+	udid       string
+	devTracker *DevTracker
+	ingested   int64
+	restarted  int32
+	maxFps     int
+	quality    string
+}
+
+func NewStreamRelay(udid string, devTracker *DevTracker) *StreamRelay {
+	return &StreamRelay{
+		udid:       udid,
+		devTracker: devTracker,
+		quality:    StreamQualityNormal,
+	}
+}
+
+// Note that the provider sent the stream anew, which starts without the
+// limits asked for; safe to call from the ingest loop
+func (self *StreamRelay) reset() {
+	atomic.StoreInt32(&self.restarted, 1)
+}
+
+// Count a frame from the provider; safe to call from the ingest loop
+func (self *StreamRelay) ingest() {
+	atomic.AddInt64(&self.ingested, 1)
+}
+
+func (self *StreamRelay) run(devTracker *DevTracker, owner *VidViewer) {
+	ticker := time.NewTicker(relayCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-owner.done:
+			return
+		case <-ticker.C:
+			self.adapt(devTracker.fastestViewerFps(self.udid, owner))
+		}
+	}
+}
+
+func (self *StreamRelay) adapt(bestFps float64) {
+	if atomic.SwapInt32(&self.restarted, 0) == 1 {
+		self.maxFps, self.quality = 0, StreamQualityNormal
+	}
+	incoming := float64(atomic.SwapInt64(&self.ingested, 0)) / relayCheckInterval.Seconds()
+
+	maxFps, quality := self.maxFps, self.quality
+	if bestFps > 0 && bestFps < incoming*viewerPaceShare {
+		maxFps = int(bestFps)
+		if maxFps < 1 {
+			maxFps = 1
+		}
+		quality = StreamQualityNormal
+		if bestFps < relayLowQualityFps {
+			quality = StreamQualityLow
+		}
+	} else if self.maxFps > 0 && (bestFps == 0 || bestFps >= float64(self.maxFps)*1.5) {
+		maxFps, quality = 0, StreamQualityNormal
+	}
+	if maxFps == self.maxFps && quality == self.quality {
+		return
+	}
+	self.maxFps, self.quality = maxFps, quality
+
+	log.WithFields(log.Fields{
+		"type":     "stream_quality",
+		"udid":     censorUuid(self.udid),
+		"incoming": incoming,
+		"viewers":  bestFps,
+		"maxFps":   maxFps,
+		"quality":  quality,
+	}).Info("Adjusting provider stream to viewers")
+
+	// The provider may be reconnecting; its new stream starts unlimited anyway
+	if provConn := self.devTracker.devProvConn(self.udid); provConn != nil {
+		provConn.setStreamQuality(self.udid, maxFps, quality)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+    "bytes"
+    "context"
+    "encoding/json"
     "fmt"
     mrand "math/rand"
     "strings"
@@ -9,35 +12,97 @@ import (
     uj "github.com/nanoscopic/ujsonin/v2/mod"
 )
 
+// Error codes handed to a request's resHandler when the provider never answered it
+const (
+    ReqErrTimeout      = "timeout"
+    ReqErrCancelled    = "cancelled"
+    ReqErrProviderGone = "provider_disconnected"
+)
+
+type PendingReq struct {
+    req   ProvBase
+    done  chan bool
+    audit *AuditRecord
+}
+
 type ReqTracker struct {
-    reqMap map[int16] ProvBase
+    reqMap map[int16] *PendingReq
     lock *sync.Mutex
     conn *ws.Conn
+    protocol int
 }
 
 func NewReqTracker() (*ReqTracker) {
     self := &ReqTracker{
-        reqMap: make( map[int16] ProvBase ),
+        reqMap: make( map[int16] *PendingReq ),
         lock: &sync.Mutex{},
+        protocol: ProvProtoRelaxed,
     }
     
     return self
 }
 
-func (self *ReqTracker) sendReq( req ProvBase ) (error,string) {
+// Build the response passed to a resHandler in place of a real provider response
+func errorRes( id int16, code string ) (uj.JNode, []byte) {
+    raw := []byte( provMsgText( ProvRes{ Id: id, Error: code } ) )
+    root, _ := uj.Parse( raw )
+    return root, raw
+}
+
+// Fetch the error code of a response; empty if the provider answered normally
+func resError( root uj.JNode ) string {
+    if root == nil {
+        return ReqErrProviderGone
+    }
+    errNode := root.Get("error")
+    if errNode == nil {
+        return ""
+    }
+    return errNode.String()
+}
+
+// Map an expired context onto the matching request error code
+func ctxError( ctx context.Context ) string {
+    if ctx.Err() == context.Canceled {
+        return ReqErrCancelled
+    }
+    return ReqErrTimeout
+}
+
+// Fail a request that never made it to the provider
+func failUnsent( req ProvBase, code string ) {
+    if !req.needsResponse() {
+        return
+    }
+    resHandler := req.resHandler()
+    if resHandler != nil {
+        root, raw := errorRes( 0, code )
+        resHandler( root, raw )
+    }
+}
+
+func (self *ReqTracker) sendReq( ctx context.Context, req ProvBase ) (error,string) {
     var reqText string
+    var id int16
+    rec := gAudit.begin( ctx, req )
     if req.needsResponse() {
-        var id int16
+        pending := &PendingReq{
+            req: req,
+            done: make( chan bool ),
+            audit: rec,
+        }
+        
         maxi := ^uint16(0) / 2
+        self.lock.Lock()
         for {
             id = int16( mrand.Int31n( int32(maxi-2) ) ) + 1
             _, exists := self.reqMap[ id ]
             if !exists { break }
         }
-        
-        self.lock.Lock()
-        self.reqMap[ id ] = req
+        self.reqMap[ id ] = pending
         self.lock.Unlock()
+        
+        go self.watchReq( ctx, id, pending )
         reqText = req.asText( id )
     } else {
         reqText = req.asText( 0 )
@@ -49,53 +114,131 @@ func (self *ReqTracker) sendReq( req ProvBase ) (error,string) {
     // send the request
     err := self.conn.WriteMessage( ws.TextMessage, []byte(reqText) )
     if err != nil {
+        if id != 0 {
+            self.failReq( id, ReqErrProviderGone )
+        } else {
+            gAudit.finish( rec, ReqErrProviderGone )
+        }
         return err,reqText
     }
+    if id == 0 {
+        gAudit.finish( rec, "sent" )
+    }
+    gRecorder.record( ctx, req )
     return err, ""
 }
 
+// Fail the request once its context expires unless the provider answers first
+func (self *ReqTracker) watchReq( ctx context.Context, id int16, pending *PendingReq ) {
+    select {
+        case <-pending.done:
+        case <-ctx.Done():
+            self.failReq( id, ctxError( ctx ) )
+    }
+}
+
+// Remove a request from the map; nil if it was already answered or failed
+func (self *ReqTracker) takeReq( id int16 ) *PendingReq {
+    self.lock.Lock()
+    pending, exists := self.reqMap[ id ]
+    if exists {
+        delete( self.reqMap, id )
+    }
+    self.lock.Unlock()
+    
+    if !exists {
+        return nil
+    }
+    close( pending.done )
+    return pending
+}
+
+func (self *ReqTracker) failReq( id int16, code string ) {
+    pending := self.takeReq( id )
+    if pending == nil {
+        return
+    }
+    fmt.Printf( "Request %d failed: %s\n", id, code )
+    gAudit.finish( pending.audit, code )
+    
+    resHandler := pending.req.resHandler()
+    if resHandler != nil {
+        root, raw := errorRes( id, code )
+        resHandler( root, raw )
+    }
+}
+
+// Fail every request still waiting on the provider, e.g. once its websocket is gone
+func (self *ReqTracker) failAll( code string ) {
+    self.lock.Lock()
+    ids := make( []int16, 0, len( self.reqMap ) )
+    for id := range self.reqMap {
+        ids = append( ids, id )
+    }
+    self.lock.Unlock()
+    
+    for _, id := range ids {
+        self.failReq( id, code )
+    }
+}
+
 func (self *ReqTracker) processResp( msgType int, reqText []byte ) uj.JNode {
     if !strings.Contains( string(reqText), "pong" ) {
         fmt.Printf( "received %s\n", string(reqText) )
     }
     
-    if len( reqText ) == 0 {
-        return nil
-    }
-    c1 := string( []byte{ reqText[0] } )
-    if c1 != "{" {
+    text := bytes.TrimSpace( reqText )
+    if len( text ) == 0 {
         return nil
     }
-    last1 := string( []byte{ reqText[ len( reqText ) - 1 ] } )
-    last2 := string( []byte{ reqText[ len( reqText ) - 2 ] } )
-    if last1 != "}" && last2 != "}" {
-        fmt.Printf("response not json; last1=%s\n", last1)
+    
+    var head ProvRes
+    if self.protocol >= ProvProtoJson {
+        // Strict providers only send JSON text; anything else is dropped rather than guessed at
+        if msgType != ws.TextMessage {
+            fmt.Printf("Dropping binary message from provider\n")
+            return nil
+        }
+        err := json.Unmarshal( text, &head )
+        if err != nil {
+            fmt.Printf("Dropping malformed message from provider: %s\n", err )
+            return nil
+        }
+    } else if text[0] != '{' {
+        fmt.Printf("response not json\n")
         return nil
     }
     
-    root, _, err := uj.ParseFull( reqText )
+    root, _, err := uj.ParseFull( text )
     if err != nil {
         fmt.Printf("Could not parse response as json\n")
         return nil
     }
     
-    id := root.Get("id").Int()
+    if self.protocol < ProvProtoJson {
+        if idNode := root.Get("id"); idNode != nil {
+            head.Id = int16( idNode.Int() )
+        }
+    }
+    id := head.Id
     
     if id == 0 {
         return root
     }
     
-    req := self.reqMap[ int16(id) ]
-    resHandler := req.resHandler()
-    if resHandler != nil {
-        resHandler( root, reqText )
-    }
-    
-    self.lock.Lock()
-    delete( self.reqMap, int16(id) )
-    self.lock.Unlock()
     // deserialize the reqText to get the id
     // fetch the original request from the reqMap
     // respond to the original request if needed
+    pending := self.takeReq( id )
+    if pending == nil {
+        fmt.Printf( "Response to request %d arrived after it was failed\n", id )
+        return nil
+    }
+    gAudit.finish( pending.audit, auditResult( root ) )
+    
+    resHandler := pending.req.resHandler()
+    if resHandler != nil {
+        resHandler( root, reqText )
+    }
     return nil
 }
\ No newline at end of file
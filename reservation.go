@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type SReserveRequest struct {
+	Udid        string `json:"udid"        example:"00008100-001338811EE10033"`
+	ProductType string `json:"productType" example:"iPhone13,2"`
+	IosVersion  string `json:"iosVersion"  example:"14.2"`
+	Provider    int64  `json:"provider"    example:"1"`
+}
+
+type SReservation struct {
+	Success bool      `json:"success" example:"true"`
+	Rid     string    `json:"rid"     example:"XVlBzgbaiC"`
+	Udid    string    `json:"udid"    example:"00008100-001338811EE10033"`
+	User    string    `json:"user"    example:"someuser"`
+	Expires time.Time `json:"expires" example:"2021-06-01T12:10:00Z"`
+}
+
+func (self *DevHandler) registerReservationRoutes() {
+	uAuth := self.userAuthGroup
+
+	control := self.NeedPerm(PermControl)
+
+	uAuth.POST("/reservation", control, self.handleReserve)
+	uAuth.DELETE("/reservation/:rid", control, self.handleRelease)
+	uAuth.POST("/reservation/:rid/renew", control, self.handleRenew)
+}
+
+func (self *DevHandler) reservationRes(rv *DbReservation, start time.Time) SReservation {
+	return SReservation{
+		Success: true,
+		Rid:     rv.Rid,
+		Udid:    rv.Udid,
+		User:    rv.User,
+		Expires: start.Add(self.config.reservationLease),
+	}
+}
+
+// @Summary Reservation - Reserve a device
+// @Description Reserves the given udid, or the first free online device matching the other fields when udid is empty.
+// @Description Reserving a device you already hold renews it. The reservation expires unless renewed within the lease.
+// @Router /reservation [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SReserveRequest true "Device or criteria"
+// @Success 200 {object} SReservation
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 503 {object} SApiError
+func (self *DevHandler) handleReserve(c *gin.Context) {
+	var req SReserveRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	user := self.apiUser(c)
+
+	if req.Udid != "" {
+		if _, ok := self.apiDevice(c, req.Udid, user); !ok {
+			return
+		}
+
+		rid := RandStringBytes(10)
+		if addReservation(req.Udid, user, rid) {
+			rv := getReservationWithRid(rid)
+			c.JSON(http.StatusOK, self.reservationRes(rv, rv.Start))
+			return
+		}
+
+		rv := getReservation(req.Udid)
+		if rv == nil {
+			apiFail(c, http.StatusInternalServerError, "reserve_failed")
+			return
+		}
+		if rv.User != user {
+			c.JSON(http.StatusConflict, SApiError{
+				Success:    false,
+				Err:        "reserved",
+				ReservedBy: rv.User,
+			})
+			return
+		}
+		start := renewReservation(rv.Udid, rv.Rid)
+		c.JSON(http.StatusOK, self.reservationRes(rv, start))
+		return
+	}
+
+	devs, err := filterDevices(self.devTracker, &DevFilter{
+		provider:    req.Provider,
+		productType: req.ProductType,
+		iosVersion:  req.IosVersion,
+		freeOnly:    true,
+	})
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Try devices on degraded or reconnecting providers only once the healthy ones are taken
+	sort.SliceStable(devs, func(i, j int) bool {
+		return !provUnreliable(devs[i].ProviderState) && provUnreliable(devs[j].ProviderState)
+	})
+
+	// Another client may take a device between listing and inserting; move on to the next one
+	for _, dev := range devs {
+		rid := RandStringBytes(10)
+		if addReservation(dev.Udid, user, rid) {
+			rv := getReservationWithRid(rid)
+			c.JSON(http.StatusOK, self.reservationRes(rv, rv.Start))
+			return
+		}
+	}
+
+	apiFail(c, http.StatusNotFound, "no_free_device")
+}
+
+// Fetch the reservation a request names, replying 404 or 403 if the caller can't act on it
+func (self *DevHandler) ownReservation(c *gin.Context) *DbReservation {
+	rv := getReservationWithRid(c.Param("rid"))
+	if rv == nil {
+		apiFail(c, http.StatusNotFound, "unknown_reservation")
+		return nil
+	}
+	if rv.User != self.apiUser(c) {
+		apiFail(c, http.StatusForbidden, "not_reservation_owner")
+		return nil
+	}
+	return rv
+}
+
+// @Summary Reservation - Release a device
+// @Router /reservation/{rid} [DELETE]
+// @Security ApiToken
+// @Param rid path string true "Reservation id"
+// @Produce json
+// @Success 200 {object} SApiSuccess
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleRelease(c *gin.Context) {
+	rv := self.ownReservation(c)
+	if rv == nil {
+		return
+	}
+
+	deleteReservationWithRid(rv.Udid, rv.Rid, ReserveEndReleased)
+	self.devTracker.waitlist.handOver(rv.Udid)
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+// @Summary Reservation - Renew lease
+// @Router /reservation/{rid}/renew [POST]
+// @Security ApiToken
+// @Param rid path string true "Reservation id"
+// @Produce json
+// @Success 200 {object} SReservation
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleRenew(c *gin.Context) {
+	rv := self.ownReservation(c)
+	if rv == nil {
+		return
+	}
+
+	start := renewReservation(rv.Udid, rv.Rid)
+	c.JSON(http.StatusOK, self.reservationRes(rv, start))
+}
+
+// Periodically drop reservations whose lease has run out. Reservations
+// backing an open video stream are left alone; they end with the stream.
+// A lease of 0 disables expiry.
+func startReservationReaper(devTracker *DevTracker, lease time.Duration) {
+	if lease <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(lease / 4)
+		for range ticker.C {
+			reapReservations(devTracker, lease)
+		}
+	}()
+}
+
+func reapReservations(devTracker *DevTracker, lease time.Duration) {
+	rs, err := getReservations()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"type":  "reserve_reap",
+			"error": err,
+		}).Error("Could not fetch reservations")
+		return
+	}
+
+	for udid, rv := range rs {
+		if time.Since(rv.Start) < lease {
+			continue
+		}
+		if devTracker.hasVidStream(udid, rv.Rid) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"type": "reserve_expire",
+			"udid": censorUuid(udid),
+			"user": rv.User,
+			"rid":  rv.Rid,
+		}).Info("Reservation lease expired")
+
+		deleteReservationWithRid(udid, rv.Rid, ReserveEndExpired)
+		devTracker.waitlist.handOver(udid)
+	}
+
+	// Handed-over reservations have expired by now too, so nobody needs these waiters
+	purgeGrantedWaiters(time.Now().Add(-lease))
+}
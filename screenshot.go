@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+)
+
+const screenshotJpegQuality = 90
+
+func (self *DevHandler) registerScreenshotRoutes() {
+	uAuth := self.userAuthGroup
+
+	uAuth.GET("/device/screenshot", self.handleScreenshot)
+}
+
+// Keep the newest frame relayed for a device, to serve as a screenshot
+func (self *DevTracker) setLastFrame(udid string, frame []byte) {
+	self.lock.Lock()
+	self.lastFrames[udid] = frame
+	self.lock.Unlock()
+}
+
+// The newest relayed frame of a device; nil unless its video is streaming
+func (self *DevTracker) getLastFrame(udid string) []byte {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, streaming := self.vidConns[udid]; !streaming {
+		return nil
+	}
+	return self.lastFrames[udid]
+}
+
+// Quarter turns clockwise that bring a frame upright for an orientation.
+// Frames come in the device's native portrait layout.
+func orientationTurns(orientation string) int {
+	switch orientation {
+	case "landscapeLeft":
+		return 1
+	case "portraitUpsideDown":
+		return 2
+	case "landscapeRight":
+		return 3
+	}
+	return 0
+}
+
+// Rotate by quarter turns clockwise; negative turns go anticlockwise
+func rotateImage(src image.Image, turns int) image.Image {
+	turns = (turns%4 + 4) % 4
+	if turns == 0 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if turns%2 == 1 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch turns {
+			case 1:
+				dx, dy = h-1-y, x
+			case 2:
+				dx, dy = w-1-x, h-1-y
+			case 3:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// Shrink by averaging the source pixels under each destination pixel
+func scaleImage(src image.Image, scale float64) image.Image {
+	b := src.Bounds()
+	dw := int(float64(b.Dx()) * scale)
+	dh := int(float64(b.Dy()) * scale)
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0 := b.Min.Y + dy*b.Dy()/dh
+		sy1 := b.Min.Y + (dy+1)*b.Dy()/dh
+		// Growing, or a source with nothing in it; take the nearest pixel
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for dx := 0; dx < dw; dx++ {
+			sx0 := b.Min.X + dx*b.Dx()/dw
+			sx1 := b.Min.X + (dx+1)*b.Dx()/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// Ask the provider for a screenshot; replies with the error status and
+// returns nil if there is none
+func (self *DevHandler) provScreenshot(c *gin.Context, pc *ProviderConnection, udid string) []byte {
+	root := self.apiRoundTrip(c, func(ctx context.Context, onRes func(uj.JNode, []byte)) {
+		pc.doScreenshot(ctx, udid, onRes)
+	})
+	if root == nil {
+		return nil
+	}
+	dataNode := root.Get("data")
+	if dataNode == nil {
+		apiFail(c, http.StatusBadGateway, "no_image")
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(dataNode.String())
+	if err != nil {
+		apiFail(c, http.StatusBadGateway, "bad_image")
+		return nil
+	}
+	return data
+}
+
+// @Summary Device - Screenshot
+// @Description The newest frame of the device's video stream, or a fresh one from the provider when nothing is streaming
+// @Router /device/screenshot [GET]
+// @Param udid query string true "Device UDID"
+// @Param format query string false "jpeg (default) or png"
+// @Param scale query number false "Shrink factor, above 0 and up to 1"
+// @Param rotate query bool false "Turn the image upright for the device's orientation"
+// @Param fresh query bool false "Always ask the provider instead of using the streamed frame"
+// @Produce image/jpeg
+// @Produce image/png
+// @Success 200 {file} binary
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+// @Failure 501 {object} SApiError
+// @Failure 502 {object} SApiError
+// @Failure 503 {object} SApiError
+// @Failure 504 {object} SApiError
+func (self *DevHandler) handleScreenshot(c *gin.Context) {
+	udid := c.Query("udid")
+
+	format := c.DefaultQuery("format", "jpeg")
+	if format != "jpeg" && format != "png" {
+		apiFail(c, http.StatusBadRequest, "bad_format")
+		return
+	}
+	scale := 1.0
+	if str := c.Query("scale"); str != "" {
+		var err error
+		scale, err = strconv.ParseFloat(str, 64)
+		if err != nil || scale <= 0 || scale > 1 {
+			apiFail(c, http.StatusBadRequest, "bad_scale")
+			return
+		}
+	}
+	rotate, _ := strconv.ParseBool(c.Query("rotate"))
+	fresh, _ := strconv.ParseBool(c.Query("fresh"))
+
+	pc, ok := self.apiDevice(c, udid, self.apiUser(c))
+	if !ok {
+		return
+	}
+	turns := 0
+	if rotate {
+		turns = orientationTurns(self.devTracker.devOrientation(udid))
+	}
+
+	var data []byte
+	if !fresh {
+		data = self.devTracker.getLastFrame(udid)
+	}
+	if data == nil {
+		data = self.provScreenshot(c, pc, udid)
+		if data == nil {
+			return
+		}
+	}
+
+	// Pass the image through untouched when nothing needs changing
+	srcFormat := "jpeg"
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		srcFormat = "png"
+	}
+	if srcFormat == format && scale == 1 && turns == 0 {
+		c.Data(http.StatusOK, "image/"+format, data)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		apiFail(c, http.StatusBadGateway, "bad_image")
+		return
+	}
+	img = rotateImage(img, turns)
+	if scale != 1 {
+		img = scaleImage(img, scale)
+	}
+
+	var out bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&out, img)
+	} else {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: screenshotJpegQuality})
+	}
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, "encode_failed")
+		return
+	}
+	c.Data(http.StatusOK, "image/"+format, out.Bytes())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// An opaque image whose red channel is the pixel index, numbering across
+// then down
+func rampImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(10 * (y*w + x)), A: 255})
+		}
+	}
+	return img
+}
+
+func redAt(img image.Image, x, y int) uint8 {
+	r, _, _, _ := img.At(x, y).RGBA()
+	return uint8(r >> 8)
+}
+
+func TestRotateImage(t *testing.T) {
+	// The source is 3x2; its top left pixel is red 0 and bottom right red 50
+	tests := []struct {
+		name     string
+		turns    int
+		w, h     int
+		tlX, tlY int
+		brX, brY int
+	}{
+		{"none", 0, 3, 2, 0, 0, 2, 1},
+		{"quarter", 1, 2, 3, 1, 0, 0, 2},
+		{"half", 2, 3, 2, 2, 1, 0, 0},
+		{"three quarters", 3, 2, 3, 0, 2, 1, 0},
+		{"full", 4, 3, 2, 0, 0, 2, 1},
+		{"past full", 5, 2, 3, 1, 0, 0, 2},
+		{"anticlockwise quarter", -1, 2, 3, 0, 2, 1, 0},
+		{"anticlockwise half", -2, 3, 2, 2, 1, 0, 0},
+		{"anticlockwise past full", -5, 2, 3, 0, 2, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := rotateImage(rampImage(3, 2), tt.turns)
+			b := out.Bounds()
+			if b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Fatalf("got %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+			if got := redAt(out, tt.tlX, tt.tlY); got != 0 {
+				t.Errorf("top left pixel not at %d,%d; found red %d", tt.tlX, tt.tlY, got)
+			}
+			if got := redAt(out, tt.brX, tt.brY); got != 50 {
+				t.Errorf("bottom right pixel not at %d,%d; found red %d", tt.brX, tt.brY, got)
+			}
+		})
+	}
+}
+
+func TestRotateImageOffsetBounds(t *testing.T) {
+	src := rampImage(4, 4).SubImage(image.Rect(1, 1, 4, 3))
+	out := rotateImage(src, 1)
+	if b := out.Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+		t.Fatalf("got %dx%d, want 2x3", b.Dx(), b.Dy())
+	}
+	// Source pixel 1,1 is index 5; a quarter turn puts the top left at the top right
+	if got := redAt(out, 1, 0); got != 50 {
+		t.Errorf("got red %d at the top right, want 50", got)
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		w, h  int
+		scale float64
+		wantW int
+		wantH int
+		red   uint8 // of the top left pixel
+	}{
+		{"unchanged", 3, 2, 1, 3, 2, 0},
+		{"half", 2, 2, 0.5, 1, 1, 15},
+		{"half of odd size", 3, 3, 0.5, 1, 1, 40},
+		{"near zero", 3, 2, 0.001, 1, 1, 25},
+		{"zero", 3, 2, 0, 1, 1, 25},
+		{"growing", 2, 1, 2, 4, 2, 0},
+		{"empty source", 0, 0, 1, 1, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := scaleImage(rampImage(tt.w, tt.h), tt.scale)
+			b := out.Bounds()
+			if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Fatalf("got %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+			if got := redAt(out, 0, 0); got != tt.red {
+				t.Errorf("got red %d, want %d", got, tt.red)
+			}
+		})
+	}
+}
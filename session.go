@@ -8,6 +8,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
 func init() {
@@ -22,19 +23,60 @@ func (self *cfSessionManager) GetSCSSessionManager() *scs.SessionManager {
 	return self.session
 }
 
-func NewSessionManager(r *gin.Engine) *cfSessionManager {
+func NewSessionManager(r *gin.Engine, config *Config) *cfSessionManager {
 	self := &cfSessionManager{
 		session: scs.New(),
 	}
-	self.session.Lifetime = (24 * 365) * time.Hour
+	self.session.Lifetime = config.sessionLifetime
+
+	if config.sessionStore == "db" {
+		self.session.Store = &dbSessionStore{}
+		if config.sessionCleanup > 0 {
+			go purgeSessions(config.sessionCleanup)
+		}
+	}
 
 	r.Use(self.Sessions())
-	//db, _ := sql.Open( "sqlite3", "sessions.db" )
-	//session.Store = sqlite3store.New( db )
 
 	return self
 }
 
+// scs store keeping sessions in the main database, so logins and provider
+// connections survive a restart
+type dbSessionStore struct{}
+
+func (self *dbSessionStore) Find(token string) ([]byte, bool, error) {
+	session := getSession(token)
+	if session == nil || time.Now().Unix() >= session.Expiry {
+		return nil, false, nil
+	}
+	return session.Data, true, nil
+}
+
+func (self *dbSessionStore) Commit(token string, data []byte, expiry time.Time) error {
+	return saveSession(&DbSession{
+		Token:  token,
+		Data:   data,
+		Expiry: expiry.Unix(),
+	})
+}
+
+func (self *dbSessionStore) Delete(token string) error {
+	return deleteSession(token)
+}
+
+func purgeSessions(interval time.Duration) {
+	for {
+		if count := deleteExpiredSessions(time.Now()); count > 0 {
+			log.WithFields(log.Fields{
+				"type":  "session_purge",
+				"count": count,
+			}).Info("Purged expired sessions")
+		}
+		time.Sleep(interval)
+	}
+}
+
 func (self *cfSessionManager) Sessions() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//fmt.Printf("Sessions")
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SharePermView    = "view"
+	SharePermControl = "control"
+)
+
+// What a share link grants. The link carries these claims signed with the
+// server's share secret, so nothing needs storing until a link is revoked.
+type ShareClaims struct {
+	Id      string `json:"id"`
+	Udid    string `json:"udid"`
+	Rid     string `json:"rid"`
+	Owner   string `json:"owner"`
+	Perm    string `json:"perm"`
+	Gen     int64  `json:"gen"`
+	Issued  int64  `json:"iat"`
+	Expires int64  `json:"exp"`
+}
+
+func newShareSecret(config *Config) []byte {
+	if config.shareSecret != "" {
+		return []byte(config.shareSecret)
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+func newShareId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (self *DevTracker) shareSig(payload string) string {
+	mac := hmac.New(sha256.New, self.shareSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (self *DevTracker) signShare(claims *ShareClaims) string {
+	data, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + self.shareSig(payload)
+}
+
+// Decode a share token; the error names why it can't be used
+func (self *DevTracker) checkShare(token string) (*ShareClaims, string) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(self.shareSig(parts[0]))) {
+		return nil, "invalid_share"
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, "invalid_share"
+	}
+	claims := &ShareClaims{}
+	if json.Unmarshal(data, claims) != nil {
+		return nil, "invalid_share"
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return nil, "share_expired"
+	}
+
+	// Revocations are kept in the db so they outlast a restart. Revoking
+	// every link of a session moves it to a new generation; links issued
+	// in an earlier one are dead.
+	if getShareRevocation(claims.Id) != nil {
+		return nil, "share_revoked"
+	}
+	if rev := getShareRevocation(claims.Rid); rev != nil && rev.Gen > claims.Gen {
+		return nil, "share_revoked"
+	}
+
+	rv := getReservationWithRid(claims.Rid)
+	if rv == nil || rv.Udid != claims.Udid || rv.User != claims.Owner {
+		return nil, "share_ended"
+	}
+	return claims, ""
+}
+
+// Revoke one share link of a session, or all of them when id is empty,
+// and drop the viewers that joined through it
+func (self *DevTracker) revokeShares(udid string, rid string, id string) {
+	now := time.Now().Unix()
+	deleteShareRevocations(now - int64(self.config.shareMaxTtl/time.Second))
+	if id == "" {
+		saveShareRevocation(rid, now, shareGen(rid)+1)
+	} else {
+		saveShareRevocation(id, now, 0)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for viewer := range self.viewers[udid] {
+		if viewer.share.Rid == rid && (id == "" || viewer.share.Id == id) {
+			viewer.close()
+			delete(self.viewers[udid], viewer)
+		}
+	}
+}
+
+// How many times every link of a session has been revoked
+func shareGen(rid string) int64 {
+	if rev := getShareRevocation(rid); rev != nil {
+		return rev.Gen
+	}
+	return 0
+}
+
+func shareToken(c *gin.Context) string {
+	if token := c.Query("key"); token != "" {
+		return token
+	}
+	return c.GetHeader("X-Share-Token")
+}
+
+// The share link a request came in through, if any
+func shareClaims(c *gin.Context) *ShareClaims {
+	claimsI, exists := c.Get("share")
+	if !exists {
+		return nil
+	}
+	return claimsI.(*ShareClaims)
+}
+
+// Let a request through on a valid share link, given as the key query
+// parameter or the X-Share-Token header
+func (self *DevHandler) NeedShareAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, reason := self.devTracker.checkShare(shareToken(c))
+		if claims == nil {
+			apiFail(c, http.StatusForbidden, reason)
+			c.Abort()
+			return
+		}
+		c.Set("share", claims)
+		c.Next()
+	}
+}
+
+// Guard device input. On a share link the link must be for the device and
+// grant control; otherwise the device must not be reserved by someone else.
+func (self *DevHandler) NeedControl() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		udid := c.Query("udid")
+		if udid == "" {
+			udid = c.PostForm("udid")
+		}
+
+		if claims := shareClaims(c); claims != nil {
+			if claims.Udid != udid {
+				apiFail(c, http.StatusForbidden, "wrong_device")
+				c.Abort()
+				return
+			}
+			if claims.Perm != SharePermControl {
+				apiFail(c, http.StatusForbidden, "view_only")
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		rv := getReservation(udid)
+		if rv != nil && rv.User != self.apiUser(c) {
+			c.JSON(http.StatusConflict, SApiError{
+				Success:    false,
+				Err:        "reserved",
+				ReservedBy: rv.User,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+type SShare struct {
+	Success bool   `json:"success" example:"true"`
+	Id      string `json:"id"      example:"3f2a9c0d1b7e4a65"`
+	Key     string `json:"key"`
+	Url     string `json:"url"`
+	Perm    string `json:"perm"    example:"view"`
+	Expires int64  `json:"expires" example:"1617900000"`
+}
+
+// @Summary Device - Share the reserved session
+// @Description Creates a signed link letting others watch the session, or with perm=control also send input. Links end with the reservation, at expiry, or when revoked from the notices websocket.
+// @Router /device/share [POST]
+// @Param udid formData string true "Device UDID"
+// @Param rid formData string true "Reservation id"
+// @Param perm formData string false "view (default) or control"
+// @Param ttl formData string false "Link lifetime, eg 30m; capped by share.maxTtl"
+// @Produce json
+// @Success 200 {object} SShare
+// @Failure 400 {object} SApiError
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleShare(c *gin.Context) {
+	udid := c.PostForm("udid")
+	rv := getReservationWithRid(c.PostForm("rid"))
+	if rv == nil || rv.Udid != udid {
+		apiFail(c, http.StatusNotFound, "unknown_reservation")
+		return
+	}
+	user := self.apiUser(c)
+	if rv.User != user {
+		apiFail(c, http.StatusForbidden, "not_reservation_owner")
+		return
+	}
+
+	perm := c.DefaultPostForm("perm", SharePermView)
+	if perm != SharePermView && perm != SharePermControl {
+		apiFail(c, http.StatusBadRequest, "bad_perm")
+		return
+	}
+
+	ttl := self.config.shareTtl
+	if ttlStr := c.PostForm("ttl"); ttlStr != "" {
+		var err error
+		ttl, err = time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			apiFail(c, http.StatusBadRequest, "bad_ttl")
+			return
+		}
+	}
+	if ttl > self.config.shareMaxTtl {
+		ttl = self.config.shareMaxTtl
+	}
+
+	now := time.Now()
+	claims := &ShareClaims{
+		Id:      newShareId(),
+		Udid:    udid,
+		Rid:     rv.Rid,
+		Owner:   user,
+		Perm:    perm,
+		Gen:     shareGen(rv.Rid),
+		Issued:  now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	}
+	key := self.devTracker.signShare(claims)
+
+	log.WithFields(log.Fields{
+		"type":  "share_create",
+		"udid":  censorUuid(udid),
+		"rid":   rv.Rid,
+		"share": claims.Id,
+		"perm":  perm,
+	}).Info("Session shared")
+
+	c.JSON(http.StatusOK, SShare{
+		Success: true,
+		Id:      claims.Id,
+		Key:     key,
+		Url:     "/share/watch?key=" + key,
+		Perm:    perm,
+		Expires: claims.Expires,
+	})
+}
+
+// @Summary Share - Watch a shared session
+// @Router /share/watch [GET]
+// @Param key query string true "Share key"
+func (self *DevHandler) showShareWatch(c *gin.Context) {
+	claims, reason := self.devTracker.checkShare(shareToken(c))
+	if claims == nil {
+		text := "This share link is not valid"
+		if reason == "share_expired" {
+			text = "This share link has expired"
+		} else if reason == "share_revoked" || reason == "share_ended" {
+			text = "This share link has ended"
+		}
+		c.HTML(http.StatusForbidden, "error", gin.H{
+			"text": text,
+		})
+		return
+	}
+	dev := getDevice(claims.Udid)
+	if dev == nil {
+		c.HTML(http.StatusNotFound, "error", gin.H{
+			"text": "Unknown device",
+		})
+		return
+	}
+
+	c.HTML(http.StatusOK, "devWatch", gin.H{
+		"udid":        claims.Udid,
+		"key":         shareToken(c),
+		"owner":       claims.Owner,
+		"control":     claims.Perm == SharePermControl,
+		"name":        dev.Name,
+		"vidWidth":    dev.Width,
+		"vidHeight":   dev.Height,
+		"clickWidth":  dev.ClickWidth,
+		"clickHeight": dev.ClickHeight,
+		"maxHeight":   self.config.maxHeight,
+	})
+}
+
+type ShareNotice struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Id     string `json:"id,omitempty"`
+	Err    string `json:"error,omitempty"`
+}
+
+func (self *ShareNotice) asBytes() []byte {
+	data, _ := json.Marshal(self)
+	return data
+}
+
+// Handle a {"type":"revokeShare","id":...} message from the notices
+// websocket of the session owner; an empty id revokes every link
+func (self *DevHandler) handleShareRevoke(c *gin.Context, noticeConn *NoticeConn, udid string, rid string, id string) {
+	rv := getReservationWithRid(rid)
+	if rv == nil || rv.Udid != udid || rv.User != self.apiUser(c) {
+		noticeConn.send((&ShareNotice{
+			Type:   "share",
+			Status: "error",
+			Id:     id,
+			Err:    "not_reservation_owner",
+		}).asBytes())
+		return
+	}
+
+	self.devTracker.revokeShares(udid, rid, id)
+
+	log.WithFields(log.Fields{
+		"type":  "share_revoke",
+		"udid":  censorUuid(udid),
+		"rid":   rid,
+		"share": id,
+	}).Info("Session share revoked")
+
+	noticeConn.send((&ShareNotice{
+		Type:   "share",
+		Status: "revoked",
+		Id:     id,
+	}).asBytes())
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testShareTracker(t *testing.T) (*DevTracker, *ShareClaims) {
+	useTestDb(t, new(DbReservation), new(DbShareRevocation))
+	_, err := gDb.Insert(&DbReservation{Udid: "dev1", User: "bob", Rid: "rid1", Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devTracker := NewDevTracker(&Config{
+		shareSecret: "secret",
+		shareMaxTtl: time.Hour,
+	})
+	now := time.Now()
+	claims := &ShareClaims{
+		Id:      "share1",
+		Udid:    "dev1",
+		Rid:     "rid1",
+		Owner:   "bob",
+		Perm:    SharePermView,
+		Issued:  now.Unix(),
+		Expires: now.Add(time.Hour).Unix(),
+	}
+	return devTracker, claims
+}
+
+func TestCheckShare(t *testing.T) {
+	devTracker, base := testShareTracker(t)
+	other := NewDevTracker(&Config{shareSecret: "other"})
+
+	tests := []struct {
+		name  string
+		token func() string
+		err   string
+	}{
+		{"valid", func() string {
+			return devTracker.signShare(base)
+		}, ""},
+		{"payload changed", func() string {
+			token := devTracker.signShare(base)
+			return "x" + token[1:]
+		}, "invalid_share"},
+		{"signature changed", func() string {
+			token := devTracker.signShare(base)
+			return token[:len(token)-1] + "x"
+		}, "invalid_share"},
+		{"signed with another secret", func() string {
+			return other.signShare(base)
+		}, "invalid_share"},
+		{"no signature", func() string {
+			return strings.Split(devTracker.signShare(base), ".")[0]
+		}, "invalid_share"},
+		{"empty", func() string {
+			return ""
+		}, "invalid_share"},
+		{"signed payload that isn't base64", func() string {
+			return "!!!." + devTracker.shareSig("!!!")
+		}, "invalid_share"},
+		{"signed payload that isn't json", func() string {
+			return "bm9wZQ." + devTracker.shareSig("bm9wZQ")
+		}, "invalid_share"},
+		{"expired", func() string {
+			claims := *base
+			claims.Expires = time.Now().Add(-time.Second).Unix()
+			return devTracker.signShare(&claims)
+		}, "share_expired"},
+		{"reservation ended", func() string {
+			claims := *base
+			claims.Rid = "rid2"
+			return devTracker.signShare(&claims)
+		}, "share_ended"},
+		{"reservation passed to someone else", func() string {
+			claims := *base
+			claims.Owner = "alice"
+			return devTracker.signShare(&claims)
+		}, "share_ended"},
+		{"other device", func() string {
+			claims := *base
+			claims.Udid = "dev2"
+			return devTracker.signShare(&claims)
+		}, "share_ended"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := devTracker.checkShare(tt.token())
+			if err != tt.err {
+				t.Fatalf("got error %q, want %q", err, tt.err)
+			}
+			if err == "" && (claims == nil || claims.Id != base.Id) {
+				t.Errorf("got claims %+v, want those of %s", claims, base.Id)
+			}
+		})
+	}
+}
+
+func TestShareRevocation(t *testing.T) {
+	devTracker, base := testShareTracker(t)
+
+	issue := func(id string) string {
+		claims := *base
+		claims.Id = id
+		claims.Gen = shareGen(claims.Rid)
+		return devTracker.signShare(&claims)
+	}
+	check := func(name string, token string, want string) {
+		t.Helper()
+		if _, err := devTracker.checkShare(token); err != want {
+			t.Errorf("%s: got error %q, want %q", name, err, want)
+		}
+	}
+
+	before := issue("before")
+	devTracker.revokeShares("dev1", "rid1", "")
+	// Most likely issued in the same second as the revocation
+	after := issue("after")
+	check("issued before revoking all", before, "share_revoked")
+	check("issued after revoking all", after, "")
+
+	sibling := issue("sibling")
+	devTracker.revokeShares("dev1", "rid1", "after")
+	check("revoked by id", after, "share_revoked")
+	check("sibling of one revoked by id", sibling, "")
+
+	devTracker.revokeShares("dev1", "rid1", "")
+	check("issued before revoking all again", sibling, "share_revoked")
+	check("issued after revoking all again", issue("last"), "")
+
+	// Revocations live in the db, so a new tracker with the same secret
+	// still refuses the links
+	restarted := NewDevTracker(devTracker.config)
+	if _, err := restarted.checkShare(before); err != "share_revoked" {
+		t.Errorf("after restart: got error %q, want share_revoked", err)
+	}
+
+	// Pruning old revocations keeps the generation of a live session, or
+	// the next revoke-all would reuse one still carried by live links
+	deleteShareRevocations(time.Now().Add(time.Hour).Unix())
+	last := issue("last")
+	devTracker.revokeShares("dev1", "rid1", "")
+	check("issued before revoking all after pruning", last, "share_revoked")
+}
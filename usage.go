@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const usageDateFormat = "2006-01-02"
+
+type SUsageRow struct {
+	Key          string  `json:"key"          example:"00008100-001338811EE10033"`
+	Name         string  `json:"name"         example:"My Device"`
+	Reservations int     `json:"reservations" example:"14"`
+	Seconds      int64   `json:"seconds"      example:"5400"`
+	Utilization  float64 `json:"utilization"  example:"0.0625"`
+}
+
+type SUsageReport struct {
+	From string      `json:"from" example:"2021-06-01"`
+	To   string      `json:"to"   example:"2021-06-30"`
+	By   string      `json:"by"   example:"device"`
+	Rows []SUsageRow `json:"rows"`
+}
+
+type SHistoryRow struct {
+	Udid     string `json:"udid"     example:"00008100-001338811EE10033"`
+	User     string `json:"user"     example:"someuser"`
+	Rid      string `json:"rid"      example:"XVlBzgbaiC"`
+	Provider int64  `json:"provider" example:"1"`
+	Start    string `json:"start"    example:"2021-06-01 10:00:00"`
+	End      string `json:"end"      example:"2021-06-01 10:30:00"`
+	Reason   string `json:"reason"   example:"released"`
+}
+
+// Parse the from/to range of a report; defaults to the last 30 days. To is inclusive.
+func usageRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from := to.AddDate(0, 0, -29)
+
+	var err error
+	if str := c.Query("from"); str != "" {
+		from, err = time.ParseInLocation(usageDateFormat, str, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from date: %s", str)
+		}
+	}
+	if str := c.Query("to"); str != "" {
+		to, err = time.ParseInLocation(usageDateFormat, str, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date: %s", str)
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to is before from")
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// Clip a reservation to the report range; open reservations count up to now
+func clippedSeconds(row *DbReservationHistory, from time.Time, to time.Time) int64 {
+	start := row.Start
+	end := row.End
+	if row.Reason == "" {
+		end = time.Now()
+	}
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return -1
+	}
+	return int64(end.Sub(start).Seconds())
+}
+
+// Summarize reservation time per device, user, or provider over [from,to)
+func usageReport(by string, from time.Time, to time.Time) ([]SUsageRow, error) {
+	history, err := getReservationHistory()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := getDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	devNames := make(map[string]string)
+	provDevs := make(map[int64]int)
+	for _, dev := range devices {
+		devNames[dev.Udid] = dev.Name
+		provDevs[dev.ProviderId]++
+	}
+
+	rows := make(map[string]*SUsageRow)
+	for i := range history {
+		hist := &history[i]
+		secs := clippedSeconds(hist, from, to)
+		if secs < 0 {
+			continue
+		}
+
+		var key, name string
+		switch by {
+		case "user":
+			key = hist.User
+			name = hist.User
+		case "provider":
+			key = strconv.FormatInt(hist.ProviderId, 10)
+			name = key
+		default:
+			key = hist.Udid
+			name = devNames[hist.Udid]
+		}
+
+		row, exists := rows[key]
+		if !exists {
+			row = &SUsageRow{Key: key, Name: name}
+			rows[key] = row
+		}
+		row.Reservations++
+		row.Seconds += secs
+	}
+
+	// Utilization is the share of available device time that was reserved;
+	// it isn't meaningful per user, where one person can hold many devices
+	span := to.Sub(from).Seconds()
+	out := []SUsageRow{}
+	for _, row := range rows {
+		switch by {
+		case "device":
+			row.Utilization = float64(row.Seconds) / span
+		case "provider":
+			provId, _ := strconv.ParseInt(row.Key, 10, 64)
+			if provDevs[provId] > 0 {
+				row.Utilization = float64(row.Seconds) / (span * float64(provDevs[provId]))
+			}
+		}
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Seconds > out[j].Seconds
+	})
+	return out, nil
+}
+
+func historyRows(from time.Time, to time.Time) ([]SHistoryRow, error) {
+	history, err := getReservationHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []SHistoryRow{}
+	for i := range history {
+		hist := &history[i]
+		if clippedSeconds(hist, from, to) < 0 {
+			continue
+		}
+		end := ""
+		if hist.Reason != "" {
+			end = hist.End.Format("2006-01-02 15:04:05")
+		}
+		out = append(out, SHistoryRow{
+			Udid:     hist.Udid,
+			User:     hist.User,
+			Rid:      hist.Rid,
+			Provider: hist.ProviderId,
+			Start:    hist.Start.Format("2006-01-02 15:04:05"),
+			End:      end,
+			Reason:   hist.Reason,
+		})
+	}
+	return out, nil
+}
+
+func usageBy(c *gin.Context) string {
+	by := c.DefaultQuery("by", "device")
+	switch by {
+	case "device", "user", "provider", "reservation":
+		return by
+	}
+	return ""
+}
+
+// @Summary Admin - Device usage report
+// @Router /admin/usage [GET]
+// @Param from query string false "First day, YYYY-MM-DD; defaults to 30 days ago"
+// @Param to query string false "Last day, YYYY-MM-DD; defaults to today"
+// @Param by query string false "device, user, or provider"
+func (self *AdminHandler) showUsage(c *gin.Context) {
+	from, to, err := usageRange(c)
+	by := usageBy(c)
+	if err == nil && (by == "" || by == "reservation") {
+		err = fmt.Errorf("by must be device, user, or provider")
+	}
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error", gin.H{
+			"text": err.Error(),
+		})
+		return
+	}
+
+	rows, err := usageReport(by, from, to)
+	if err != nil {
+		panic(err)
+	}
+
+	type usageLine struct {
+		SUsageRow
+		Hours   string
+		Percent string
+	}
+	lines := []usageLine{}
+	for _, row := range rows {
+		lines = append(lines, usageLine{
+			SUsageRow: row,
+			Hours:     fmt.Sprintf("%.1f", float64(row.Seconds)/3600),
+			Percent:   fmt.Sprintf("%.1f%%", row.Utilization*100),
+		})
+	}
+
+	c.HTML(http.StatusOK, "adminUsage", gin.H{
+		"from":        from.Format(usageDateFormat),
+		"to":          to.AddDate(0, 0, -1).Format(usageDateFormat),
+		"by":          by,
+		"rows":        lines,
+		"deviceVideo": self.config.text.deviceVideo,
+	})
+}
+
+// @Summary Admin - Export device usage
+// @Description by=reservation exports the individual reservations instead of totals
+// @Router /admin/usage/export [GET]
+// @Param from query string false "First day, YYYY-MM-DD; defaults to 30 days ago"
+// @Param to query string false "Last day, YYYY-MM-DD; defaults to today"
+// @Param by query string false "device, user, provider, or reservation"
+// @Param format query string false "json or csv"
+// @Produce json
+// @Success 200 {object} SUsageReport
+func (self *AdminHandler) handleUsageExport(c *gin.Context) {
+	from, to, err := usageRange(c)
+	by := usageBy(c)
+	if err == nil && by == "" {
+		err = fmt.Errorf("by must be device, user, provider, or reservation")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, SApiError{
+			Success: false,
+			Err:     err.Error(),
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	fromStr := from.Format(usageDateFormat)
+	toStr := to.AddDate(0, 0, -1).Format(usageDateFormat)
+
+	var records [][]string
+	if by == "reservation" {
+		hist, err := historyRows(from, to)
+		if err != nil {
+			panic(err)
+		}
+		if format != "csv" {
+			c.JSON(http.StatusOK, hist)
+			return
+		}
+		records = append(records, []string{"udid", "user", "rid", "provider", "start", "end", "reason"})
+		for _, h := range hist {
+			records = append(records, []string{h.Udid, h.User, h.Rid, strconv.FormatInt(h.Provider, 10), h.Start, h.End, h.Reason})
+		}
+	} else {
+		rows, err := usageReport(by, from, to)
+		if err != nil {
+			panic(err)
+		}
+		if format != "csv" {
+			c.JSON(http.StatusOK, SUsageReport{
+				From: fromStr,
+				To:   toStr,
+				By:   by,
+				Rows: rows,
+			})
+			return
+		}
+		records = append(records, []string{by, "name", "reservations", "seconds", "utilization"})
+		for _, row := range rows {
+			records = append(records, []string{
+				row.Key,
+				row.Name,
+				strconv.Itoa(row.Reservations),
+				strconv.FormatInt(row.Seconds, 10),
+				strconv.FormatFloat(row.Utilization, 'f', 4, 64),
+			})
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%s-%s-%s.csv", by, fromStr, toStr))
+	c.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(c.Writer)
+	w.WriteAll(records)
+}
@@ -4,7 +4,11 @@ import (
     "fmt"
     "net/http"
     "encoding/json"
+    "strconv"
+    "strings"
+    "time"
     "github.com/gin-gonic/gin"
+    uj "github.com/nanoscopic/ujsonin/v2/mod"
     cfauth "github.com/nanoscopic/controlfloor_auth"
     //log "github.com/sirupsen/logrus"
 )
@@ -51,6 +55,26 @@ func (self *UserHandler) NeedUserAuth( authHandler cfauth.AuthHandler ) gin.Hand
     return func( c *gin.Context ) {
         sCtx := self.sessionManager.GetSession( c )
         
+        // API tokens stand in for a login; the user is set on this request's session only
+        if token := bearerToken( c ); token != "" {
+            dbToken := checkApiToken( c, token, false )
+            if dbToken == nil {
+                return
+            }
+            role := tokenRole( self.config, dbToken )
+            if !roleHas( role, PermView ) {
+                c.AbortWithStatusJSON( http.StatusForbidden, SApiError{
+                    Success: false,
+                    Err:     "user_disabled",
+                } )
+                return
+            }
+            self.sessionManager.session.Put( sCtx, "user", dbToken.User )
+            c.Set( "role", role )
+            c.Next()
+            return
+        }
+        
         loginI := self.sessionManager.session.Get( sCtx, "user" )
         
         if loginI == nil {
@@ -63,31 +87,176 @@ func (self *UserHandler) NeedUserAuth( authHandler cfauth.AuthHandler ) gin.Hand
             c.Abort()
             fmt.Println("user fail")
             return
-        } else {
-            //fmt.Println("user ok")
         }
         
+        // Roles are looked up on every request so changes apply at once
+        role := userRole( loginI.(string), self.config.defaultRole )
+        if !roleHas( role, PermView ) {
+            self.sessionManager.session.Remove( sCtx, "user" )
+            self.sessionManager.WriteSession( c )
+            c.Redirect( 302, "/login" )
+            c.Abort()
+            return
+        }
+        c.Set( "role", role )
+        
         c.Next()
     }
 }
 
 type SDevice struct {
-    Udid        string `json:"udid"   example:"00008100-001338811EE10033"`
-    Name        string `json:"name"   example:"My Device"`
+    Udid            string `json:"udid"                      example:"00008100-001338811EE10033"`
+    Name            string `json:"name"                      example:"My Device"`
+    Provider        int64  `json:"provider"                  example:"1"`
+    Online          bool   `json:"online"                    example:"true"`
+    WdaStatus       string `json:"wdaStatus"                 example:"up"`
+    CfaStatus       string `json:"cfaStatus"                 example:"up"`
+    VideoStatus     string `json:"videoStatus"               example:"up"`
+    Orientation     string `json:"orientation"               example:"portrait"`
+    ProductType     string `json:"productType"               example:"iPhone13,2"`
+    IosVersion      string `json:"iosVersion"                example:"14.2.1"`
+    ProviderState   string `json:"providerState,omitempty"   example:"healthy"`
+    ProviderPingMs  int64  `json:"providerPingMs,omitempty"  example:"15"`
+    ReservedBy      string `json:"reservedBy,omitempty"      example:"someuser"`
+    ReservedSeconds int64  `json:"reservedSeconds,omitempty" example:"120"`
+}
+
+// Pull a string field out of the device info json a provider reported
+func devInfoField( info uj.JNode, key string ) string {
+    if info == nil {
+        return ""
+    }
+    node := info.Get( key )
+    if node == nil {
+        return ""
+    }
+    return node.String()
+}
+
+// Criteria for picking devices; zero values match everything
+type DevFilter struct {
+    udid        string
+    status      string
+    provider    int64
+    productType string
+    iosVersion  string
+    freeOnly    bool
+}
+
+func (self *DevFilter) matches( dev *SDevice ) bool {
+    if self.udid != "" && dev.Udid != self.udid { return false }
+    if self.status == "online" && !dev.Online { return false }
+    if self.status == "offline" && dev.Online { return false }
+    if self.status == "healthy" && ( !dev.Online || provUnreliable( dev.ProviderState ) ) { return false }
+    if self.provider != 0 && dev.Provider != self.provider { return false }
+    if self.productType != "" && dev.ProductType != self.productType { return false }
+    if self.iosVersion != "" && dev.IosVersion != self.iosVersion && !strings.HasPrefix( dev.IosVersion, self.iosVersion + "." ) { return false }
+    if self.freeOnly && ( !dev.Online || dev.ReservedBy != "" ) { return false }
+    return true
+}
+
+// List registered devices merged with their live state, keeping those the filter matches
+func filterDevices( devTracker *DevTracker, filter *DevFilter ) ( []SDevice, error ) {
+    devices, err := getDevices()
+    if err != nil {
+        return nil, err
+    }
+    
+    rs, _ := getReservations()
+    if rs == nil {
+        rs = make( map[string]DbReservation )
+    }
+    
+    devsOut := []SDevice{}
+    
+    for _, device := range devices {
+        udid := device.Udid
+        
+        var info uj.JNode
+        if device.JsonInfo != "" {
+            root, _, err := uj.ParseFull( []byte( device.JsonInfo ) )
+            if err == nil {
+                info = root
+            }
+        }
+        
+        provId := devTracker.getDevProvId( udid )
+        wdaUp, cfaUp, videoUp := devStatusStrings( devTracker.getDevStatus( udid ) )
+        
+        dev := SDevice{
+            Udid:        udid,
+            Name:        device.Name,
+            Provider:    provId,
+            Online:      provId != 0,
+            WdaStatus:   wdaUp,
+            CfaStatus:   cfaUp,
+            VideoStatus: videoUp,
+            Orientation: devTracker.devOrientation( udid ),
+            ProductType: devInfoField( info, "ProductType" ),
+            IosVersion:  devInfoField( info, "ProductVersion" ),
+        }
+        
+        if health := devTracker.devProvHealth( udid ); health != nil {
+            stat := health.snapshot()
+            dev.ProviderState = stat.State
+            dev.ProviderPingMs = stat.AvgLatencyMs
+        } else if devTracker.devReconnecting( udid ) {
+            dev.ProviderState = ProvReconnecting
+        }
+        
+        if r, hasR := rs[ udid ]; hasR {
+            dev.ReservedBy = r.User
+            if !r.Start.IsZero() {
+                dev.ReservedSeconds = int64( time.Since( r.Start ).Seconds() )
+            }
+        }
+        
+        if !filter.matches( &dev ) {
+            continue
+        }
+        devsOut = append( devsOut, dev )
+    }
+    
+    return devsOut, nil
 }
 
 // @Summary Device list
+// @Description Registered devices merged with their live state
 // @Router /device/list [GET]
+// @Param status query string false "online, offline, or healthy for online devices whose provider isn't degraded or reconnecting"
+// @Param provider query int false "Provider id"
+// @Param productType query string false "Product type, e.g. iPhone13,2"
+// @Param iosVersion query string false "iOS version prefix, e.g. 14 or 14.2"
+// @Param free query bool false "Only online devices nobody has reserved"
+// @Produce json
+// @Success 200 {array} SDevice
 func (self *UserHandler) showDeviceList( c *gin.Context ) {
-    //devices, err := getDevices()
-    //if err != nil { panic( err ) }
-    
-    devsOut := []SDevice{}
+    filter := DevFilter{
+        status:      c.Query("status"),
+        productType: c.Query("productType"),
+        iosVersion:  c.Query("iosVersion"),
+        freeOnly:    c.Query("free") == "true" || c.Query("free") == "1",
+    }
+    if provStr := c.Query("provider"); provStr != "" {
+        provId, err := strconv.ParseInt( provStr, 10, 64 )
+        if err != nil {
+            c.JSON( http.StatusBadRequest, SApiError{
+                Success: false,
+                Err:     "invalid provider id",
+            } )
+            return
+        }
+        filter.provider = provId
+    }
     
-    devsOut = append( devsOut, SDevice{
-        Udid: "udid",
-        Name: "my dev",
-    } )
+    devsOut, err := filterDevices( self.devTracker, &filter )
+    if err != nil {
+        c.JSON( http.StatusInternalServerError, SApiError{
+            Success: false,
+            Err:     err.Error(),
+        } )
+        return
+    }
     
     c.JSON( http.StatusOK, devsOut )
 }
@@ -144,6 +313,14 @@ func (self *UserHandler) showUserRoot( c *gin.Context ) {
             device.Ready = "No"
         }
         
+        if health := self.devTracker.devProvHealth( udid ); health != nil {
+            stat := health.snapshot()
+            device.ProviderState = stat.State
+            device.ProviderPingMs = stat.AvgLatencyMs
+        } else if self.devTracker.devReconnecting( udid ) {
+            device.ProviderState = ProvReconnecting
+        }
+        
         t, _ := json.Marshal( device )
               
         jsont += string(t) + ","
@@ -195,17 +372,15 @@ func (self *UserHandler) handleUserLogin( c *gin.Context ) {
     user := c.PostForm("user")
     pass := c.PostForm("pass")
     
-    if user == "ok" && pass == "ok" {
-        fmt.Printf("login ok\n")
-        
-        self.sessionManager.session.Put( s, "user", "test" )
-        self.sessionManager.WriteSession( c )
-        
-        c.Redirect( 302, "/" )
+    dbUser, reason := checkUserLogin( user, pass )
+    if dbUser == nil {
+        recordUserLoginFail( c, user, reason, false )
+        self.showUserLogin( c )
         return
-    } else {
-        fmt.Printf("login failed\n")
     }
     
-    self.showUserLogin( c )
+    self.sessionManager.session.Put( s, "user", dbUser.Username )
+    self.sessionManager.WriteSession( c )
+    
+    c.Redirect( 302, "/" )
 }
\ No newline at end of file
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleTester = "tester"
+	RoleAdmin  = "admin"
+)
+
+const (
+	PermView        = "view"        // device list, info, screenshots, videos
+	PermControl     = "control"     // reserving, input, apps, sharing
+	PermDeviceAdmin = "deviceAdmin" // shutdown and app restrictions
+	PermAdmin       = "admin"       // admin pages and API
+)
+
+var rolePerms = map[string][]string{
+	RoleViewer: {PermView},
+	RoleTester: {PermView, PermControl},
+	RoleAdmin:  {PermView, PermControl, PermDeviceAdmin, PermAdmin},
+}
+
+var roleNames = []string{RoleViewer, RoleTester, RoleAdmin}
+
+func validRole(role string) bool {
+	_, ok := rolePerms[role]
+	return ok
+}
+
+func roleHas(role string, perm string) bool {
+	for _, p := range rolePerms[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// The role of a user; users not in the user table, such as those the auth
+// module lets in, get the fallback. Disabled users have no role.
+func userRole(username string, fallback string) string {
+	user := getUser(username)
+	if user == nil {
+		return fallback
+	}
+	if user.Disabled {
+		return ""
+	}
+	return user.Role
+}
+
+// The role an API token acts with; tokens of users outside the user table
+// keep working as before, with the admin flag standing for the admin role
+func tokenRole(config *Config, token *DbApiToken) string {
+	fallback := config.defaultRole
+	if token.Admin {
+		fallback = RoleAdmin
+	}
+	return userRole(token.User, fallback)
+}
+
+// The role NeedUserAuth or NeedAdminAuth found for the request
+func requestRole(c *gin.Context) string {
+	return c.GetString("role")
+}
+
+func hashUserPass(pass string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}
+
+// Check a login against the user table; the error names why it is refused
+func checkUserLogin(username string, pass string) (*DbUser, string) {
+	user := getUser(username)
+	if user == nil {
+		return nil, "unknown_user"
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(pass)) != nil {
+		return nil, "bad_password"
+	}
+	if user.Disabled {
+		return nil, "disabled"
+	}
+	noteUserLogin(user.Id)
+	return user, ""
+}
+
+func recordUserLoginFail(c *gin.Context, username string, reason string, admin bool) {
+	log.WithFields(log.Fields{
+		"type":   "user_login_fail",
+		"user":   username,
+		"reason": reason,
+		"admin":  admin,
+		"ip":     c.ClientIP(),
+	}).Warn("User login failed")
+}
+
+func createUser(username string, pass string, role string) error {
+	if username == "" || pass == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	if !validRole(role) {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	if getUser(username) != nil {
+		return fmt.Errorf("user %s already exists", username)
+	}
+	addUser(&DbUser{
+		Username: username,
+		Password: hashUserPass(pass),
+		Role:     role,
+		Created:  time.Now(),
+	})
+
+	log.WithFields(log.Fields{
+		"type": "user_add",
+		"user": username,
+		"role": role,
+	}).Info("Created user")
+	return nil
+}
+
+func changeUserRole(username string, role string) error {
+	if !validRole(role) {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	user := getUser(username)
+	if user == nil {
+		return fmt.Errorf("no user %s", username)
+	}
+	updateUser(user.Id, &DbUser{Role: role}, "role")
+
+	log.WithFields(log.Fields{
+		"type": "user_role",
+		"user": username,
+		"role": role,
+	}).Info("Changed user role")
+	return nil
+}
+
+func changeUserPassword(username string, pass string) error {
+	if pass == "" {
+		return fmt.Errorf("password is required")
+	}
+	user := getUser(username)
+	if user == nil {
+		return fmt.Errorf("no user %s", username)
+	}
+	updateUser(user.Id, &DbUser{Password: hashUserPass(pass)}, "password")
+
+	log.WithFields(log.Fields{
+		"type": "user_password",
+		"user": username,
+	}).Info("Changed user password")
+	return nil
+}
+
+func changeUserDisabled(username string, disabled bool) error {
+	user := getUser(username)
+	if user == nil {
+		return fmt.Errorf("no user %s", username)
+	}
+	updateUser(user.Id, &DbUser{Disabled: disabled}, "disabled")
+
+	log.WithFields(log.Fields{
+		"type":     "user_disable",
+		"user":     username,
+		"disabled": disabled,
+	}).Info("Changed user status")
+	return nil
+}
+
+func printUser(user DbUser) {
+	fmt.Printf("Username: %s\nRole: %s\nDisabled: %t\nLast login: %s\n\n",
+		user.Username, user.Role, user.Disabled, formatTokenTime(user.LastLogin))
+}
+
+// Refuse a device action to users whose role lacks the permission
+func (self *DevHandler) NeedPerm(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role := requestRole(c); !roleHas(role, perm) {
+			log.WithFields(log.Fields{
+				"type": "permission_denied",
+				"user": self.apiUser(c),
+				"role": role,
+				"perm": perm,
+				"path": c.FullPath(),
+			}).Warn("Refused action the user's role doesn't allow")
+			c.AbortWithStatusJSON(http.StatusForbidden, SApiError{
+				Success: false,
+				Err:     "permission_denied",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+type SUserRow struct {
+	Id        int64
+	Username  string
+	Role      string
+	Disabled  bool
+	Created   string
+	LastLogin string
+}
+
+// @Summary Admin - User list
+// @Router /admin/users [GET]
+func (self *AdminHandler) showUsers(c *gin.Context) {
+	users, err := getUsers()
+	if err != nil {
+		panic(err)
+	}
+
+	rows := []SUserRow{}
+	for _, user := range users {
+		rows = append(rows, SUserRow{
+			Id:        user.Id,
+			Username:  user.Username,
+			Role:      user.Role,
+			Disabled:  user.Disabled,
+			Created:   formatTokenTime(user.Created),
+			LastLogin: formatTokenTime(user.LastLogin),
+		})
+	}
+
+	c.HTML(http.StatusOK, "adminUsers", gin.H{
+		"users":       rows,
+		"roles":       roleNames,
+		"defaultRole": self.config.defaultRole,
+		"deviceVideo": self.config.text.deviceVideo,
+	})
+}
+
+func (self *AdminHandler) userFormResult(c *gin.Context, err error) {
+	if err != nil {
+		c.HTML(http.StatusOK, "error", gin.H{
+			"text": err.Error(),
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// @Summary Admin - Create user
+// @Router /admin/users/create [POST]
+// @Param user formData string true "Username"
+// @Param pass formData string true "Password"
+// @Param role formData string true "viewer, tester, or admin"
+func (self *AdminHandler) handleUserCreate(c *gin.Context) {
+	self.userFormResult(c, createUser(c.PostForm("user"), c.PostForm("pass"), c.PostForm("role")))
+}
+
+// @Summary Admin - Change user role
+// @Router /admin/users/role [POST]
+// @Param user formData string true "Username"
+// @Param role formData string true "viewer, tester, or admin"
+func (self *AdminHandler) handleUserRole(c *gin.Context) {
+	self.userFormResult(c, changeUserRole(c.PostForm("user"), c.PostForm("role")))
+}
+
+// @Summary Admin - Set user password
+// @Router /admin/users/password [POST]
+// @Param user formData string true "Username"
+// @Param pass formData string true "New password"
+func (self *AdminHandler) handleUserPassword(c *gin.Context) {
+	self.userFormResult(c, changeUserPassword(c.PostForm("user"), c.PostForm("pass")))
+}
+
+// @Summary Admin - Disable or enable user
+// @Router /admin/users/disable [POST]
+// @Param user formData string true "Username"
+// @Param disabled formData bool true "Whether the user is disabled"
+func (self *AdminHandler) handleUserDisable(c *gin.Context) {
+	disabled, _ := strconv.ParseBool(c.PostForm("disabled"))
+	self.userFormResult(c, changeUserDisabled(c.PostForm("user"), disabled))
+}
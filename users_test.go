@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoleHas(t *testing.T) {
+	perms := []string{PermView, PermControl, PermDeviceAdmin, PermAdmin}
+	tests := []struct {
+		role    string
+		allowed []bool // in the order of perms
+	}{
+		{RoleViewer, []bool{true, false, false, false}},
+		{RoleTester, []bool{true, true, false, false}},
+		{RoleAdmin, []bool{true, true, true, true}},
+		{"", []bool{false, false, false, false}},
+		{"root", []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		for i, perm := range perms {
+			if got := roleHas(tt.role, perm); got != tt.allowed[i] {
+				t.Errorf("role %q perm %s: got %v, want %v", tt.role, perm, got, tt.allowed[i])
+			}
+		}
+	}
+	if roleHas(RoleAdmin, "unknown") {
+		t.Errorf("admin has a permission that doesn't exist")
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	useTestDb(t, new(DbUser))
+	gDb.Insert(&DbUser{Username: "vera", Role: RoleViewer})
+	gDb.Insert(&DbUser{Username: "tom", Role: RoleTester})
+	gDb.Insert(&DbUser{Username: "ada", Role: RoleAdmin})
+	gDb.Insert(&DbUser{Username: "dan", Role: RoleAdmin, Disabled: true})
+
+	tests := []struct {
+		name     string
+		user     string
+		fallback string
+		role     string
+	}{
+		{"viewer", "vera", RoleTester, RoleViewer},
+		{"tester", "tom", RoleViewer, RoleTester},
+		{"admin", "ada", "", RoleAdmin},
+		{"disabled", "dan", RoleTester, ""},
+		{"outside the table", "olive", RoleTester, RoleTester},
+		{"outside the table without a default", "olive", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userRole(tt.user, tt.fallback); got != tt.role {
+				t.Errorf("got %q, want %q", got, tt.role)
+			}
+		})
+	}
+}
+
+func TestTokenRole(t *testing.T) {
+	useTestDb(t, new(DbUser))
+	gDb.Insert(&DbUser{Username: "vera", Role: RoleViewer})
+	gDb.Insert(&DbUser{Username: "dan", Role: RoleAdmin, Disabled: true})
+	config := &Config{defaultRole: RoleViewer}
+
+	tests := []struct {
+		name  string
+		token DbApiToken
+		role  string
+	}{
+		{"user token outside the table", DbApiToken{User: "olive"}, RoleViewer},
+		{"admin token outside the table", DbApiToken{User: "olive", Admin: true}, RoleAdmin},
+		{"admin token of a viewer", DbApiToken{User: "vera", Admin: true}, RoleViewer},
+		{"admin token of a disabled user", DbApiToken{User: "dan", Admin: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenRole(config, &tt.token); got != tt.role {
+				t.Errorf("got %q, want %q", got, tt.role)
+			}
+		})
+	}
+}
+
+func TestNeedPerm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := &DevHandler{sessionManager: NewSessionManager(r, &Config{})}
+	r.GET("/:role/:perm", func(c *gin.Context) {
+		// NeedUserAuth would have set the role
+		c.Set("role", c.Param("role"))
+		handler.NeedPerm(c.Param("perm"))(c)
+		if !c.IsAborted() {
+			c.String(http.StatusOK, "ok")
+		}
+	})
+
+	perms := []string{PermView, PermControl, PermDeviceAdmin, PermAdmin}
+	for _, role := range append(roleNames, "none") {
+		for _, perm := range perms {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/"+role+"/"+perm, nil))
+			want := http.StatusForbidden
+			if roleHas(role, perm) {
+				want = http.StatusOK
+			}
+			if w.Code != want {
+				t.Errorf("role %s perm %s: got status %d, want %d", role, perm, w.Code, want)
+			}
+		}
+	}
+}
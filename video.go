@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Writes the frames of one reservation's video. <rid>.mjpeg is the JPEG frames
+// back to back, which ffmpeg and VLC play as MJPEG. <rid>.idx has a line per
+// frame: server time in unix milliseconds, byte offset, and size.
+type VideoWriter struct {
+	rec    *DbVideoRecording
+	video  *os.File
+	index  *os.File
+	offset int64
+	users  int
+	lock   *sync.Mutex
+}
+
+// Taps the provider image relay and keeps video per reservation on disk
+type VideoRecorder struct {
+	record    bool
+	dir       string
+	retention time.Duration
+	writers   map[string]*VideoWriter
+	lock      *sync.Mutex
+}
+
+var gVideo *VideoRecorder
+
+func startVideoRecorder(config *Config) {
+	gVideo = &VideoRecorder{
+		record:    config.recordVideo,
+		dir:       config.videoDir,
+		retention: config.videoRetention,
+		writers:   make(map[string]*VideoWriter),
+		lock:      &sync.Mutex{},
+	}
+	if gVideo.record {
+		err := os.MkdirAll(gVideo.dir, 0755)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"type":  "video_dir",
+				"dir":   gVideo.dir,
+				"error": err,
+			}).Error("Could not create video directory; not recording video")
+			gVideo.record = false
+		}
+	}
+	if gVideo.retention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		for {
+			gVideo.expire()
+			<-ticker.C
+		}
+	}()
+}
+
+func (self *VideoRecorder) videoPath(rid string) string {
+	return filepath.Join(self.dir, rid+".mjpeg")
+}
+
+func (self *VideoRecorder) indexPath(rid string) string {
+	return filepath.Join(self.dir, rid+".idx")
+}
+
+// Start or join the recording of a reservation's video; nil if it isn't being recorded.
+// Streams reopened within the same reservation append to the same files.
+func (self *VideoRecorder) open(udid string, rid string) *VideoWriter {
+	if self == nil || !self.record || rid == "" {
+		return nil
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if writer, exists := self.writers[rid]; exists {
+		writer.users++
+		return writer
+	}
+
+	rv := getReservationWithRid(rid)
+	if rv == nil {
+		return nil
+	}
+
+	video, err := os.OpenFile(self.videoPath(rid), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Printf("Error opening video file: %s\n", err)
+		return nil
+	}
+	index, err := os.OpenFile(self.indexPath(rid), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Printf("Error opening video index: %s\n", err)
+		video.Close()
+		return nil
+	}
+	var offset int64
+	if info, err := video.Stat(); err == nil {
+		offset = info.Size()
+	}
+
+	rec := getVideoRecordingWithRid(rid)
+	if rec == nil {
+		rec = &DbVideoRecording{
+			Rid:   rid,
+			Udid:  udid,
+			User:  rv.User,
+			Start: time.Now(),
+		}
+		addVideoRecording(rec)
+	}
+	rec.End = time.Time{}
+	updateVideoRecording(rec)
+
+	writer := &VideoWriter{
+		rec:    rec,
+		video:  video,
+		index:  index,
+		offset: offset,
+		users:  1,
+		lock:   &sync.Mutex{},
+	}
+	self.writers[rid] = writer
+
+	log.WithFields(log.Fields{
+		"type": "video_record_start",
+		"udid": censorUuid(udid),
+		"rid":  rid,
+	}).Info("Recording device video")
+
+	return writer
+}
+
+// Leave a recording; the last stream out finishes the files
+func (self *VideoRecorder) close(writer *VideoWriter) {
+	if self == nil || writer == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	writer.users--
+	if writer.users > 0 {
+		return
+	}
+	writer.finish()
+	delete(self.writers, writer.rec.Rid)
+}
+
+func (self *VideoWriter) writeFrame(frame []byte) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.video == nil {
+		return
+	}
+
+	stamp := time.Now().UnixMilli()
+	n, err := self.video.Write(frame)
+	if err == nil {
+		_, err = fmt.Fprintf(self.index, "%d %d %d\n", stamp, self.offset, n)
+	}
+	if err != nil {
+		fmt.Printf("Error writing video frame; recording stopped: %s\n", err)
+		self.closeFiles()
+		return
+	}
+	self.offset += int64(n)
+	self.rec.Frames++
+	self.rec.Bytes += int64(n)
+}
+
+func (self *VideoWriter) closeFiles() {
+	if self.video == nil {
+		return
+	}
+	self.video.Close()
+	self.index.Close()
+	self.video = nil
+	self.index = nil
+}
+
+func (self *VideoWriter) finish() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.closeFiles()
+	self.rec.End = time.Now()
+	updateVideoRecording(self.rec)
+
+	log.WithFields(log.Fields{
+		"type":   "video_record_end",
+		"udid":   censorUuid(self.rec.Udid),
+		"rid":    self.rec.Rid,
+		"frames": self.rec.Frames,
+	}).Info("Finished recording device video")
+}
+
+// Delete a recording and its files; fails while a stream is still writing to it
+func (self *VideoRecorder) remove(rec *DbVideoRecording) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if _, active := self.writers[rec.Rid]; active {
+		return fmt.Errorf("recording in progress")
+	}
+	for _, path := range []string{self.videoPath(rec.Rid), self.indexPath(rec.Rid)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	deleteVideoRecording(rec.Id)
+	return nil
+}
+
+// Delete recordings that ended longer ago than the retention period
+func (self *VideoRecorder) expire() {
+	recs, err := getVideoRecordings("")
+	if err != nil {
+		fmt.Printf("Error listing video recordings: %s\n", err)
+		return
+	}
+	for i := range recs {
+		rec := &recs[i]
+		// A recording left open by a restart never got an end time
+		end := rec.End
+		if end.IsZero() {
+			end = rec.Start
+		}
+		if time.Since(end) < self.retention {
+			continue
+		}
+		if self.remove(rec) == nil {
+			log.WithFields(log.Fields{
+				"type": "video_expire",
+				"rid":  rec.Rid,
+			}).Info("Deleted expired video recording")
+		}
+	}
+}
+
+func (self *VideoRecorder) active(rid string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	_, active := self.writers[rid]
+	return active
+}
+
+type SVideoRecording struct {
+	Id        int64  `json:"id"        example:"3"`
+	Rid       string `json:"rid"       example:"XVlBzgbaiC"`
+	Udid      string `json:"udid"      example:"00008100-001338811EE10033"`
+	User      string `json:"user"      example:"someuser"`
+	Start     string `json:"start"     example:"2021-06-01 10:00:00"`
+	End       string `json:"end"       example:"2021-06-01 10:30:00"`
+	Frames    int64  `json:"frames"    example:"9000"`
+	Bytes     int64  `json:"bytes"     example:"450000000"`
+	Recording bool   `json:"recording" example:"false"`
+}
+
+func toVideoRecording(rec *DbVideoRecording) SVideoRecording {
+	end := ""
+	if !rec.End.IsZero() {
+		end = rec.End.Format("2006-01-02 15:04:05")
+	}
+	return SVideoRecording{
+		Id:        rec.Id,
+		Rid:       rec.Rid,
+		Udid:      rec.Udid,
+		User:      rec.User,
+		Start:     rec.Start.Format("2006-01-02 15:04:05"),
+		End:       end,
+		Frames:    rec.Frames,
+		Bytes:     rec.Bytes,
+		Recording: gVideo.active(rec.Rid),
+	}
+}
+
+func videoRecordingList(user string) ([]SVideoRecording, error) {
+	recs, err := getVideoRecordings(user)
+	if err != nil {
+		return nil, err
+	}
+	out := []SVideoRecording{}
+	for i := range recs {
+		out = append(out, toVideoRecording(&recs[i]))
+	}
+	return out, nil
+}
+
+// Send the video of a recording, or its frame index when part is "index"
+func sendVideoFile(c *gin.Context, rec *DbVideoRecording, part string) {
+	path := gVideo.videoPath(rec.Rid)
+	name := fmt.Sprintf("%s-%s.mjpeg", rec.Udid, rec.Rid)
+	if part == "index" {
+		path = gVideo.indexPath(rec.Rid)
+		name = fmt.Sprintf("%s-%s.idx", rec.Udid, rec.Rid)
+	}
+	if _, err := os.Stat(path); err != nil {
+		apiFail(c, http.StatusNotFound, "video_file_missing")
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+func (self *DevHandler) registerVideoRoutes() {
+	uAuth := self.userAuthGroup
+
+	uAuth.GET("/videos", self.showVideos)
+	uAuth.GET("/videos/:id", self.handleVideoDownload)
+	uAuth.DELETE("/videos/:id", self.NeedPerm(PermControl), self.handleVideoDelete)
+}
+
+// Fetch the video recording a request names, replying 404 or 403 if the caller can't use it
+func (self *DevHandler) ownVideo(c *gin.Context) *DbVideoRecording {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apiFail(c, http.StatusNotFound, "unknown_video")
+		return nil
+	}
+	rec := getVideoRecording(id)
+	if rec == nil {
+		apiFail(c, http.StatusNotFound, "unknown_video")
+		return nil
+	}
+	if rec.User != self.apiUser(c) {
+		apiFail(c, http.StatusForbidden, "not_video_owner")
+		return nil
+	}
+	return rec
+}
+
+// @Summary Video - List your video recordings
+// @Router /videos [GET]
+// @Security ApiToken
+// @Produce json
+// @Success 200 {array} SVideoRecording
+func (self *DevHandler) showVideos(c *gin.Context) {
+	out, err := videoRecordingList(self.apiUser(c))
+	if err != nil {
+		apiFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// @Summary Video - Download a video recording
+// @Description The video is MJPEG: JPEG frames back to back. part=index instead returns one line per frame
+// @Description with its server time in unix milliseconds, byte offset, and size.
+// @Router /videos/{id} [GET]
+// @Security ApiToken
+// @Param id path int true "Video recording id"
+// @Param part query string false "index for the frame index"
+// @Produce octet-stream
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleVideoDownload(c *gin.Context) {
+	rec := self.ownVideo(c)
+	if rec == nil {
+		return
+	}
+	sendVideoFile(c, rec, c.Query("part"))
+}
+
+// @Summary Video - Delete a video recording
+// @Router /videos/{id} [DELETE]
+// @Security ApiToken
+// @Param id path int true "Video recording id"
+// @Produce json
+// @Success 200 {object} SApiSuccess
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+// @Failure 409 {object} SApiError
+func (self *DevHandler) handleVideoDelete(c *gin.Context) {
+	rec := self.ownVideo(c)
+	if rec == nil {
+		return
+	}
+	err := gVideo.remove(rec)
+	if err != nil {
+		apiFail(c, http.StatusConflict, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+// @Summary Admin - Video recordings
+// @Router /admin/videos [GET]
+func (self *AdminHandler) showVideos(c *gin.Context) {
+	out, err := videoRecordingList("")
+	if err != nil {
+		panic(err)
+	}
+	c.HTML(http.StatusOK, "adminVideos", gin.H{
+		"videos":      out,
+		"record":      self.config.recordVideo,
+		"retention":   self.config.videoRetention.String(),
+		"deviceVideo": self.config.text.deviceVideo,
+	})
+}
+
+func adminVideo(c *gin.Context, idStr string) *DbVideoRecording {
+	id, _ := strconv.ParseInt(idStr, 10, 64)
+	rec := getVideoRecording(id)
+	if rec == nil {
+		c.HTML(http.StatusNotFound, "error", gin.H{
+			"text": "unknown video recording",
+		})
+	}
+	return rec
+}
+
+// @Summary Admin - Download video recording
+// @Router /admin/videos/download [GET]
+// @Param id query int true "Video recording id"
+// @Param part query string false "index for the frame index"
+// @Produce octet-stream
+func (self *AdminHandler) handleVideoDownload(c *gin.Context) {
+	rec := adminVideo(c, c.Query("id"))
+	if rec == nil {
+		return
+	}
+	sendVideoFile(c, rec, c.Query("part"))
+}
+
+// @Summary Admin - Delete video recording
+// @Router /admin/videos/delete [POST]
+// @Param id formData int true "Video recording id"
+func (self *AdminHandler) handleVideoDelete(c *gin.Context) {
+	rec := adminVideo(c, c.PostForm("id"))
+	if rec == nil {
+		return
+	}
+	err := gVideo.remove(rec)
+	if err != nil {
+		c.HTML(http.StatusConflict, "error", gin.H{
+			"text": err.Error(),
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/videos")
+}
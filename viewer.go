@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ws "github.com/gorilla/websocket"
+	uj "github.com/nanoscopic/ujsonin/v2/mod"
+	log "github.com/sirupsen/logrus"
+)
+
+// A watcher of a device's video: the reservation holder's own stream, or
+// someone joining through a share link. Frames pass through a one-slot
+// mailbox, so a slow viewer misses frames instead of holding up the relay.
+type VidViewer struct {
+	socket    *ws.Conn
+	offset    int64
+	share     *ShareClaims // nil for the reservation holder
+	user      string
+	frames    chan []byte
+	texts     chan []byte
+	done      chan bool
+	closeOnce *sync.Once
+	stats     *ViewerStats
+}
+
+func NewVidViewer(socket *ws.Conn, offset int64, share *ShareClaims, user string) *VidViewer {
+	self := &VidViewer{
+		socket:    socket,
+		offset:    offset,
+		share:     share,
+		user:      user,
+		frames:    make(chan []byte, 1),
+		texts:     make(chan []byte, 16),
+		done:      make(chan bool),
+		closeOnce: &sync.Once{},
+		stats:     &ViewerStats{},
+	}
+	socket.SetPongHandler(func(string) error {
+		self.stats.pong()
+		return nil
+	})
+	return self
+}
+
+// Hand over a frame without waiting, replacing one the viewer hasn't taken yet
+func (self *VidViewer) offer(frame []byte) {
+	for {
+		select {
+		case self.frames <- frame:
+			return
+		default:
+		}
+		select {
+		case <-self.frames:
+			self.stats.drop()
+		default:
+		}
+	}
+}
+
+// Queue a text message without waiting; dropped if the viewer is far behind
+func (self *VidViewer) offerText(text []byte) {
+	select {
+	case self.texts <- text:
+	default:
+	}
+}
+
+func (self *VidViewer) close() {
+	self.closeOnce.Do(func() {
+		close(self.done)
+	})
+}
+
+func (self *VidViewer) writeFrame(frame []byte) error {
+	nowMilli := time.Now().UnixMilli() + self.offset
+	err := self.socket.WriteMessage(ws.TextMessage, []byte(strconv.FormatInt(nowMilli, 10)))
+	if err != nil {
+		return err
+	}
+	toSend := make([]byte, 0, len(frame)+100)
+	toSend = append(toSend, frame...)
+	toSend = append(toSend, []byte(fmt.Sprintf("%*d", 100, time.Now().UnixMilli()+self.offset))...)
+	return self.socket.WriteMessage(ws.BinaryMessage, toSend)
+}
+
+// Write frames to the viewer until it leaves, its share link expires, or the
+// session it watches ends. Frames carry a trailing timestamp, and are paced
+// to what the viewer has shown it can take; frames arriving meanwhile
+// replace each other. Returns the error that cut the viewer off, if any.
+func (self *VidViewer) run() error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var nextFrame time.Time
+	for {
+		frames := self.frames
+		var paced <-chan time.Time
+		if wait := time.Until(nextFrame); wait > 0 {
+			frames = nil
+			paced = time.After(wait)
+		}
+
+		var err error
+		select {
+		case <-self.done:
+			self.flushTexts()
+			if self.share != nil {
+				self.socket.WriteMessage(ws.TextMessage, []byte(`{"type":"ended"}`))
+			}
+			return nil
+		case now := <-ticker.C:
+			if self.share != nil && now.Unix() >= self.share.Expires {
+				self.close()
+				continue
+			}
+			self.stats.ping()
+			err = self.socket.WriteControl(ws.PingMessage, nil, now.Add(time.Second))
+			if err == nil {
+				err = self.socket.WriteMessage(ws.TextMessage, []byte("ping"))
+			}
+		case text := <-self.texts:
+			err = self.socket.WriteMessage(ws.TextMessage, text)
+		case <-paced:
+		case frame := <-frames:
+			start := time.Now()
+			err = self.writeFrame(frame)
+			self.stats.sent(len(frame), time.Since(start))
+			nextFrame = start.Add(self.stats.interval())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (self *VidViewer) flushTexts() {
+	for {
+		select {
+		case text := <-self.texts:
+			self.socket.WriteMessage(ws.TextMessage, text)
+		default:
+			return
+		}
+	}
+}
+
+// Read what the viewer sends until it goes away; the viewer reports the
+// throughput it sees as {"bps":...,"avgFrame":...}
+func (self *VidViewer) readReports() {
+	for {
+		_, data, err := self.socket.ReadMessage()
+		if err != nil {
+			break
+		}
+		self.stats.report(data)
+	}
+	self.close()
+}
+
+// Delivery figures of one viewer. Throughput is the lower of what the
+// viewer reports and what frame writes to it achieve.
+type ViewerStats struct {
+	lock        sync.Mutex
+	frames      int64
+	dropped     int64
+	avgFrame    float64
+	writeBps    float64
+	reportedBps int64
+	rtt         time.Duration
+	pingAt      time.Time
+}
+
+// Reported rates at or above this mean the viewer isn't the bottleneck
+const viewerBpsCeiling = 10000000
+
+// Share of measured throughput frames are paced to, leaving headroom
+const viewerPaceShare = 0.75
+
+// Weight of a new sample in the moving averages
+const viewerAvgWeight = 0.2
+
+func movingAvg(avg float64, sample float64) float64 {
+	if avg == 0 {
+		return sample
+	}
+	return avg + (sample-avg)*viewerAvgWeight
+}
+
+func (self *ViewerStats) sent(size int, took time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.frames++
+	self.avgFrame = movingAvg(self.avgFrame, float64(size))
+	// Writes that finish at once only say the socket buffer had room
+	if took >= time.Millisecond {
+		self.writeBps = movingAvg(self.writeBps, float64(size)/took.Seconds())
+	}
+}
+
+func (self *ViewerStats) drop() {
+	self.lock.Lock()
+	self.dropped++
+	self.lock.Unlock()
+}
+
+func (self *ViewerStats) ping() {
+	self.lock.Lock()
+	self.pingAt = time.Now()
+	self.lock.Unlock()
+}
+
+func (self *ViewerStats) pong() {
+	self.lock.Lock()
+	if !self.pingAt.IsZero() {
+		self.rtt = time.Since(self.pingAt)
+	}
+	self.lock.Unlock()
+}
+
+func (self *ViewerStats) report(data []byte) {
+	root, _, err := uj.ParseFull(data)
+	if err != nil {
+		return
+	}
+	bpsNode := root.Get("bps")
+	if bpsNode == nil {
+		return
+	}
+	bps, _ := strconv.ParseInt(bpsNode.String(), 10, 64)
+	self.lock.Lock()
+	self.reportedBps = bps
+	self.lock.Unlock()
+}
+
+// Frames per second the viewer keeps up with; 0 when it isn't limited
+func (self *ViewerStats) maxFps() float64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	bps := self.writeBps
+	if self.reportedBps > 0 && self.reportedBps < viewerBpsCeiling && (bps == 0 || float64(self.reportedBps) < bps) {
+		bps = float64(self.reportedBps)
+	}
+	if bps == 0 || bps >= viewerBpsCeiling || self.avgFrame == 0 {
+		return 0
+	}
+	return bps / self.avgFrame * viewerPaceShare
+}
+
+// Least time between frames sent to the viewer
+func (self *ViewerStats) interval() time.Duration {
+	fps := self.maxFps()
+	if fps == 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+func (self *ViewerStats) logFields(fields log.Fields) log.Fields {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	fields["frames"] = self.frames
+	fields["dropped"] = self.dropped
+	fields["rttMs"] = self.rtt.Milliseconds()
+	return fields
+}
+
+// Join a viewer to a device; false unless the shared session is still streaming
+func (self *DevTracker) addViewer(udid string, viewer *VidViewer) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	vidConn, exists := self.vidConns[udid]
+	if !exists || vidConn.rid != viewer.share.Rid {
+		return false
+	}
+	if self.viewers[udid] == nil {
+		self.viewers[udid] = make(map[*VidViewer]bool)
+	}
+	self.viewers[udid][viewer] = true
+	return true
+}
+
+func (self *DevTracker) delViewer(udid string, viewer *VidViewer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.viewers[udid], viewer)
+}
+
+func (self *DevTracker) fanOutFrame(udid string, frame []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for viewer := range self.viewers[udid] {
+		viewer.offer(frame)
+	}
+}
+
+// The frame rate the fastest viewer of a device keeps up with, counting the
+// reservation holder; 0 if any viewer isn't limited
+func (self *DevTracker) fastestViewerFps(udid string, owner *VidViewer) float64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	best := owner.stats.maxFps()
+	if best == 0 {
+		return 0
+	}
+	for viewer := range self.viewers[udid] {
+		fps := viewer.stats.maxFps()
+		if fps == 0 {
+			return 0
+		}
+		if fps > best {
+			best = fps
+		}
+	}
+	return best
+}
+
+// Close viewers of sessions other than keepRid; the lock must be held
+func (self *DevTracker) endViewers(udid string, keepRid string) {
+	for viewer := range self.viewers[udid] {
+		if viewer.share.Rid != keepRid {
+			viewer.close()
+			delete(self.viewers[udid], viewer)
+		}
+	}
+}
+
+// @Description Share - Shared Image Stream Websocket
+// @Router /share/stream [GET]
+// @Param key query string true "Share key"
+func (self *DevHandler) handleShareStream(c *gin.Context) {
+	share := shareClaims(c)
+
+	conn, err := wsupgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(ws.TextMessage, timeStampMessage())
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	viewer := NewVidViewer(conn, parseTimeResult(data), share, self.apiUser(c))
+
+	if !self.devTracker.addViewer(share.Udid, viewer) {
+		conn.WriteMessage(ws.TextMessage, []byte(`{"type":"ended"}`))
+		return
+	}
+	defer self.devTracker.delViewer(share.Udid, viewer)
+
+	fields := log.Fields{
+		"type":   "viewer_start",
+		"udid":   censorUuid(share.Udid),
+		"rid":    share.Rid,
+		"share":  share.Id,
+		"viewer": viewer.user,
+	}
+	log.WithFields(fields).Info("Viewer joined shared video")
+
+	go viewer.readReports()
+
+	viewer.run()
+
+	fields["type"] = "viewer_end"
+	log.WithFields(viewer.stats.logFields(fields)).Info("Viewer left shared video")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hands freed devices to queued users in FIFO order. Queue entries live in
+// the waiter table; the notice sockets of users waiting on a page live here.
+type Waitlist struct {
+	devTracker *DevTracker
+	conns      map[int64]*NoticeConn
+	lock       *sync.Mutex
+	connLock   *sync.Mutex
+}
+
+func NewWaitlist(devTracker *DevTracker) *Waitlist {
+	return &Waitlist{
+		devTracker: devTracker,
+		conns:      make(map[int64]*NoticeConn),
+		lock:       &sync.Mutex{},
+		connLock:   &sync.Mutex{},
+	}
+}
+
+type WaitGrantNotice struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Udid   string `json:"udid"`
+	Rid    string `json:"rid"`
+}
+
+func waiterFilter(waiter *DbWaiter) *DevFilter {
+	return &DevFilter{
+		udid:        waiter.Udid,
+		status:      "online",
+		provider:    waiter.Provider,
+		productType: waiter.ProductType,
+		iosVersion:  waiter.IosVersion,
+	}
+}
+
+// Whether two waiters are queued for the same device or the same pool
+func sameQueue(a *DbWaiter, b *DbWaiter) bool {
+	return a.Udid == b.Udid &&
+		a.ProductType == b.ProductType &&
+		a.IosVersion == b.IosVersion &&
+		a.Provider == b.Provider
+}
+
+// Give a device that may have just become free to the first waiter it suits
+func (self *Waitlist) handOver(udid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if getReservation(udid) != nil {
+		return
+	}
+
+	devs, err := filterDevices(self.devTracker, &DevFilter{udid: udid})
+	if err != nil || len(devs) == 0 {
+		return
+	}
+	dev := devs[0]
+
+	waiters, err := getQueuedWaiters()
+	if err != nil {
+		return
+	}
+
+	for _, waiter := range waiters {
+		// Waiters for a specific udid take it even while it is offline; pools only want online devices
+		if waiter.Udid != udid && (waiter.Udid != "" || !waiterFilter(&waiter).matches(&dev)) {
+			continue
+		}
+
+		rid := RandStringBytes(10)
+		if !addReservation(udid, waiter.User, rid) {
+			return
+		}
+		grantWaiter(waiter.Id, udid, rid)
+
+		log.WithFields(log.Fields{
+			"type":   "waitlist_grant",
+			"udid":   censorUuid(udid),
+			"user":   waiter.User,
+			"waiter": waiter.Id,
+			"rid":    rid,
+		}).Info("Handed device to waiting user")
+
+		self.notify(waiter.Id, &WaitGrantNotice{
+			Type:   "reservation",
+			Status: "granted",
+			Udid:   udid,
+			Rid:    rid,
+		})
+		return
+	}
+}
+
+// Try every free device a newly queued waiter could take
+func (self *Waitlist) handOverFor(waiter *DbWaiter) {
+	filter := waiterFilter(waiter)
+	if waiter.Udid != "" {
+		filter.status = ""
+	}
+	filter.freeOnly = waiter.Udid == ""
+
+	devs, err := filterDevices(self.devTracker, filter)
+	if err != nil {
+		return
+	}
+	for _, dev := range devs {
+		self.handOver(dev.Udid)
+		cur := getWaiter(waiter.Id)
+		if cur == nil || cur.Rid != "" {
+			return
+		}
+	}
+}
+
+// 1-based place of a waiter among those queued for the same device or pool; 0 once granted
+func (self *Waitlist) position(waiter *DbWaiter) int {
+	if waiter.Rid != "" {
+		return 0
+	}
+	waiters, err := getQueuedWaiters()
+	if err != nil {
+		return 0
+	}
+	pos := 0
+	for _, other := range waiters {
+		if other.Id > waiter.Id {
+			break
+		}
+		if sameQueue(&other, waiter) {
+			pos++
+		}
+	}
+	return pos
+}
+
+func (self *Waitlist) setConn(id int64, conn *NoticeConn) {
+	self.connLock.Lock()
+	self.conns[id] = conn
+	self.connLock.Unlock()
+}
+
+func (self *Waitlist) delConn(id int64, conn *NoticeConn) {
+	self.connLock.Lock()
+	if self.conns[id] == conn {
+		delete(self.conns, id)
+	}
+	self.connLock.Unlock()
+}
+
+func (self *Waitlist) notify(id int64, notice *WaitGrantNotice) {
+	self.connLock.Lock()
+	conn := self.conns[id]
+	self.connLock.Unlock()
+	if conn == nil {
+		return
+	}
+
+	text, _ := json.Marshal(notice)
+	err := conn.send(text)
+	if err != nil {
+		fmt.Printf("Failed to notify waiter %d: %s\n", id, err)
+	}
+}
+
+type SWaiter struct {
+	Success  bool   `json:"success"       example:"true"`
+	Id       int64  `json:"id"            example:"12"`
+	Position int    `json:"position"      example:"2"`
+	Granted  bool   `json:"granted"       example:"false"`
+	Udid     string `json:"udid,omitempty" example:"00008100-001338811EE10033"`
+	Rid      string `json:"rid,omitempty"  example:"XVlBzgbaiC"`
+}
+
+func (self *DevHandler) registerWaitlistRoutes() {
+	uAuth := self.userAuthGroup
+
+	control := self.NeedPerm(PermControl)
+
+	uAuth.POST("/waitlist", control, self.handleWaitJoin)
+	uAuth.GET("/waitlist/:id", self.showWaiter)
+	uAuth.DELETE("/waitlist/:id", control, self.handleWaitLeave)
+}
+
+func (self *DevHandler) waiterRes(waiter *DbWaiter) SWaiter {
+	return SWaiter{
+		Success:  true,
+		Id:       waiter.Id,
+		Position: self.devTracker.waitlist.position(waiter),
+		Granted:  waiter.Rid != "",
+		Udid:     waiter.GrantedUdid,
+		Rid:      waiter.Rid,
+	}
+}
+
+// Fetch the waiter a request names, replying 404 or 403 if the caller can't act on it
+func (self *DevHandler) ownWaiter(c *gin.Context, idStr string) *DbWaiter {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		apiFail(c, http.StatusNotFound, "unknown_waiter")
+		return nil
+	}
+	waiter := getWaiter(id)
+	if waiter == nil {
+		apiFail(c, http.StatusNotFound, "unknown_waiter")
+		return nil
+	}
+	if waiter.User != self.apiUser(c) {
+		apiFail(c, http.StatusForbidden, "not_waiter_owner")
+		return nil
+	}
+	return waiter
+}
+
+// @Summary Waitlist - Join
+// @Description Queues for the given udid, or for any device matching the other fields when udid is empty.
+// @Description If a suitable device is free it is granted at once. Otherwise poll the waiter or
+// @Description listen on /device/notices?wait={id} for a "reservation" "granted" notice.
+// @Router /waitlist [POST]
+// @Security ApiToken
+// @Accept json
+// @Produce json
+// @Param request body SReserveRequest true "Device or pool criteria"
+// @Success 200 {object} SWaiter
+// @Failure 400 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleWaitJoin(c *gin.Context) {
+	var req SReserveRequest
+	if !apiBind(c, &req) {
+		return
+	}
+
+	if req.Udid != "" && getDevice(req.Udid) == nil {
+		apiFail(c, http.StatusNotFound, "unknown_udid")
+		return
+	}
+
+	waiter := &DbWaiter{
+		User:        self.apiUser(c),
+		Udid:        req.Udid,
+		ProductType: req.ProductType,
+		IosVersion:  req.IosVersion,
+		Provider:    req.Provider,
+		Created:     time.Now(),
+	}
+	addWaiter(waiter)
+
+	log.WithFields(log.Fields{
+		"type":   "waitlist_join",
+		"user":   waiter.User,
+		"waiter": waiter.Id,
+	}).Info("User joined waitlist")
+
+	self.devTracker.waitlist.handOverFor(waiter)
+
+	waiter = getWaiter(waiter.Id)
+	c.JSON(http.StatusOK, self.waiterRes(waiter))
+}
+
+// @Summary Waitlist - Position
+// @Router /waitlist/{id} [GET]
+// @Security ApiToken
+// @Param id path int true "Waiter id"
+// @Produce json
+// @Success 200 {object} SWaiter
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) showWaiter(c *gin.Context) {
+	waiter := self.ownWaiter(c, c.Param("id"))
+	if waiter == nil {
+		return
+	}
+	c.JSON(http.StatusOK, self.waiterRes(waiter))
+}
+
+// @Summary Waitlist - Leave
+// @Router /waitlist/{id} [DELETE]
+// @Security ApiToken
+// @Param id path int true "Waiter id"
+// @Produce json
+// @Success 200 {object} SApiSuccess
+// @Failure 403 {object} SApiError
+// @Failure 404 {object} SApiError
+func (self *DevHandler) handleWaitLeave(c *gin.Context) {
+	waiter := self.ownWaiter(c, c.Param("id"))
+	if waiter == nil {
+		return
+	}
+	deleteWaiter(waiter.Id)
+	c.JSON(http.StatusOK, SApiSuccess{Success: true})
+}
+
+func (self *DevHandler) handleWaitNotices(c *gin.Context, idStr string) {
+	waiter := self.ownWaiter(c, idStr)
+	if waiter == nil {
+		return
+	}
+
+	conn, err := wsupgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
+		return
+	}
+	noticeConn := NewNoticeConn(conn)
+
+	waitlist := self.devTracker.waitlist
+	waitlist.setConn(waiter.Id, noticeConn)
+	defer waitlist.delConn(waiter.Id, noticeConn)
+
+	// The device may have been handed over before the socket opened
+	waiter = getWaiter(waiter.Id)
+	if waiter != nil && waiter.Rid != "" {
+		waitlist.notify(waiter.Id, &WaitGrantNotice{
+			Type:   "reservation",
+			Status: "granted",
+			Udid:   waiter.GrantedUdid,
+			Rid:    waiter.Rid,
+		})
+	}
+
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+}